@@ -0,0 +1,18 @@
+package dto
+
+import "github.com/google/uuid"
+
+// DirectoryParamsDTO — поиск авторов и издательств по имени или любому из псевдонимов
+type DirectoryParamsDTO struct {
+	Name   string
+	Limit  uint
+	Offset int
+}
+
+// BookAuthorDTO — участник издания книги с ролью (автор, переводчик, редактор)
+type BookAuthorDTO struct {
+	AuthorID uuid.UUID `db:"author_id"`
+	Name     string    `db:"name"`
+	Role     string    `db:"role"`
+	Position int       `db:"position"`
+}
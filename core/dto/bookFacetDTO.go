@@ -0,0 +1,7 @@
+package dto
+
+// BookFacetDTO — значение поля книги (жанр, автор) и число книг с ним
+type BookFacetDTO struct {
+	Value string `db:"value"`
+	Count int    `db:"count"`
+}
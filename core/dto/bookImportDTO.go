@@ -0,0 +1,33 @@
+package dto
+
+import "github.com/google/uuid"
+
+type BookImportParamsDTO struct {
+	Format   string                 // csv, jsonl, marc21 или marcxml
+	Key      string                 // естественный ключ, по которому строка считается уже существующей книгой
+	Defaults *BookImportDefaultsDTO // значения полей, которых нет в формате MARC
+	BranchID *uuid.UUID             // филиал, в фонд которого поступают книги без учета по филиалам; nil — без учета
+}
+
+type BookImportDefaultsDTO struct {
+	CopiesNumber uint
+	Rarity       string
+	AgeLimit     uint
+}
+
+type BookImportReportDTO struct {
+	Total     int                   `json:"total"`
+	Inserted  int                   `json:"inserted"`
+	Updated   int                   `json:"updated"`
+	Unchanged int                   `json:"unchanged"`
+	Failed    int                   `json:"failed"`
+	Errors    []*BookImportErrorDTO `json:"errors"`
+	// SkippedFields — сколько раз во входных записях встретилось поле, не перенесенное в книгу
+	SkippedFields map[string]int `json:"skipped_fields,omitempty"`
+}
+
+type BookImportErrorDTO struct {
+	Line    int    `json:"line"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
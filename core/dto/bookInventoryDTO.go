@@ -0,0 +1,12 @@
+package dto
+
+import "github.com/google/uuid"
+
+// BookInventoryDTO — фонд книги в филиале. Available — экземпляры, не занятые открытыми бронированиями филиала
+type BookInventoryDTO struct {
+	BranchID     uuid.UUID `db:"branch_id"`
+	BranchCode   string    `db:"branch_code"`
+	BranchName   string    `db:"branch_name"`
+	CopiesNumber uint      `db:"copies_number"`
+	Available    uint      `db:"available"`
+}
@@ -0,0 +1,13 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"github.com/nikitalystsev/BookSmart-services/core/dto"
+)
+
+// BookParamsDTO — фильтр выборок BookRepo: параметры сервисного слоя и поля, которых в нем нет
+type BookParamsDTO struct {
+	dto.BookParamsDTO
+	ISBN     string     // ISBN-10 или ISBN-13 в любом написании; пустая строка — без фильтра
+	BranchID *uuid.UUID // только книги, у которых в филиале есть экземпляр, не занятый открытым бронированием
+}
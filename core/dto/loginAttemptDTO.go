@@ -0,0 +1,9 @@
+package dto
+
+import "time"
+
+type LoginLockoutDTO struct {
+	FailedAttempts int
+	IsLocked       bool
+	RemainingTime  time.Duration
+}
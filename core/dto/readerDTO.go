@@ -0,0 +1,78 @@
+package dto
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ReaderParamsDTO struct {
+	Fio         string
+	PhoneNumber string
+	Role        string
+	MinAge      uint
+	MaxAge      uint
+	Limit       uint
+	Offset      int
+}
+
+type ReaderExportDTO struct {
+	Reader        ReaderExportInfoDTO      `json:"reader"`
+	LibCards      []*LibCardExportDTO      `json:"lib_cards"`
+	Reservations  []*ReservationExportDTO  `json:"reservations"`
+	Ratings       []*RatingExportDTO       `json:"ratings"`
+	FavoriteBooks []*FavoriteBookExportDTO `json:"favorite_books"`
+	BookHolds     []*BookHoldExportDTO     `json:"book_holds"`
+	LoginHistory  []*LoginExportDTO        `json:"login_history"`
+	ExportedAt    time.Time                `json:"exported_at"`
+}
+
+type ReaderExportInfoDTO struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	Fio           string     `json:"fio" db:"fio"`
+	PhoneNumber   string     `json:"phone_number" db:"phone_number"`
+	Age           uint       `json:"age" db:"age"`
+	Role          string     `json:"role" db:"role"`
+	DeactivatedAt *time.Time `json:"deactivated_at,omitempty" db:"deactivated_at"`
+}
+
+type LibCardExportDTO struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	LibCardNum   string    `json:"lib_card_num" db:"lib_card_num"`
+	Validity     int       `json:"validity" db:"validity"`
+	IssueDate    time.Time `json:"issue_date" db:"issue_date"`
+	ActionStatus bool      `json:"action_status" db:"action_status"`
+}
+
+type ReservationExportDTO struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	BookID     uuid.UUID `json:"book_id" db:"book_id"`
+	BookTitle  string    `json:"book_title" db:"book_title"`
+	IssueDate  time.Time `json:"issue_date" db:"issue_date"`
+	ReturnDate time.Time `json:"return_date" db:"return_date"`
+	State      string    `json:"state" db:"state"`
+}
+
+type RatingExportDTO struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	BookID    uuid.UUID `json:"book_id" db:"book_id"`
+	BookTitle string    `json:"book_title" db:"book_title"`
+	Review    *string   `json:"review" db:"review"`
+	Rating    int       `json:"rating" db:"rating"`
+}
+
+type FavoriteBookExportDTO struct {
+	BookID    uuid.UUID `json:"book_id" db:"book_id"`
+	BookTitle string    `json:"book_title" db:"book_title"`
+}
+
+type BookHoldExportDTO struct {
+	BookID    uuid.UUID `json:"book_id" db:"book_id"`
+	BookTitle string    `json:"book_title" db:"book_title"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type LoginExportDTO struct {
+	LoginTime time.Time `json:"login_time" db:"login_time"`
+	Success   bool      `json:"success" db:"success"`
+	IP        string    `json:"ip" db:"ip"`
+}
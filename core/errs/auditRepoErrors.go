@@ -0,0 +1,7 @@
+package errs
+
+import "errors"
+
+var (
+	ErrAuditLogDoesNotExists = errors.New("[!] auditRepo error! Audit log does not exist")
+)
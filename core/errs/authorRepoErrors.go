@@ -0,0 +1,11 @@
+package errs
+
+import "errors"
+
+var (
+	ErrAuthorDoesNotExists      = errors.New("[!] authorRepo error! Author does not exist")
+	ErrAuthorAlreadyExists      = errors.New("[!] authorRepo error! Author with this name or alias already exists")
+	ErrAuthorHasBooks           = errors.New("[!] authorRepo error! Author has books")
+	ErrAuthorAliasDoesNotExists = errors.New("[!] authorRepo error! Author alias does not exist")
+	ErrAuthorAliasIsName        = errors.New("[!] authorRepo error! Author alias is the current name")
+)
@@ -0,0 +1,16 @@
+package errs
+
+import "errors"
+
+var (
+	ErrBookCopyDoesNotExists        = errors.New("[!] bookCopyRepo error! Book copy does not exist")
+	ErrBookCopyBarcodeAlreadyExists = errors.New("[!] bookCopyRepo error! Book copy with this barcode already exists")
+	ErrInvalidBookCopyBarcode       = errors.New("[!] bookCopyRepo error! Invalid book copy barcode")
+	ErrInvalidBookCopyCondition     = errors.New("[!] bookCopyRepo error! Invalid book copy condition")
+	ErrInvalidBookCopyStatus        = errors.New("[!] bookCopyRepo error! Invalid book copy status")
+	ErrBookCopyIsIssued             = errors.New("[!] bookCopyRepo error! Book copy is issued")
+	ErrBookCopyIsNotAvailable       = errors.New("[!] bookCopyRepo error! Book copy is not available")
+	ErrBookCopyOfAnotherBook        = errors.New("[!] bookCopyRepo error! Book copy belongs to another book")
+	ErrBookCopyOfAnotherBranch      = errors.New("[!] bookCopyRepo error! Book copy belongs to another branch")
+	ErrBookCopyIsLostByReader       = errors.New("[!] bookCopyRepo error! Lost book copy is still linked to an open reservation")
+)
@@ -0,0 +1,16 @@
+package errs
+
+import "errors"
+
+var (
+	ErrBookHasActiveReservations = errors.New("[!] bookRepo error! Book has active reservations")
+	ErrBookIsNotDeleted          = errors.New("[!] bookRepo error! Book is not deleted")
+	ErrUnknownBookImportFormat   = errors.New("[!] bookRepo error! Unknown book import format")
+	ErrUnknownBookImportKey      = errors.New("[!] bookRepo error! Unknown book import key")
+	ErrUnknownBookExportFormat   = errors.New("[!] bookRepo error! Unknown book export format")
+	ErrInvalidISBN               = errors.New("[!] bookRepo error! Invalid ISBN")
+	ErrBookISBNAlreadyExists     = errors.New("[!] bookRepo error! Book with this ISBN already exists")
+	ErrInvalidBookAuthorRole     = errors.New("[!] bookRepo error! Invalid book author role")
+	ErrBookAuthorDoesNotExists   = errors.New("[!] bookRepo error! Book author does not exist")
+	ErrBookCopiesNumberMismatch  = errors.New("[!] bookRepo error! Copies number of a book with branch inventory is set per branch")
+)
@@ -0,0 +1,10 @@
+package errs
+
+import "errors"
+
+var (
+	ErrBranchDoesNotExists = errors.New("[!] branchRepo error! Branch does not exist")
+	ErrBranchAlreadyExists = errors.New("[!] branchRepo error! Branch with this code already exists")
+	ErrBranchIsInUse       = errors.New("[!] branchRepo error! Branch has inventory, copies, reservations or libCards")
+	ErrInvalidBranchCode   = errors.New("[!] branchRepo error! Invalid branch code")
+)
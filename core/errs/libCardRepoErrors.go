@@ -0,0 +1,13 @@
+package errs
+
+import "errors"
+
+var (
+	ErrInvalidLibCardNum          = errors.New("[!] libCardRepo error! Invalid libCard number")
+	ErrLibCardNumsExhausted       = errors.New("[!] libCardRepo error! LibCard numbers for this format are exhausted")
+	ErrInvalidLibCardNumConfig    = errors.New("[!] libCardRepo error! Invalid libCard number config")
+	ErrInvalidLibCardExtension    = errors.New("[!] libCardRepo error! Invalid libCard extension period")
+	ErrLibCardRenewalDoesNotExist = errors.New("[!] libCardRepo error! LibCard renewal does not exist")
+	ErrLibCardIsBlocked           = errors.New("[!] libCardRepo error! LibCard is blocked")
+	ErrInvalidLibCardBlockReason  = errors.New("[!] libCardRepo error! Invalid libCard block reason")
+)
@@ -0,0 +1,7 @@
+package errs
+
+import "errors"
+
+var (
+	ErrLoginHistoryDoesNotExists = errors.New("[!] loginAttemptRepo error! Login history does not exist")
+)
@@ -0,0 +1,11 @@
+package errs
+
+import "errors"
+
+var (
+	ErrPublisherDoesNotExists      = errors.New("[!] publisherRepo error! Publisher does not exist")
+	ErrPublisherAlreadyExists      = errors.New("[!] publisherRepo error! Publisher with this name or alias already exists")
+	ErrPublisherHasBooks           = errors.New("[!] publisherRepo error! Publisher has books")
+	ErrPublisherAliasDoesNotExists = errors.New("[!] publisherRepo error! Publisher alias does not exist")
+	ErrPublisherAliasIsName        = errors.New("[!] publisherRepo error! Publisher alias is the current name")
+)
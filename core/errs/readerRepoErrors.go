@@ -0,0 +1,9 @@
+package errs
+
+import "errors"
+
+var (
+	ErrReaderPhoneNumberAlreadyExist = errors.New("[!] readerRepo error! Reader with this phoneNumber already exists")
+	ErrReaderIsDeactivated           = errors.New("[!] readerRepo error! Reader is deactivated")
+	ErrReaderIsAlreadyAnonymized     = errors.New("[!] readerRepo error! Reader is already anonymized")
+)
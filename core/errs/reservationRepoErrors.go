@@ -0,0 +1,13 @@
+package errs
+
+import "errors"
+
+var (
+	ErrReservationRenewalDoesNotExist  = errors.New("[!] reservationRepo error! Reservation renewal does not exist")
+	ErrReservationRenewalLimitReached  = errors.New("[!] reservationRepo error! Reservation renewal limit reached")
+	ErrInvalidReservationRenewalPolicy = errors.New("[!] reservationRepo error! Invalid reservation renewal policy")
+	ErrReservationExtensionTooLong     = errors.New("[!] reservationRepo error! Reservation is extended beyond the renewal period")
+	ErrBookHasWaitingReaders           = errors.New("[!] reservationRepo error! Other readers are waiting for the book")
+	ErrBookHoldDoesNotExists           = errors.New("[!] reservationRepo error! Book hold does not exist")
+	ErrBookHoldAlreadyExists           = errors.New("[!] reservationRepo error! Reader is already waiting for the book")
+)
@@ -0,0 +1,11 @@
+package errs
+
+import "errors"
+
+var (
+	ErrVerificationCodeDoesNotExists    = errors.New("[!] verificationCodeRepo error! Verification code does not exist or expired")
+	ErrVerificationCodeIsInvalid        = errors.New("[!] verificationCodeRepo error! Verification code is invalid")
+	ErrVerificationCodeAttemptsExceeded = errors.New("[!] verificationCodeRepo error! Verification code attempts exceeded")
+	ErrVerificationCodeResendTooEarly   = errors.New("[!] verificationCodeRepo error! Verification code resend is too early")
+	ErrVerificationCodeSecretIsEmpty    = errors.New("[!] verificationCodeRepo error! Verification code secret is empty")
+)
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuditLogModel struct {
+	ID         uuid.UUID       `db:"id"`
+	EntityType string          `db:"entity_type"`
+	EntityID   uuid.UUID       `db:"entity_id"`
+	Operation  string          `db:"operation"`
+	BeforeData json.RawMessage `db:"before_data"`
+	AfterData  json.RawMessage `db:"after_data"`
+	ActorID    *uuid.UUID      `db:"actor_id"`
+	RequestID  *string         `db:"request_id"`
+	CreatedAt  time.Time       `db:"created_at"`
+}
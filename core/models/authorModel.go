@@ -0,0 +1,12 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuthorModel struct {
+	ID        uuid.UUID `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type BookCopyModel struct {
+	ID        uuid.UUID  `db:"id"`
+	BookID    uuid.UUID  `db:"book_id"`
+	Barcode   string     `db:"barcode"`
+	Condition string     `db:"condition"`
+	Location  string     `db:"location"`
+	Status    string     `db:"status"`
+	BranchID  *uuid.UUID `db:"branch_id"`
+	CreatedAt time.Time  `db:"created_at"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type BookHoldModel struct {
+	ID        uuid.UUID `db:"id"`
+	BookID    uuid.UUID `db:"book_id"`
+	ReaderID  uuid.UUID `db:"reader_id"`
+	CreatedAt time.Time `db:"created_at"`
+}
@@ -3,14 +3,17 @@ package models
 import "github.com/google/uuid"
 
 type BookModel struct {
-	ID             uuid.UUID `db:"id"`
-	Title          string    `db:"title"`
-	Author         string    `db:"author"`
-	Publisher      string    `db:"publisher"`
-	CopiesNumber   uint      `db:"copies_number"`
-	Rarity         string    `db:"rarity"`
-	Genre          string    `db:"genre"`
-	PublishingYear uint      `db:"publishing_year"`
-	Language       string    `db:"language"`
-	AgeLimit       uint      `db:"age_limit"`
+	ID             uuid.UUID  `db:"id"`
+	Title          string     `db:"title"`
+	Author         string     `db:"author"`
+	Publisher      string     `db:"publisher"`
+	CopiesNumber   uint       `db:"copies_number"`
+	Rarity         string     `db:"rarity"`
+	Genre          string     `db:"genre"`
+	PublishingYear uint       `db:"publishing_year"`
+	Language       string     `db:"language"`
+	AgeLimit       uint       `db:"age_limit"`
+	ISBN10         *string    `db:"isbn_10"`
+	ISBN13         *string    `db:"isbn_13"`
+	PublisherID    *uuid.UUID `db:"publisher_id"`
 }
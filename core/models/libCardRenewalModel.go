@@ -0,0 +1,16 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type LibCardRenewalModel struct {
+	ID               uuid.UUID `db:"id"`
+	LibCardID        uuid.UUID `db:"lib_card_id"`
+	PreviousValidity int       `db:"previous_validity"`
+	NewValidity      int       `db:"new_validity"`
+	PreviousExpiry   time.Time `db:"previous_expiry"`
+	NewExpiry        time.Time `db:"new_expiry"`
+	RenewedAt        time.Time `db:"renewed_at"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type LibCardStatusModel struct {
+	LibCardModel
+	Status      string     `db:"status"`
+	BlockReason *string    `db:"block_reason"`
+	BlockedAt   *time.Time `db:"blocked_at"`
+	ReplacesID  *uuid.UUID `db:"replaces_id"`
+}
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type LoginHistoryModel struct {
+	ID        uuid.UUID `db:"id"`
+	ReaderID  uuid.UUID `db:"reader_id"`
+	LoginTime time.Time `db:"login_time"`
+	Success   bool      `db:"success"`
+	IP        string    `db:"ip"`
+}
@@ -6,10 +6,12 @@ import (
 )
 
 type ReservationModel struct {
-	ID         uuid.UUID `db:"id"`
-	ReaderID   uuid.UUID `db:"reader_id"`
-	BookID     uuid.UUID `db:"book_id"`
-	IssueDate  time.Time `db:"issue_date"`
-	ReturnDate time.Time `db:"return_date"`
-	State      string    `db:"state"`
+	ID         uuid.UUID  `db:"id"`
+	ReaderID   uuid.UUID  `db:"reader_id"`
+	BookID     uuid.UUID  `db:"book_id"`
+	IssueDate  time.Time  `db:"issue_date"`
+	ReturnDate time.Time  `db:"return_date"`
+	State      string     `db:"state"`
+	BranchID   *uuid.UUID `db:"branch_id"`
+	CopyID     *uuid.UUID `db:"copy_id"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type ReservationRenewalModel struct {
+	ID                 uuid.UUID `db:"id"`
+	ReservationID      uuid.UUID `db:"reservation_id"`
+	PreviousReturnDate time.Time `db:"previous_return_date"`
+	NewReturnDate      time.Time `db:"new_return_date"`
+	RenewedAt          time.Time `db:"renewed_at"`
+}
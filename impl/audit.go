@@ -0,0 +1,176 @@
+package impl
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"reflect"
+)
+
+const (
+	AuditEntityBook          = "book"
+	AuditEntityReader        = "reader"
+	AuditEntityLibCard       = "lib_card"
+	AuditEntityRating        = "rating"
+	AuditEntityReservation   = "reservation"
+	AuditEntityAuthor        = "author"
+	AuditEntityPublisher     = "publisher"
+	AuditEntityBookAuthor    = "book_author"
+	AuditEntityBookCopy      = "book_copy"
+	AuditEntityBranch        = "branch"
+	AuditEntityBookInventory = "book_inventory"
+	AuditEntityBookHold      = "book_hold"
+)
+
+const (
+	AuditOperationCreate      = "create"
+	AuditOperationUpdate      = "update"
+	AuditOperationDelete      = "delete"
+	AuditOperationRestore     = "restore"
+	AuditOperationDeactivate  = "deactivate"
+	AuditOperationAnonymize   = "anonymize"
+	AuditOperationAddAlias    = "add_alias"
+	AuditOperationDeleteAlias = "delete_alias"
+)
+
+// auditHiddenFields никогда не попадают в журнал, даже в виде хеша
+var auditHiddenFields = map[string]struct{}{
+	"password": {},
+}
+
+// auditWriter пишет записи журнала изменений через тот же getter, что и репозиторий,
+// поэтому запись попадает в транзакцию изменяемой сущности
+type auditWriter struct {
+	db     *sqlx.DB
+	getter *trmsqlx.CtxGetter
+}
+
+func newAuditWriter(db *sqlx.DB, getter *trmsqlx.CtxGetter) *auditWriter {
+	return &auditWriter{db: db, getter: getter}
+}
+
+// write сохраняет изменение сущности. before и after — модели репозитория (с тегами db)
+// или nil; для обновления в журнал попадают только изменившиеся поля
+func (aw *auditWriter) write(ctx context.Context, entityType string, entityID uuid.UUID, operation string, before, after any) error {
+	beforeFields, afterFields := auditFields(before), auditFields(after)
+	if beforeFields != nil && afterFields != nil {
+		beforeFields, afterFields = auditDiff(beforeFields, afterFields)
+	}
+
+	beforeData, err := marshalAuditFields(beforeFields)
+	if err != nil {
+		return err
+	}
+	afterData, err := marshalAuditFields(afterFields)
+	if err != nil {
+		return err
+	}
+
+	actorID, requestID := auditContext(ctx)
+
+	query := `insert into bs.audit_log 
+			  	(entity_type, entity_id, operation, before_data, after_data, actor_id, request_id) 
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = aw.getter.DefaultTrOrDB(ctx, aw.db).ExecContext(
+		ctx, query,
+		entityType,
+		entityID,
+		operation,
+		beforeData,
+		afterData,
+		actorID,
+		requestID,
+	)
+
+	return err
+}
+
+// auditContext достает из контекста автора изменения и ID запроса; отсутствующие значения пишутся как null
+func auditContext(ctx context.Context) (*uuid.UUID, *string) {
+	var actorID *uuid.UUID
+	if id, ok := ActorIDFromContext(ctx); ok {
+		actorID = &id
+	}
+	var requestID *string
+	if id, ok := RequestIDFromContext(ctx); ok {
+		requestID = &id
+	}
+
+	return actorID, requestID
+}
+
+// auditFields раскладывает модель по именам колонок из тегов db. Встроенные структуры
+// раскрываются, готовые наборы полей (map[string]any) передаются как есть
+func auditFields(model any) map[string]any {
+	if fields, ok := model.(map[string]any); ok {
+		return fields
+	}
+
+	v := reflect.ValueOf(model)
+	if !v.IsValid() || (v.Kind() == reflect.Pointer && v.IsNil()) {
+		return nil
+	}
+
+	fields := make(map[string]any)
+	collectAuditFields(reflect.Indirect(v), fields)
+
+	return fields
+}
+
+func collectAuditFields(v reflect.Value, fields map[string]any) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			collectAuditFields(v.Field(i), fields)
+			continue
+		}
+
+		column := field.Tag.Get("db")
+		if column == "" || column == "-" {
+			continue
+		}
+		if _, hidden := auditHiddenFields[column]; hidden {
+			continue
+		}
+		fields[column] = v.Field(i).Interface()
+	}
+}
+
+func auditDiff(before, after map[string]any) (map[string]any, map[string]any) {
+	changedBefore, changedAfter := make(map[string]any), make(map[string]any)
+
+	for column, afterValue := range after {
+		beforeValue := before[column]
+
+		beforeJSON, _ := json.Marshal(beforeValue)
+		afterJSON, _ := json.Marshal(afterValue)
+		if bytes.Equal(beforeJSON, afterJSON) {
+			continue
+		}
+
+		changedBefore[column] = beforeValue
+		changedAfter[column] = afterValue
+	}
+
+	return changedBefore, changedAfter
+}
+
+// marshalAuditFields возвращает строку, а не []byte: lib/pq передает []byte как bytea,
+// что не приводится к jsonb
+func marshalAuditFields(fields map[string]any) (*string, error) {
+	if fields == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	dataStr := string(data)
+
+	return &dataStr, nil
+}
@@ -0,0 +1,100 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+)
+
+type AuditRepo struct {
+	instrumentation
+
+	db *sqlx.DB
+}
+
+func NewAuditRepo(db *sqlx.DB, logger Logger) *AuditRepo {
+	return &AuditRepo{
+		instrumentation: instrumentation{repo: "audit", dbSystem: dbSystemPostgres, table: "bs.audit_log", logger: logger},
+		db:              db,
+	}
+}
+
+// GetByEntity возвращает историю изменений сущности в хронологическом порядке
+func (ar *AuditRepo) GetByEntity(ctx context.Context, entityType string, entityID uuid.UUID, limit uint, offset int) (_ []*repomodels.AuditLogModel, err error) {
+	ctx, end := ar.start(ctx, "GetByEntity", entityIDAttr(entityID))
+	defer end(&err)
+
+	ar.logger.Debugf("selecting audit log of %s with ID: %s", entityType, entityID)
+
+	query := `select 
+    			id, 
+    			entity_type, 
+    			entity_id, 
+    			operation, 
+    			coalesce(before_data, 'null'::jsonb) as before_data, 
+    			coalesce(after_data, 'null'::jsonb) as after_data, 
+    			actor_id, 
+    			request_id, 
+    			created_at 
+			  from bs.audit_log 
+			  where entity_type = $1 and entity_id = $2 
+			  order by created_at 
+			  limit $3 offset $4`
+
+	var entries []*repomodels.AuditLogModel
+	err = ar.db.SelectContext(ctx, &entries, query, entityType, entityID, limit, offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		ar.logger.Debugf("error selecting audit log: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(entries) == 0 {
+		ar.logger.Debugf("audit log of %s with ID not found: %s", entityType, entityID)
+		return nil, repoerrs.ErrAuditLogDoesNotExists
+	}
+
+	ar.logger.Debugf("found %d audit log entries of %s with ID: %s", len(entries), entityType, entityID)
+
+	return entries, nil
+}
+
+// GetByActor возвращает изменения, выполненные пользователем, начиная с последних
+func (ar *AuditRepo) GetByActor(ctx context.Context, actorID uuid.UUID, limit uint, offset int) (_ []*repomodels.AuditLogModel, err error) {
+	ctx, end := ar.start(ctx, "GetByActor", entityIDAttr(actorID))
+	defer end(&err)
+
+	ar.logger.Debugf("selecting audit log of actor with ID: %s", actorID)
+
+	query := `select 
+    			id, 
+    			entity_type, 
+    			entity_id, 
+    			operation, 
+    			coalesce(before_data, 'null'::jsonb) as before_data, 
+    			coalesce(after_data, 'null'::jsonb) as after_data, 
+    			actor_id, 
+    			request_id, 
+    			created_at 
+			  from bs.audit_log 
+			  where actor_id = $1 
+			  order by created_at desc 
+			  limit $2 offset $3`
+
+	var entries []*repomodels.AuditLogModel
+	err = ar.db.SelectContext(ctx, &entries, query, actorID, limit, offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		ar.logger.Debugf("error selecting audit log: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(entries) == 0 {
+		ar.logger.Debugf("audit log of actor with ID not found: %s", actorID)
+		return nil, repoerrs.ErrAuditLogDoesNotExists
+	}
+
+	ar.logger.Debugf("found %d audit log entries of actor with ID: %s", len(entries), actorID)
+
+	return entries, nil
+}
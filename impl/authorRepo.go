@@ -0,0 +1,233 @@
+package impl
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+)
+
+// AuthorRepo — справочник авторов. Псевдонимы сводят разные написания («Толстой Л.Н.», «Лев Толстой»)
+// к одной записи
+type AuthorRepo struct {
+	instrumentation
+
+	directory *directory
+}
+
+func NewAuthorRepo(db *sqlx.DB, logger Logger) *AuthorRepo {
+	return &AuthorRepo{
+		instrumentation: instrumentation{repo: "author", dbSystem: dbSystemPostgres, table: "bs.author", logger: logger},
+		directory: newDirectory(db, "author", AuditEntityAuthor, directoryErrors{
+			notFound:      repoerrs.ErrAuthorDoesNotExists,
+			alreadyExists: repoerrs.ErrAuthorAlreadyExists,
+			hasBooks:      repoerrs.ErrAuthorHasBooks,
+			aliasNotFound: repoerrs.ErrAuthorAliasDoesNotExists,
+			aliasIsName:   repoerrs.ErrAuthorAliasIsName,
+		}),
+	}
+}
+
+func (ar *AuthorRepo) Create(ctx context.Context, author *repomodels.AuthorModel) (err error) {
+	ctx, end := ar.start(ctx, "Create", entityIDAttr(author.ID))
+	defer end(&err)
+
+	ar.logger.Debugf("inserting author with ID: %s", author.ID)
+
+	err = ar.directory.create(ctx, author.ID, author.Name)
+	if err != nil && errors.Is(err, repoerrs.ErrAuthorAlreadyExists) {
+		ar.logger.Debugf("author with this name already exists: %s", author.Name)
+		return err
+	}
+	if err != nil {
+		ar.logger.Debugf("error inserting author: %v", err)
+		return err
+	}
+
+	ar.logger.Debugf("inserted author with ID: %s", author.ID)
+
+	return nil
+}
+
+func (ar *AuthorRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *repomodels.AuthorModel, err error) {
+	ctx, end := ar.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	ar.logger.Debugf("selecting author with ID: %s", ID)
+
+	entry, err := ar.directory.getByID(ctx, ID)
+	if err != nil && errors.Is(err, repoerrs.ErrAuthorDoesNotExists) {
+		ar.logger.Debugf("author with this ID not found: %s", ID)
+		return nil, err
+	}
+	if err != nil {
+		ar.logger.Debugf("error selecting author with ID: %v", err)
+		return nil, err
+	}
+
+	ar.logger.Debugf("selected author with ID: %s", ID)
+
+	return (*repomodels.AuthorModel)(entry), nil
+}
+
+// GetByName ищет автора по текущему имени или псевдониму без учета регистра
+func (ar *AuthorRepo) GetByName(ctx context.Context, name string) (_ *repomodels.AuthorModel, err error) {
+	ctx, end := ar.start(ctx, "GetByName")
+	defer end(&err)
+
+	ar.logger.Debugf("selecting author by name: %s", name)
+
+	entry, err := ar.directory.getByName(ctx, name)
+	if err != nil && errors.Is(err, repoerrs.ErrAuthorDoesNotExists) {
+		ar.logger.Debugf("author with this name not found: %s", name)
+		return nil, err
+	}
+	if err != nil {
+		ar.logger.Debugf("error selecting author by name: %v", err)
+		return nil, err
+	}
+
+	ar.logger.Debugf("selected author with name: %s", name)
+
+	return (*repomodels.AuthorModel)(entry), nil
+}
+
+// Search ищет по подстроке в имени и псевдонимах
+func (ar *AuthorRepo) Search(ctx context.Context, params *repodto.DirectoryParamsDTO) (_ []*repomodels.AuthorModel, err error) {
+	ctx, end := ar.start(ctx, "Search")
+	defer end(&err)
+
+	ar.logger.Debugf("selecting authors with params")
+
+	entries, err := ar.directory.search(ctx, params)
+	if err != nil && errors.Is(err, repoerrs.ErrAuthorDoesNotExists) {
+		ar.logger.Debugf("authors not found with this params")
+		return nil, err
+	}
+	if err != nil {
+		ar.logger.Debugf("error selecting authors with params: %v", err)
+		return nil, err
+	}
+
+	ar.logger.Debugf("found %d authors", len(entries))
+
+	authors := make([]*repomodels.AuthorModel, len(entries))
+	for i, entry := range entries {
+		authors[i] = (*repomodels.AuthorModel)(entry)
+	}
+
+	return authors, nil
+}
+
+// Update меняет имя; прежнее имя остается псевдонимом
+func (ar *AuthorRepo) Update(ctx context.Context, author *repomodels.AuthorModel) (err error) {
+	ctx, end := ar.start(ctx, "Update", entityIDAttr(author.ID))
+	defer end(&err)
+
+	ar.logger.Debugf("updating author with ID: %s", author.ID)
+
+	err = ar.directory.update(ctx, author.ID, author.Name)
+	if err != nil && (errors.Is(err, repoerrs.ErrAuthorDoesNotExists) || errors.Is(err, repoerrs.ErrAuthorAlreadyExists)) {
+		ar.logger.Debugf("author with ID %s can't be updated: %v", author.ID, err)
+		return err
+	}
+	if err != nil {
+		ar.logger.Debugf("error updating author: %v", err)
+		return err
+	}
+
+	ar.logger.Debugf("updated author with ID: %s", author.ID)
+
+	return nil
+}
+
+// Delete удаляет автора вместе с псевдонимами. Автора, у которого есть книги, удалить нельзя
+func (ar *AuthorRepo) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := ar.start(ctx, "Delete", entityIDAttr(ID))
+	defer end(&err)
+
+	ar.logger.Debugf("deleting author with ID: %s", ID)
+
+	err = ar.directory.delete(ctx, ID)
+	if err != nil && (errors.Is(err, repoerrs.ErrAuthorDoesNotExists) || errors.Is(err, repoerrs.ErrAuthorHasBooks)) {
+		ar.logger.Debugf("author with ID %s can't be deleted: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		ar.logger.Debugf("error deleting author: %v", err)
+		return err
+	}
+
+	ar.logger.Debugf("deleted author with ID: %s", ID)
+
+	return nil
+}
+
+func (ar *AuthorRepo) AddAlias(ctx context.Context, ID uuid.UUID, alias string) (err error) {
+	ctx, end := ar.start(ctx, "AddAlias", entityIDAttr(ID))
+	defer end(&err)
+
+	ar.logger.Debugf("adding alias to author with ID: %s", ID)
+
+	err = ar.directory.addAlias(ctx, ID, alias)
+	if err != nil && (errors.Is(err, repoerrs.ErrAuthorDoesNotExists) || errors.Is(err, repoerrs.ErrAuthorAlreadyExists)) {
+		ar.logger.Debugf("alias %s can't be added to author with ID %s: %v", alias, ID, err)
+		return err
+	}
+	if err != nil {
+		ar.logger.Debugf("error adding author alias: %v", err)
+		return err
+	}
+
+	ar.logger.Debugf("added alias to author with ID: %s", ID)
+
+	return nil
+}
+
+func (ar *AuthorRepo) DeleteAlias(ctx context.Context, ID uuid.UUID, alias string) (err error) {
+	ctx, end := ar.start(ctx, "DeleteAlias", entityIDAttr(ID))
+	defer end(&err)
+
+	ar.logger.Debugf("deleting alias of author with ID: %s", ID)
+
+	err = ar.directory.deleteAlias(ctx, ID, alias)
+	if err != nil && (errors.Is(err, repoerrs.ErrAuthorDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrAuthorAliasDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrAuthorAliasIsName)) {
+		ar.logger.Debugf("alias %s of author with ID %s can't be deleted: %v", alias, ID, err)
+		return err
+	}
+	if err != nil {
+		ar.logger.Debugf("error deleting author alias: %v", err)
+		return err
+	}
+
+	ar.logger.Debugf("deleted alias of author with ID: %s", ID)
+
+	return nil
+}
+
+// GetAliases возвращает все написания, включая текущее имя
+func (ar *AuthorRepo) GetAliases(ctx context.Context, ID uuid.UUID) (_ []string, err error) {
+	ctx, end := ar.start(ctx, "GetAliases", entityIDAttr(ID))
+	defer end(&err)
+
+	ar.logger.Debugf("selecting aliases of author with ID: %s", ID)
+
+	aliases, err := ar.directory.getAliases(ctx, ID)
+	if err != nil && errors.Is(err, repoerrs.ErrAuthorDoesNotExists) {
+		ar.logger.Debugf("author with this ID not found: %s", ID)
+		return nil, err
+	}
+	if err != nil {
+		ar.logger.Debugf("error selecting author aliases: %v", err)
+		return nil, err
+	}
+
+	ar.logger.Debugf("found %d aliases of author with ID: %s", len(aliases), ID)
+
+	return aliases, nil
+}
@@ -0,0 +1,319 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"strings"
+)
+
+const (
+	BookAuthorRoleAuthor     = "Author"
+	BookAuthorRoleTranslator = "Translator"
+	BookAuthorRoleEditor     = "Editor"
+)
+
+var bookAuthorRoles = map[string]struct{}{
+	BookAuthorRoleAuthor:     {},
+	BookAuthorRoleTranslator: {},
+	BookAuthorRoleEditor:     {},
+}
+
+// AddAuthor связывает книгу с автором в роли role; position задает порядок в списке
+// участников. Повторный вызов для той же роли меняет только порядок
+func (br *BookRepo) AddAuthor(ctx context.Context, bookID, authorID uuid.UUID, role string, position int) (err error) {
+	ctx, end := br.start(ctx, "AddAuthor", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("adding author %s to book with ID: %s", authorID, bookID)
+
+	if _, ok := bookAuthorRoles[role]; !ok {
+		br.logger.Debugf("error adding author: invalid role %s", role)
+		return repoerrs.ErrInvalidBookAuthorRole
+	}
+
+	query := `insert into bs.book_author (book_id, author_id, role, position)
+			  values ($1, $2, $3, $4)
+			  on conflict (book_id, author_id, role) do update set position = excluded.position`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		if _, err := br.getActiveForUpdate(ctx, bookID); err != nil {
+			return err
+		}
+
+		_, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, bookID, authorID, role, position)
+		if err != nil && isForeignKeyViolation(err) {
+			return repoerrs.ErrAuthorDoesNotExists
+		}
+		if err != nil {
+			return err
+		}
+
+		after := map[string]any{"author_id": authorID, "role": role, "position": position}
+
+		return br.audit.write(ctx, AuditEntityBookAuthor, bookID, AuditOperationCreate, nil, after)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) || errors.Is(err, repoerrs.ErrAuthorDoesNotExists)) {
+		br.logger.Debugf("author %s can't be added to book with ID %s: %v", authorID, bookID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error adding author: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("added author %s to book with ID: %s", authorID, bookID)
+
+	return nil
+}
+
+func (br *BookRepo) RemoveAuthor(ctx context.Context, bookID, authorID uuid.UUID, role string) (err error) {
+	ctx, end := br.start(ctx, "RemoveAuthor", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("removing author %s from book with ID: %s", authorID, bookID)
+
+	query := `delete from bs.book_author where book_id = $1 and author_id = $2 and role::text = $3`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, bookID, authorID, role)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows == 0 {
+			return repoerrs.ErrBookAuthorDoesNotExists
+		}
+
+		before := map[string]any{"author_id": authorID, "role": role}
+
+		return br.audit.write(ctx, AuditEntityBookAuthor, bookID, AuditOperationDelete, before, nil)
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrBookAuthorDoesNotExists) {
+		br.logger.Debugf("author %s of book with ID %s not found", authorID, bookID)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error removing author: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("removed author %s from book with ID: %s", authorID, bookID)
+
+	return nil
+}
+
+// linkDirectories связывает книгу со справочниками по ее текстовым полям author и publisher. Имена
+// ищутся среди псевдонимов без учета регистра, как при заполнении справочников в 000015; ненайденные
+// имена справочники не пополняют, такие книги связываются вручную через AddAuthor и SetPublisher.
+// before — состояние до изменения (nil для новой книги): связи пересматриваются, только если поле изменилось,
+// и заменяется лишь связь с автором, найденным по прежнему тексту
+func (br *BookRepo) linkDirectories(ctx context.Context, ID uuid.UUID, author, publisher string, before *bookSnapshot) error {
+	tx := br.getter.DefaultTrOrDB(ctx, br.db)
+
+	if before == nil || !strings.EqualFold(before.Author, author) {
+		if before != nil {
+			query := `delete from bs.book_author ba
+					  using bs.author_alias a
+					  where ba.book_id = $1 and ba.role = 'Author' and
+					        a.author_id = ba.author_id and lower(a.alias) = lower($2)
+					  returning ba.author_id`
+
+			var unlinked []uuid.UUID
+			if err := tx.SelectContext(ctx, &unlinked, query, ID, before.Author); err != nil {
+				return err
+			}
+			for _, authorID := range unlinked {
+				auditBefore := map[string]any{"author_id": authorID, "role": BookAuthorRoleAuthor}
+				if err := br.audit.write(ctx, AuditEntityBookAuthor, ID, AuditOperationDelete, auditBefore, nil); err != nil {
+					return err
+				}
+			}
+		}
+
+		query := `insert into bs.book_author (book_id, author_id)
+				  select $1, author_id from bs.author_alias where lower(alias) = lower($2)
+				  on conflict do nothing
+				  returning author_id`
+
+		var linked []uuid.UUID
+		if err := tx.SelectContext(ctx, &linked, query, ID, author); err != nil {
+			return err
+		}
+		for _, authorID := range linked {
+			after := map[string]any{"author_id": authorID, "role": BookAuthorRoleAuthor, "position": 0}
+			if err := br.audit.write(ctx, AuditEntityBookAuthor, ID, AuditOperationCreate, nil, after); err != nil {
+				return err
+			}
+		}
+	}
+
+	if before == nil || !strings.EqualFold(before.Publisher, publisher) {
+		query := `update bs.book
+				  set publisher_id = (select publisher_id from bs.publisher_alias where lower(alias) = lower($2))
+				  where id = $1`
+
+		if _, err := tx.ExecContext(ctx, query, ID, publisher); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetBookAuthors возвращает участников издания: сначала авторов, затем переводчиков и редакторов
+func (br *BookRepo) GetBookAuthors(ctx context.Context, bookID uuid.UUID) (_ []*repodto.BookAuthorDTO, err error) {
+	ctx, end := br.start(ctx, "GetBookAuthors", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting authors of book with ID: %s", bookID)
+
+	query := `select ba.author_id, a.name, ba.role, ba.position
+			  from bs.book_author ba join bs.author a on a.id = ba.author_id
+			  where ba.book_id = $1
+			  order by ba.role, ba.position, a.name`
+
+	var authors []*repodto.BookAuthorDTO
+	err = br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &authors, query, bookID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting authors of book: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(authors) == 0 {
+		br.logger.Debugf("authors of book with ID not found: %s", bookID)
+		return nil, repoerrs.ErrBookAuthorDoesNotExists
+	}
+
+	br.logger.Debugf("found %d authors of book with ID: %s", len(authors), bookID)
+
+	return authors, nil
+}
+
+// GetByAuthorID возвращает книги автора; пустая role — в любой роли
+func (br *BookRepo) GetByAuthorID(ctx context.Context, authorID uuid.UUID, role string, limit uint, offset int) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByAuthorID", entityIDAttr(authorID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting books of author with ID: %s", authorID)
+
+	query := `select
+    			b.id,
+    			b.title,
+    			b.author,
+    			b.publisher,
+    			b.copies_number,
+    			b.rarity,
+    			b.genre,
+    			b.publishing_year,
+    			b.language,
+    			b.age_limit
+	          from bs.book b
+	          where ($2 or b.deleted_at is null) and
+	                exists (select 1 from bs.book_author ba
+	                        where ba.book_id = b.id and ba.author_id = $1 and ($3 = '' or ba.role::text = $3))
+	          order by b.publishing_year, b.title, b.id
+	          limit $4 offset $5`
+
+	return br.selectBooks(ctx, query, authorID, withDeletedBooks(ctx), role, limit, offset)
+}
+
+// SetPublisher привязывает книгу к издательству и заменяет текстовое поле publisher его
+// текущим названием; nil отвязывает книгу, оставляя текст как есть
+func (br *BookRepo) SetPublisher(ctx context.Context, bookID uuid.UUID, publisherID *uuid.UUID) (err error) {
+	ctx, end := br.start(ctx, "SetPublisher", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("setting publisher of book with ID: %s", bookID)
+
+	query := `update bs.book
+			  set publisher_id = $1,
+			      publisher = coalesce((select name from bs.publisher where id = $1), publisher)
+			  where id = $2`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getActiveForUpdate(ctx, bookID)
+		if err != nil {
+			return err
+		}
+
+		_, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, publisherID, bookID)
+		if err != nil && isForeignKeyViolation(err) {
+			return repoerrs.ErrPublisherDoesNotExists
+		}
+		if err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, bookID, AuditOperationUpdate, EventBookUpdated, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) || errors.Is(err, repoerrs.ErrPublisherDoesNotExists)) {
+		br.logger.Debugf("publisher of book with ID %s can't be set: %v", bookID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error setting publisher: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("set publisher of book with ID: %s", bookID)
+
+	return nil
+}
+
+func (br *BookRepo) GetByPublisherID(ctx context.Context, publisherID uuid.UUID, limit uint, offset int) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByPublisherID", entityIDAttr(publisherID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting books of publisher with ID: %s", publisherID)
+
+	query := `select
+    			id,
+    			title,
+    			author,
+    			publisher,
+    			copies_number,
+    			rarity,
+    			genre,
+    			publishing_year,
+    			language,
+    			age_limit
+	          from bs.book
+	          where ($2 or deleted_at is null) and publisher_id = $1
+	          order by publishing_year, title, id
+	          limit $3 offset $4`
+
+	return br.selectBooks(ctx, query, publisherID, withDeletedBooks(ctx), limit, offset)
+}
+
+func (br *BookRepo) selectBooks(ctx context.Context, query string, args ...any) ([]*models.BookModel, error) {
+	var coreBooks []*repomodels.BookModel
+
+	err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &coreBooks, query, args...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting books: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreBooks) == 0 {
+		br.logger.Debugf("books not found")
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("found %d books", len(coreBooks))
+
+	books := make([]*models.BookModel, len(coreBooks))
+	for i, book := range coreBooks {
+		books[i] = br.convertToBookModel(book)
+	}
+
+	return books, nil
+}
@@ -0,0 +1,354 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	trmcontext "github.com/avito-tech/go-transaction-manager/trm/v2/context"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	"github.com/nikitalystsev/BookSmart-services/core/dto"
+	"github.com/nikitalystsev/BookSmart-services/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/intfRepo"
+	"golang.org/x/sync/singleflight"
+	"io"
+	"time"
+)
+
+const (
+	DefaultBookCacheTTL         = 10 * time.Minute
+	DefaultBookCacheNegativeTTL = 30 * time.Second
+	DefaultBookCacheKeyPrefix   = "book_cache:"
+	DefaultBookCacheLoadTimeout = 5 * time.Second
+
+	bookCacheNotFound = "-"
+
+	bookCacheScanCount = 500
+)
+
+type BookCacheConfig struct {
+	Enabled     bool
+	TTL         time.Duration // время жизни найденной книги
+	NegativeTTL time.Duration // время жизни отметки «книга не найдена»
+	KeyPrefix   string
+	LoadTimeout time.Duration // предел общей загрузки из базы при промахе
+}
+
+// CachedBookRepo — декоратор BookRepo, который отдает GetByID и GetByTitle из Redis.
+// Одновременные промахи по одному ключу в рамках процесса схлопываются в один запрос к базе.
+// Ошибки Redis не ломают чтение: запрос просто уходит в базу.
+// Внутри транзакции кеш не используется, а сброс после изменения откладывается до ее завершения:
+// до коммита другие запросы видят старую строку и положили бы ее в кеш снова
+type CachedBookRepo struct {
+	repo   *BookRepo
+	client *redis.Client
+	config BookCacheConfig
+	group  singleflight.Group
+	logger Logger
+}
+
+var _ intfRepo.IBookRepo = (*CachedBookRepo)(nil)
+
+// NewCachedBookRepo оборачивает repo; если кеш выключен в конфигурации, все вызовы идут в repo напрямую
+func NewCachedBookRepo(repo *BookRepo, client *redis.Client, config BookCacheConfig, logger Logger) *CachedBookRepo {
+	if config.TTL <= 0 {
+		config.TTL = DefaultBookCacheTTL
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = DefaultBookCacheNegativeTTL
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = DefaultBookCacheKeyPrefix
+	}
+	if config.LoadTimeout <= 0 {
+		config.LoadTimeout = DefaultBookCacheLoadTimeout
+	}
+
+	return &CachedBookRepo{repo: repo, client: client, config: config, logger: logger}
+}
+
+func (cbr *CachedBookRepo) Create(ctx context.Context, book *models.BookModel) error {
+	if err := cbr.repo.Create(ctx, book); err != nil {
+		return err
+	}
+
+	// могла остаться отметка «не найдена» для этого ID или названия
+	cbr.invalidate(ctx, cbr.idKey(book.ID), cbr.titleKey(book.Title))
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.BookModel, error) {
+	if cbr.bypass(ctx) {
+		return cbr.repo.GetByID(ctx, ID)
+	}
+
+	return cbr.getThrough(ctx, cbr.idKey(ID), func(ctx context.Context) (*models.BookModel, error) {
+		return cbr.repo.GetByID(ctx, ID)
+	})
+}
+
+// GetByTitle кеширует книгу под ключом названия. Если название книги с тех пор изменилось,
+// запись считается промахом
+func (cbr *CachedBookRepo) GetByTitle(ctx context.Context, title string) (*models.BookModel, error) {
+	if cbr.bypass(ctx) {
+		return cbr.repo.GetByTitle(ctx, title)
+	}
+
+	titleKey := cbr.titleKey(title)
+
+	book, err := cbr.getThrough(ctx, titleKey, func(ctx context.Context) (*models.BookModel, error) {
+		return cbr.repo.GetByTitle(ctx, title)
+	})
+	if err == nil && book.Title != title {
+		cbr.invalidate(ctx, titleKey)
+		return cbr.repo.GetByTitle(ctx, title)
+	}
+
+	return book, err
+}
+
+func (cbr *CachedBookRepo) Delete(ctx context.Context, ID uuid.UUID) error {
+	if !cbr.config.Enabled {
+		return cbr.repo.Delete(ctx, ID)
+	}
+
+	book, err := cbr.repo.GetByID(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	if err = cbr.repo.Delete(ctx, ID); err != nil {
+		return err
+	}
+
+	cbr.invalidate(ctx, cbr.idKey(ID), cbr.titleKey(book.Title))
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) Update(ctx context.Context, book *models.BookModel) error {
+	if !cbr.config.Enabled {
+		return cbr.repo.Update(ctx, book)
+	}
+
+	oldBook, err := cbr.repo.GetByID(ctx, book.ID)
+	if err != nil {
+		return err
+	}
+
+	if err = cbr.repo.Update(ctx, book); err != nil {
+		return err
+	}
+
+	cbr.invalidate(ctx, cbr.idKey(book.ID), cbr.titleKey(oldBook.Title), cbr.titleKey(book.Title))
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) Restore(ctx context.Context, ID uuid.UUID) error {
+	if err := cbr.repo.Restore(ctx, ID); err != nil {
+		return err
+	}
+
+	cbr.invalidateBook(ctx, ID)
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) SetISBN(ctx context.Context, ID uuid.UUID, isbn string) error {
+	if err := cbr.repo.SetISBN(ctx, ID, isbn); err != nil {
+		return err
+	}
+
+	cbr.invalidateBook(ctx, ID)
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) SetPublisher(ctx context.Context, bookID uuid.UUID, publisherID *uuid.UUID) error {
+	if err := cbr.repo.SetPublisher(ctx, bookID, publisherID); err != nil {
+		return err
+	}
+
+	cbr.invalidateBook(ctx, bookID)
+
+	return nil
+}
+
+func (cbr *CachedBookRepo) SetInventory(ctx context.Context, bookID, branchID uuid.UUID, copiesNumber uint) error {
+	if err := cbr.repo.SetInventory(ctx, bookID, branchID, copiesNumber); err != nil {
+		return err
+	}
+
+	cbr.invalidateBook(ctx, bookID)
+
+	return nil
+}
+
+// Import может затронуть любое число книг, поэтому после него сбрасывается весь кеш книг
+func (cbr *CachedBookRepo) Import(ctx context.Context, r io.Reader, params *repodto.BookImportParamsDTO) (*repodto.BookImportReportDTO, error) {
+	report, err := cbr.repo.Import(ctx, r, params)
+	if err != nil {
+		return nil, err
+	}
+
+	cbr.afterCommit(ctx, cbr.invalidateAll)
+
+	return report, nil
+}
+
+// GetByParams не кешируется: комбинаций фильтров слишком много
+func (cbr *CachedBookRepo) GetByParams(ctx context.Context, params *dto.BookParamsDTO) ([]*models.BookModel, error) {
+	return cbr.repo.GetByParams(ctx, params)
+}
+
+// Invalidate сбрасывает кеш книги, измененной в обход декоратора
+func (cbr *CachedBookRepo) Invalidate(ctx context.Context, ID uuid.UUID, title string) {
+	cbr.invalidate(ctx, cbr.idKey(ID), cbr.titleKey(title))
+}
+
+// bypass сообщает, что чтение должно идти мимо кеша: кеш выключен, запрошены архивные книги
+// или вызов идет внутри транзакции, которая может видеть еще не закоммиченные изменения
+func (cbr *CachedBookRepo) bypass(ctx context.Context) bool {
+	return !cbr.config.Enabled || withDeletedBooks(ctx) || trmcontext.DefaultManager.Default(ctx) != nil
+}
+
+func (cbr *CachedBookRepo) getThrough(
+	ctx context.Context,
+	key string,
+	load func(ctx context.Context) (*models.BookModel, error),
+) (*models.BookModel, error) {
+	cached, err := cbr.client.Get(ctx, key).Result()
+	if err == nil {
+		return cbr.decode(cached)
+	}
+	if !errors.Is(err, redis.Nil) {
+		cbr.logger.Warnf("error reading book cache: %v", err)
+		return load(ctx)
+	}
+
+	// загрузку разделяют все ожидающие этот ключ, поэтому отмена первого вызывающего не должна
+	// обрывать ее остальным: у нее свой таймаут, а каждый вызывающий ждет не дольше своего ctx
+	result := cbr.group.DoChan(key, func() (any, error) {
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cbr.config.LoadTimeout)
+		defer cancel()
+
+		book, err := load(loadCtx)
+		if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+			return nil, err
+		}
+
+		cbr.store(loadCtx, key, book)
+
+		return book, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-result:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+
+		return res.Val.(*models.BookModel), nil
+	}
+}
+
+func (cbr *CachedBookRepo) store(ctx context.Context, key string, book *models.BookModel) {
+	value, ttl := bookCacheNotFound, cbr.config.NegativeTTL
+	if book != nil {
+		data, err := json.Marshal(book)
+		if err != nil {
+			cbr.logger.Warnf("error encoding book for cache: %v", err)
+			return
+		}
+		value, ttl = string(data), cbr.config.TTL
+	}
+
+	if err := cbr.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		cbr.logger.Warnf("error writing book cache: %v", err)
+	}
+}
+
+func (cbr *CachedBookRepo) decode(cached string) (*models.BookModel, error) {
+	if cached == bookCacheNotFound {
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	var book models.BookModel
+	if err := json.Unmarshal([]byte(cached), &book); err != nil {
+		return nil, err
+	}
+
+	return &book, nil
+}
+
+// invalidateBook сбрасывает кеш книги по ID и текущему названию
+func (cbr *CachedBookRepo) invalidateBook(ctx context.Context, ID uuid.UUID) {
+	if !cbr.config.Enabled {
+		return
+	}
+
+	book, err := cbr.repo.GetByID(ctx, ID)
+	if err != nil {
+		cbr.logger.Warnf("error reading book for cache invalidation: %v", err)
+		cbr.invalidate(ctx, cbr.idKey(ID))
+		return
+	}
+
+	cbr.invalidate(ctx, cbr.idKey(ID), cbr.titleKey(book.Title))
+}
+
+func (cbr *CachedBookRepo) invalidate(ctx context.Context, keys ...string) {
+	cbr.afterCommit(ctx, func(ctx context.Context) {
+		if err := cbr.client.Del(ctx, keys...).Err(); err != nil {
+			cbr.logger.Warnf("error invalidating book cache: %v", err)
+		}
+	})
+}
+
+func (cbr *CachedBookRepo) invalidateAll(ctx context.Context) {
+	iter := cbr.client.Scan(ctx, 0, cbr.config.KeyPrefix+"*", bookCacheScanCount).Iterator()
+	for iter.Next(ctx) {
+		if err := cbr.client.Del(ctx, iter.Val()).Err(); err != nil {
+			cbr.logger.Warnf("error invalidating book cache: %v", err)
+			return
+		}
+	}
+	if err := iter.Err(); err != nil {
+		cbr.logger.Warnf("error invalidating book cache: %v", err)
+	}
+}
+
+// afterCommit выполняет fn сразу, если вызов идет вне транзакции, иначе — после завершения внешней
+// транзакции (при откате сброс лишний, но безвреден). Без кеша ничего не делает
+func (cbr *CachedBookRepo) afterCommit(ctx context.Context, fn func(ctx context.Context)) {
+	if !cbr.config.Enabled {
+		return
+	}
+
+	tr := trmcontext.DefaultManager.Default(ctx)
+	if tr == nil {
+		fn(ctx)
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		<-tr.Closed()
+		fn(ctx)
+	}()
+}
+
+func (cbr *CachedBookRepo) idKey(ID uuid.UUID) string {
+	return fmt.Sprintf("%sid:%s", cbr.config.KeyPrefix, ID)
+}
+
+func (cbr *CachedBookRepo) titleKey(title string) string {
+	return fmt.Sprintf("%stitle:%s", cbr.config.KeyPrefix, title)
+}
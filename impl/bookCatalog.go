@@ -0,0 +1,141 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+)
+
+// GetNewArrivals возвращает книги не из архива, начиная с последних добавленных. У книг начального
+// наполнения одинаковое время добавления (см. 000019_book_created_at_backfill), они идут последними по id
+func (br *BookRepo) GetNewArrivals(ctx context.Context, limit uint, offset int) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetNewArrivals")
+	defer end(&err)
+
+	br.logger.Debugf("selecting new arrivals")
+
+	query := `select
+    			id,
+    			title,
+    			author,
+    			publisher,
+    			copies_number,
+    			rarity,
+    			genre,
+    			publishing_year,
+    			language,
+    			age_limit
+	          from bs.book
+	          where deleted_at is null
+	          order by created_at desc, id
+	          limit $1 offset $2`
+
+	var coreBooks []*repomodels.BookModel
+
+	err = br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &coreBooks, query, limit, offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting new arrivals: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreBooks) == 0 {
+		br.logger.Debugf("new arrivals not found")
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("found %d new arrivals", len(coreBooks))
+
+	books := make([]*models.BookModel, len(coreBooks))
+	for i, book := range coreBooks {
+		books[i] = br.convertToBookModel(book)
+	}
+
+	return books, nil
+}
+
+// GetByGenre возвращает книги не из архива с жанром, в точности равным genre, по названию.
+// В отличие от GetByParams не ищет подстроку: «Роман» не находит «Исторический роман»
+func (br *BookRepo) GetByGenre(ctx context.Context, genre string, limit uint, offset int) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByGenre")
+	defer end(&err)
+
+	return br.getByFacet(ctx, "genre", genre, limit, offset)
+}
+
+// GetByAuthorName возвращает книги не из архива, у которых поле author в точности равно author, по названию
+func (br *BookRepo) GetByAuthorName(ctx context.Context, author string, limit uint, offset int) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByAuthorName")
+	defer end(&err)
+
+	return br.getByFacet(ctx, "author", author, limit, offset)
+}
+
+// GetGenres возвращает жанры книг не из архива с числом книг в каждом, по алфавиту
+func (br *BookRepo) GetGenres(ctx context.Context, limit uint, offset int) (_ []*repodto.BookFacetDTO, err error) {
+	ctx, end := br.start(ctx, "GetGenres")
+	defer end(&err)
+
+	return br.getFacets(ctx, "genre", limit, offset)
+}
+
+// GetAuthors возвращает авторов книг не из архива с числом книг каждого, по алфавиту
+func (br *BookRepo) GetAuthors(ctx context.Context, limit uint, offset int) (_ []*repodto.BookFacetDTO, err error) {
+	ctx, end := br.start(ctx, "GetAuthors")
+	defer end(&err)
+
+	return br.getFacets(ctx, "author", limit, offset)
+}
+
+// getFacets группирует книги по колонке; column подставляется в запрос и приходит только из кода
+func (br *BookRepo) getFacets(ctx context.Context, column string, limit uint, offset int) ([]*repodto.BookFacetDTO, error) {
+	br.logger.Debugf("selecting book %s facets", column)
+
+	query := `select ` + column + ` as value, count(*) as count
+	          from bs.book
+	          where deleted_at is null
+	          group by ` + column + `
+	          order by ` + column + `
+	          limit $1 offset $2`
+
+	var facets []*repodto.BookFacetDTO
+
+	err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &facets, query, limit, offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting book %s facets: %v", column, err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(facets) == 0 {
+		br.logger.Debugf("book %s facets not found", column)
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("found %d book %s facets", len(facets), column)
+
+	return facets, nil
+}
+
+// getByFacet выбирает книги с точным значением колонки, по которой строятся фасеты getFacets
+func (br *BookRepo) getByFacet(ctx context.Context, column, value string, limit uint, offset int) ([]*models.BookModel, error) {
+	br.logger.Debugf("selecting books by %s", column)
+
+	query := `select
+    			id,
+    			title,
+    			author,
+    			publisher,
+    			copies_number,
+    			rarity,
+    			genre,
+    			publishing_year,
+    			language,
+    			age_limit
+	          from bs.book
+	          where deleted_at is null and ` + column + ` = $1
+	          order by title, id
+	          limit $2 offset $3`
+
+	return br.selectBooks(ctx, query, value, limit, offset)
+}
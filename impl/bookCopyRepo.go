@@ -0,0 +1,421 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+	"strings"
+)
+
+const (
+	BookCopyConditionNew     = "New"
+	BookCopyConditionGood    = "Good"
+	BookCopyConditionWorn    = "Worn"
+	BookCopyConditionDamaged = "Damaged"
+)
+
+const (
+	BookCopyStatusAvailable  = "Available"
+	BookCopyStatusIssued     = "Issued"
+	BookCopyStatusInRepair   = "InRepair"
+	BookCopyStatusLost       = "Lost"
+	BookCopyStatusWrittenOff = "WrittenOff"
+)
+
+var bookCopyConditions = map[string]struct{}{
+	BookCopyConditionNew:     {},
+	BookCopyConditionGood:    {},
+	BookCopyConditionWorn:    {},
+	BookCopyConditionDamaged: {},
+}
+
+// статус Issued ставит и снимает только ReservationRepo
+var bookCopyManualStatuses = map[string]struct{}{
+	BookCopyStatusAvailable:  {},
+	BookCopyStatusInRepair:   {},
+	BookCopyStatusLost:       {},
+	BookCopyStatusWrittenOff: {},
+}
+
+// BookCopyRepo — физические экземпляры книг. CopiesNumber книги остается как есть
+// и экземплярами не пересчитывается
+type BookCopyRepo struct {
+	instrumentation
+
+	db        *sqlx.DB
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+	outbox    *outboxWriter
+}
+
+func NewBookCopyRepo(db *sqlx.DB, logger Logger) *BookCopyRepo {
+	return &BookCopyRepo{
+		instrumentation: instrumentation{repo: "book_copy", dbSystem: dbSystemPostgres, table: "bs.book_copy", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		outbox:          newOutboxWriter(db, trmsqlx.DefaultCtxGetter),
+	}
+}
+
+// Create добавляет экземпляр. Пустые состояние и статус означают Good и Available
+func (bcr *BookCopyRepo) Create(ctx context.Context, bookCopy *repomodels.BookCopyModel) (err error) {
+	ctx, end := bcr.start(ctx, "Create", entityIDAttr(bookCopy.ID))
+	defer end(&err)
+
+	bcr.logger.Debugf("inserting book copy with ID: %s", bookCopy.ID)
+
+	if bookCopy.Condition == "" {
+		bookCopy.Condition = BookCopyConditionGood
+	}
+	if bookCopy.Status == "" {
+		bookCopy.Status = BookCopyStatusAvailable
+	}
+	bookCopy.Barcode = normalizeBarcode(bookCopy.Barcode)
+	if err = validateBookCopy(bookCopy); err != nil {
+		bcr.logger.Debugf("error inserting book copy: %v", err)
+		return err
+	}
+
+	query := `insert into bs.book_copy (id, book_id, barcode, condition, location, status, branch_id)
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+
+	err = bcr.trManager.Do(ctx, func(ctx context.Context) error {
+		if err := checkBranchExists(ctx, bcr.getter.DefaultTrOrDB(ctx, bcr.db), bookCopy.BranchID); err != nil {
+			return err
+		}
+
+		result, err := bcr.getter.DefaultTrOrDB(ctx, bcr.db).ExecContext(ctx, query,
+			bookCopy.ID,
+			bookCopy.BookID,
+			bookCopy.Barcode,
+			bookCopy.Condition,
+			bookCopy.Location,
+			bookCopy.Status,
+			bookCopy.BranchID,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBookCopyBarcodeAlreadyExists
+		}
+		if err != nil && isForeignKeyViolation(err) {
+			return errs.ErrBookDoesNotExists
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("bookCopyRepo.Create: expected 1 row affected, got %d", rows)
+		}
+
+		return bcr.recordChange(ctx, bookCopy.ID, AuditOperationCreate, EventBookCopyCreated, nil)
+	})
+	if err != nil && (errors.Is(err, repoerrs.ErrBookCopyBarcodeAlreadyExists) ||
+		errors.Is(err, errs.ErrBookDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrBranchDoesNotExists)) {
+		bcr.logger.Debugf("book copy with barcode %s can't be inserted: %v", bookCopy.Barcode, err)
+		return err
+	}
+	if err != nil {
+		bcr.logger.Debugf("error inserting book copy: %v", err)
+		return err
+	}
+
+	bcr.logger.Debugf("inserted book copy with ID: %s", bookCopy.ID)
+
+	return nil
+}
+
+func (bcr *BookCopyRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *repomodels.BookCopyModel, err error) {
+	ctx, end := bcr.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	bcr.logger.Debugf("selecting book copy with ID: %s", ID)
+
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where id = $1`
+
+	bookCopy, err := bcr.get(ctx, query, ID)
+	if err != nil {
+		bcr.logger.Debugf("error selecting book copy with ID %s: %v", ID, err)
+		return nil, err
+	}
+
+	bcr.logger.Debugf("selected book copy with ID: %s", ID)
+
+	return bookCopy, nil
+}
+
+// GetByBarcode — поиск экземпляра по штрихкоду со сканера
+func (bcr *BookCopyRepo) GetByBarcode(ctx context.Context, barcode string) (_ *repomodels.BookCopyModel, err error) {
+	ctx, end := bcr.start(ctx, "GetByBarcode")
+	defer end(&err)
+
+	bcr.logger.Debugf("selecting book copy by barcode: %s", barcode)
+
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where barcode = $1`
+
+	bookCopy, err := bcr.get(ctx, query, normalizeBarcode(barcode))
+	if err != nil {
+		bcr.logger.Debugf("error selecting book copy by barcode %s: %v", barcode, err)
+		return nil, err
+	}
+
+	bcr.logger.Debugf("selected book copy with barcode: %s", barcode)
+
+	return bookCopy, nil
+}
+
+// GetByReservationID возвращает экземпляр, выданный по бронированию
+func (bcr *BookCopyRepo) GetByReservationID(ctx context.Context, reservationID uuid.UUID) (_ *repomodels.BookCopyModel, err error) {
+	ctx, end := bcr.start(ctx, "GetByReservationID", entityIDAttr(reservationID))
+	defer end(&err)
+
+	bcr.logger.Debugf("selecting book copy of reservation with ID: %s", reservationID)
+
+	query := `select c.id, c.book_id, c.barcode, c.condition, c.location, c.status, c.branch_id, c.created_at
+			  from bs.book_copy c join bs.reservation r on r.copy_id = c.id
+			  where r.id = $1`
+
+	bookCopy, err := bcr.get(ctx, query, reservationID)
+	if err != nil {
+		bcr.logger.Debugf("error selecting book copy of reservation with ID %s: %v", reservationID, err)
+		return nil, err
+	}
+
+	bcr.logger.Debugf("selected book copy of reservation with ID: %s", reservationID)
+
+	return bookCopy, nil
+}
+
+// GetByBookID возвращает экземпляры книги; пустой status — в любом статусе
+func (bcr *BookCopyRepo) GetByBookID(ctx context.Context, bookID uuid.UUID, status string) (_ []*repomodels.BookCopyModel, err error) {
+	ctx, end := bcr.start(ctx, "GetByBookID", entityIDAttr(bookID))
+	defer end(&err)
+
+	bcr.logger.Debugf("selecting copies of book with ID: %s", bookID)
+
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where book_id = $1 and ($2 = '' or status::text = $2)
+			  order by barcode`
+
+	var copies []*repomodels.BookCopyModel
+	err = bcr.getter.DefaultTrOrDB(ctx, bcr.db).SelectContext(ctx, &copies, query, bookID, status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		bcr.logger.Debugf("error selecting book copies: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(copies) == 0 {
+		bcr.logger.Debugf("copies of book with ID not found: %s", bookID)
+		return nil, repoerrs.ErrBookCopyDoesNotExists
+	}
+
+	bcr.logger.Debugf("found %d copies of book with ID: %s", len(copies), bookID)
+
+	return copies, nil
+}
+
+// Update меняет штрихкод, состояние, место, статус и филиал экземпляра. Выданный экземпляр
+// можно только отметить потерянным, остальное делается через возврат по бронированию
+func (bcr *BookCopyRepo) Update(ctx context.Context, bookCopy *repomodels.BookCopyModel) (err error) {
+	ctx, end := bcr.start(ctx, "Update", entityIDAttr(bookCopy.ID))
+	defer end(&err)
+
+	bcr.logger.Debugf("updating book copy with ID: %s", bookCopy.ID)
+
+	bookCopy.Barcode = normalizeBarcode(bookCopy.Barcode)
+	if err = validateBookCopy(bookCopy); err != nil {
+		bcr.logger.Debugf("error updating book copy: %v", err)
+		return err
+	}
+
+	query := `update bs.book_copy
+			  set barcode = $1,
+			      condition = $2,
+			      location = $3,
+			      status = $4,
+			      branch_id = $5
+			  where id = $6`
+
+	err = bcr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := bcr.getForUpdate(ctx, bookCopy.ID)
+		if err != nil {
+			return err
+		}
+		if before.Status == BookCopyStatusIssued && bookCopy.Status != BookCopyStatusLost {
+			return repoerrs.ErrBookCopyIsIssued
+		}
+		// потерянный читателем экземпляр остается за бронированием до его закрытия,
+		// иначе его можно было бы выдать второму читателю
+		if before.Status == BookCopyStatusLost && bookCopy.Status != BookCopyStatusLost {
+			if err = bcr.checkNotLinkedToOpenReservation(ctx, bookCopy.ID); err != nil {
+				return err
+			}
+		}
+		if err = checkBranchExists(ctx, bcr.getter.DefaultTrOrDB(ctx, bcr.db), bookCopy.BranchID); err != nil {
+			return err
+		}
+
+		_, err = bcr.getter.DefaultTrOrDB(ctx, bcr.db).ExecContext(ctx, query,
+			bookCopy.Barcode,
+			bookCopy.Condition,
+			bookCopy.Location,
+			bookCopy.Status,
+			bookCopy.BranchID,
+			bookCopy.ID,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBookCopyBarcodeAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		return bcr.recordChange(ctx, bookCopy.ID, AuditOperationUpdate, EventBookCopyUpdated, before)
+	})
+	if err != nil && (errors.Is(err, repoerrs.ErrBookCopyDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrBookCopyIsIssued) ||
+		errors.Is(err, repoerrs.ErrBookCopyIsLostByReader) ||
+		errors.Is(err, repoerrs.ErrBookCopyBarcodeAlreadyExists) ||
+		errors.Is(err, repoerrs.ErrBranchDoesNotExists)) {
+		bcr.logger.Debugf("book copy with ID %s can't be updated: %v", bookCopy.ID, err)
+		return err
+	}
+	if err != nil {
+		bcr.logger.Debugf("error updating book copy: %v", err)
+		return err
+	}
+
+	bcr.logger.Debugf("updated book copy with ID: %s", bookCopy.ID)
+
+	return nil
+}
+
+// Delete удаляет ошибочно заведенный экземпляр; списанные экземпляры помечаются статусом WrittenOff
+func (bcr *BookCopyRepo) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := bcr.start(ctx, "Delete", entityIDAttr(ID))
+	defer end(&err)
+
+	bcr.logger.Debugf("deleting book copy with ID: %s", ID)
+
+	query := `delete from bs.book_copy where id = $1`
+
+	err = bcr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := bcr.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+		if before.Status == BookCopyStatusIssued {
+			return repoerrs.ErrBookCopyIsIssued
+		}
+
+		if _, err = bcr.getter.DefaultTrOrDB(ctx, bcr.db).ExecContext(ctx, query, ID); err != nil {
+			return err
+		}
+
+		if err = bcr.audit.write(ctx, AuditEntityBookCopy, ID, AuditOperationDelete, before, nil); err != nil {
+			return err
+		}
+
+		return bcr.outbox.write(ctx, AuditEntityBookCopy, ID, EventBookCopyDeleted, before)
+	})
+	if err != nil && (errors.Is(err, repoerrs.ErrBookCopyDoesNotExists) || errors.Is(err, repoerrs.ErrBookCopyIsIssued)) {
+		bcr.logger.Debugf("book copy with ID %s can't be deleted: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		bcr.logger.Debugf("error deleting book copy: %v", err)
+		return err
+	}
+
+	bcr.logger.Debugf("deleted book copy with ID: %s", ID)
+
+	return nil
+}
+
+func (bcr *BookCopyRepo) get(ctx context.Context, query string, args ...any) (*repomodels.BookCopyModel, error) {
+	var bookCopy repomodels.BookCopyModel
+	err := bcr.getter.DefaultTrOrDB(ctx, bcr.db).GetContext(ctx, &bookCopy, query, args...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repoerrs.ErrBookCopyDoesNotExists
+	}
+
+	return &bookCopy, nil
+}
+
+func (bcr *BookCopyRepo) getForUpdate(ctx context.Context, ID uuid.UUID) (*repomodels.BookCopyModel, error) {
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where id = $1
+			  for update`
+
+	return bcr.get(ctx, query, ID)
+}
+
+func (bcr *BookCopyRepo) checkNotLinkedToOpenReservation(ctx context.Context, ID uuid.UUID) error {
+	query := `select exists(select 1 from bs.reservation where copy_id = $1 and state != $2)`
+
+	var linked bool
+	if err := bcr.getter.DefaultTrOrDB(ctx, bcr.db).GetContext(ctx, &linked, query, ID, impl.ReservationClosed); err != nil {
+		return err
+	}
+	if linked {
+		return repoerrs.ErrBookCopyIsLostByReader
+	}
+
+	return nil
+}
+
+func (bcr *BookCopyRepo) recordChange(ctx context.Context, ID uuid.UUID, operation, eventType string, before *repomodels.BookCopyModel) error {
+	after, err := bcr.getForUpdate(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	if err = bcr.audit.write(ctx, AuditEntityBookCopy, ID, operation, before, after); err != nil {
+		return err
+	}
+
+	return bcr.outbox.write(ctx, AuditEntityBookCopy, ID, eventType, after)
+}
+
+func validateBookCopy(bookCopy *repomodels.BookCopyModel) error {
+	if bookCopy.Barcode == "" {
+		return repoerrs.ErrInvalidBookCopyBarcode
+	}
+	if _, ok := bookCopyConditions[bookCopy.Condition]; !ok {
+		return repoerrs.ErrInvalidBookCopyCondition
+	}
+	if _, ok := bookCopyManualStatuses[bookCopy.Status]; !ok {
+		return repoerrs.ErrInvalidBookCopyStatus
+	}
+
+	return nil
+}
+
+// normalizeBarcode убирает пробелы по краям: сканеры часто дописывают перевод строки
+func normalizeBarcode(barcode string) string {
+	return strings.TrimSpace(barcode)
+}
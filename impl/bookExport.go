@@ -0,0 +1,289 @@
+package impl
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"io"
+	"strconv"
+)
+
+const (
+	BookExportFormatCSV        = "csv"
+	BookExportFormatJSONLD     = "jsonld"
+	BookExportFormatDublinCore = "dc"
+)
+
+// bookExportColumns совпадают с колонками, которые понимает Import, так что выгрузку можно загрузить обратно
+var bookExportColumns = []string{
+	"id", "title", "author", "publisher", "copies_number", "rarity", "genre", "publishing_year", "language", "age_limit",
+}
+
+// bookExportEncoder пишет документ выгрузки по одной книге за раз
+type bookExportEncoder interface {
+	begin() error
+	encode(book *repomodels.BookModel) error
+	end() error
+}
+
+// Export построчно выгружает каталог в w в формате csv, jsonld (schema.org Book) или dc (Dublin Core XML).
+// Фильтры те же, что у GetByParams; нулевой Limit означает весь каталог. Возвращает число выгруженных книг
+func (br *BookRepo) Export(ctx context.Context, w io.Writer, format string, params *repodto.BookParamsDTO) (_ int, err error) {
+	ctx, end := br.start(ctx, "Export")
+	defer end(&err)
+
+	br.logger.Debugf("exporting books as %s", format)
+
+	bw := bufio.NewWriter(w)
+	encoder, err := newBookExportEncoder(bw, format)
+	if err != nil {
+		br.logger.Debugf("error exporting books: %v", err)
+		return 0, err
+	}
+	if params == nil {
+		params = &repodto.BookParamsDTO{}
+	}
+
+	query := `select
+    			id,
+    			title,
+    			author,
+    			publisher,
+    			copies_number,
+    			rarity,
+    			genre,
+    			publishing_year,
+    			language,
+    			age_limit,
+    			isbn_13
+	          from bs.book
+	          where ` + bookParamsCondition + `
+	          order by title, id
+	          limit nullif($10, 0) offset $11`
+
+	args, err := bookParamsArgs(ctx, params)
+	if err != nil {
+		br.logger.Debugf("error exporting books: %v", err)
+		return 0, err
+	}
+
+	rows, err := br.getter.DefaultTrOrDB(ctx, br.db).QueryxContext(ctx, query, args...)
+	if err != nil {
+		br.logger.Debugf("error selecting books for export: %v", err)
+		return 0, err
+	}
+	defer rows.Close()
+
+	if err = encoder.begin(); err != nil {
+		br.logger.Debugf("error exporting books: %v", err)
+		return 0, err
+	}
+
+	count := 0
+	for rows.Next() {
+		var book repomodels.BookModel
+		if err = rows.StructScan(&book); err != nil {
+			br.logger.Debugf("error scanning book for export: %v", err)
+			return count, err
+		}
+		if err = encoder.encode(&book); err != nil {
+			br.logger.Debugf("error exporting book with ID %s: %v", book.ID, err)
+			return count, err
+		}
+		count++
+	}
+	if err = rows.Err(); err != nil {
+		br.logger.Debugf("error selecting books for export: %v", err)
+		return count, err
+	}
+
+	if err = encoder.end(); err != nil {
+		br.logger.Debugf("error exporting books: %v", err)
+		return count, err
+	}
+	if err = bw.Flush(); err != nil {
+		br.logger.Debugf("error exporting books: %v", err)
+		return count, err
+	}
+	setRowsAffected(ctx, int64(count))
+
+	br.logger.Debugf("exported %d books", count)
+
+	return count, nil
+}
+
+func newBookExportEncoder(w io.Writer, format string) (bookExportEncoder, error) {
+	switch format {
+	case BookExportFormatCSV:
+		return &bookCSVEncoder{w: csv.NewWriter(w)}, nil
+	case BookExportFormatJSONLD:
+		return &bookJSONLDEncoder{w: w}, nil
+	case BookExportFormatDublinCore:
+		return &bookDublinCoreEncoder{w: w, encoder: xml.NewEncoder(w)}, nil
+	default:
+		return nil, repoerrs.ErrUnknownBookExportFormat
+	}
+}
+
+type bookCSVEncoder struct {
+	w *csv.Writer
+}
+
+func (bce *bookCSVEncoder) begin() error {
+	return bce.w.Write(bookExportColumns)
+}
+
+func (bce *bookCSVEncoder) encode(book *repomodels.BookModel) error {
+	return bce.w.Write([]string{
+		book.ID.String(),
+		book.Title,
+		book.Author,
+		book.Publisher,
+		strconv.FormatUint(uint64(book.CopiesNumber), 10),
+		book.Rarity,
+		book.Genre,
+		strconv.FormatUint(uint64(book.PublishingYear), 10),
+		book.Language,
+		strconv.FormatUint(uint64(book.AgeLimit), 10),
+	})
+}
+
+func (bce *bookCSVEncoder) end() error {
+	bce.w.Flush()
+	return bce.w.Error()
+}
+
+type schemaOrgThing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type schemaOrgBook struct {
+	Type            string          `json:"@type"`
+	ID              string          `json:"@id"`
+	Identifier      string          `json:"identifier"`
+	Name            string          `json:"name"`
+	Author          *schemaOrgThing `json:"author,omitempty"`
+	Publisher       *schemaOrgThing `json:"publisher,omitempty"`
+	Genre           string          `json:"genre,omitempty"`
+	DatePublished   string          `json:"datePublished,omitempty"`
+	InLanguage      string          `json:"inLanguage,omitempty"`
+	TypicalAgeRange string          `json:"typicalAgeRange,omitempty"`
+	ISBN            string          `json:"isbn,omitempty"`
+}
+
+// bookJSONLDEncoder пишет один документ с @graph, а не JSON Lines: так выгрузку принимают
+// валидаторы schema.org и поисковые системы
+type bookJSONLDEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (bje *bookJSONLDEncoder) begin() error {
+	_, err := io.WriteString(bje.w, `{"@context":"https://schema.org","@graph":[`)
+	return err
+}
+
+func (bje *bookJSONLDEncoder) encode(book *repomodels.BookModel) error {
+	item := &schemaOrgBook{
+		Type:       "Book",
+		ID:         "urn:uuid:" + book.ID.String(),
+		Identifier: book.ID.String(),
+		Name:       book.Title,
+		Genre:      book.Genre,
+		InLanguage: book.Language,
+	}
+	if book.Author != "" {
+		item.Author = &schemaOrgThing{Type: "Person", Name: book.Author}
+	}
+	if book.Publisher != "" {
+		item.Publisher = &schemaOrgThing{Type: "Organization", Name: book.Publisher}
+	}
+	if book.PublishingYear != 0 {
+		item.DatePublished = strconv.FormatUint(uint64(book.PublishingYear), 10)
+	}
+	if book.AgeLimit != 0 {
+		item.TypicalAgeRange = fmt.Sprintf("%d-", book.AgeLimit)
+	}
+	if book.ISBN13 != nil {
+		item.ISBN = *book.ISBN13
+	}
+
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if bje.count > 0 {
+		if _, err = io.WriteString(bje.w, ","); err != nil {
+			return err
+		}
+	}
+	bje.count++
+
+	_, err = bje.w.Write(data)
+	return err
+}
+
+func (bje *bookJSONLDEncoder) end() error {
+	_, err := io.WriteString(bje.w, "]}\n")
+	return err
+}
+
+type dublinCoreRecord struct {
+	XMLName    xml.Name `xml:"oai_dc:dc"`
+	Identifier []string `xml:"dc:identifier"`
+	Title      string   `xml:"dc:title"`
+	Creator    string   `xml:"dc:creator,omitempty"`
+	Publisher  string   `xml:"dc:publisher,omitempty"`
+	Date       string   `xml:"dc:date,omitempty"`
+	Language   string   `xml:"dc:language,omitempty"`
+	Subject    string   `xml:"dc:subject,omitempty"`
+	Type       string   `xml:"dc:type"`
+}
+
+// bookDublinCoreEncoder пишет записи oai_dc:dc внутри общего корня; пространства имен
+// объявлены на корне, поэтому префиксы в тегах записей задаются буквально
+type bookDublinCoreEncoder struct {
+	w       io.Writer
+	encoder *xml.Encoder
+}
+
+func (bde *bookDublinCoreEncoder) begin() error {
+	_, err := io.WriteString(bde.w, xml.Header+
+		`<records xmlns:oai_dc="http://www.openarchives.org/OAI/2.0/oai_dc/" xmlns:dc="http://purl.org/dc/elements/1.1/">`)
+	return err
+}
+
+func (bde *bookDublinCoreEncoder) encode(book *repomodels.BookModel) error {
+	record := &dublinCoreRecord{
+		Identifier: []string{"urn:uuid:" + book.ID.String()},
+		Title:      book.Title,
+		Creator:    book.Author,
+		Publisher:  book.Publisher,
+		Language:   book.Language,
+		Subject:    book.Genre,
+		Type:       "Text",
+	}
+	if book.PublishingYear != 0 {
+		record.Date = strconv.FormatUint(uint64(book.PublishingYear), 10)
+	}
+	if book.ISBN13 != nil {
+		record.Identifier = append(record.Identifier, "urn:isbn:"+*book.ISBN13)
+	}
+
+	return bde.encoder.Encode(record)
+}
+
+func (bde *bookDublinCoreEncoder) end() error {
+	if err := bde.encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(bde.w, "</records>\n")
+	return err
+}
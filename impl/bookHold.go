@@ -0,0 +1,158 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+)
+
+// PlaceHold ставит читателя в очередь ожидания книги. Очередь снимается, когда читатель
+// бронирует книгу (Create) или отказывается от ожидания (CancelHold)
+func (rr *ReservationRepo) PlaceHold(ctx context.Context, hold *repomodels.BookHoldModel) (err error) {
+	ctx, end := rr.start(ctx, "PlaceHold", entityIDAttr(hold.BookID))
+	defer end(&err)
+
+	rr.logger.Debugf("placing hold on book %s for reader %s", hold.BookID, hold.ReaderID)
+
+	query := `insert into bs.book_hold (id, book_id, reader_id) values ($1, $2, $3)`
+
+	err = rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		if err := rr.checkHoldParties(ctx, hold); err != nil {
+			return err
+		}
+
+		_, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, hold.ID, hold.BookID, hold.ReaderID)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBookHoldAlreadyExists
+		}
+		if err != nil && isForeignKeyViolation(err) {
+			// книга удалена между проверкой и вставкой: после ошибки транзакция прервана, и уточнить нельзя
+			return errs.ErrBookDoesNotExists
+		}
+		if err != nil {
+			return err
+		}
+
+		after := map[string]any{"id": hold.ID, "reader_id": hold.ReaderID}
+
+		return rr.audit.write(ctx, AuditEntityBookHold, hold.BookID, AuditOperationCreate, nil, after)
+	})
+	if err != nil && (errors.Is(err, repoerrs.ErrBookHoldAlreadyExists) ||
+		errors.Is(err, errs.ErrBookDoesNotExists) ||
+		errors.Is(err, errs.ErrReaderDoesNotExists)) {
+		rr.logger.Debugf("hold on book %s can't be placed: %v", hold.BookID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error placing hold: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("placed hold on book %s for reader %s", hold.BookID, hold.ReaderID)
+
+	return nil
+}
+
+// checkHoldParties проверяет, что книга (не удаленная) и читатель существуют
+func (rr *ReservationRepo) checkHoldParties(ctx context.Context, hold *repomodels.BookHoldModel) error {
+	query := `select exists (select 1 from bs.book where id = $1 and deleted_at is null) as book_exists,
+	                 exists (select 1 from bs.reader where id = $2) as reader_exists`
+
+	var exists struct {
+		Book   bool `db:"book_exists"`
+		Reader bool `db:"reader_exists"`
+	}
+	if err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &exists, query, hold.BookID, hold.ReaderID); err != nil {
+		return err
+	}
+	if !exists.Book {
+		return errs.ErrBookDoesNotExists
+	}
+	if !exists.Reader {
+		return errs.ErrReaderDoesNotExists
+	}
+
+	return nil
+}
+
+func (rr *ReservationRepo) CancelHold(ctx context.Context, bookID, readerID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "CancelHold", entityIDAttr(bookID))
+	defer end(&err)
+
+	rr.logger.Debugf("cancelling hold on book %s for reader %s", bookID, readerID)
+
+	err = rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		rows, err := rr.deleteHold(ctx, bookID, readerID)
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows == 0 {
+			return repoerrs.ErrBookHoldDoesNotExists
+		}
+
+		return nil
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrBookHoldDoesNotExists) {
+		rr.logger.Debugf("hold on book %s for reader %s not found", bookID, readerID)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error cancelling hold: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("cancelled hold on book %s for reader %s", bookID, readerID)
+
+	return nil
+}
+
+// GetHolds возвращает очередь ожидания книги в порядке постановки
+func (rr *ReservationRepo) GetHolds(ctx context.Context, bookID uuid.UUID) (_ []*repomodels.BookHoldModel, err error) {
+	ctx, end := rr.start(ctx, "GetHolds", entityIDAttr(bookID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting holds on book with ID: %s", bookID)
+
+	query := `select id, book_id, reader_id, created_at
+			  from bs.book_hold
+			  where book_id = $1
+			  order by created_at, id`
+
+	var holds []*repomodels.BookHoldModel
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &holds, query, bookID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rr.logger.Debugf("error selecting holds: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(holds) == 0 {
+		rr.logger.Debugf("holds on book with ID not found: %s", bookID)
+		return nil, repoerrs.ErrBookHoldDoesNotExists
+	}
+
+	rr.logger.Debugf("found %d holds on book with ID: %s", len(holds), bookID)
+
+	return holds, nil
+}
+
+// deleteHold снимает читателя с очереди и пишет это в журнал; отсутствие записи не ошибка
+func (rr *ReservationRepo) deleteHold(ctx context.Context, bookID, readerID uuid.UUID) (int64, error) {
+	query := `delete from bs.book_hold where book_id = $1 and reader_id = $2 returning id`
+
+	var holdIDs []uuid.UUID
+	if err := rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &holdIDs, query, bookID, readerID); err != nil {
+		return 0, err
+	}
+	for _, holdID := range holdIDs {
+		before := map[string]any{"id": holdID, "reader_id": readerID}
+		if err := rr.audit.write(ctx, AuditEntityBookHold, bookID, AuditOperationDelete, before, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(holdIDs)), nil
+}
@@ -0,0 +1,650 @@
+package impl
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	BookImportFormatCSV   = "csv"
+	BookImportFormatJSONL = "jsonl"
+)
+
+const (
+	BookImportKeyID          = "id"
+	BookImportKeyTitle       = "title"
+	BookImportKeyTitleAuthor = "title_author"
+)
+
+const (
+	bookImportMaxAgeLimit   = 18
+	bookImportMaxLineLength = 1 << 20
+)
+
+// bookImportKey — как строка промежуточной таблицы s сопоставляется с книгой b
+type bookImportKey struct {
+	match     string // условие совпадения с существующей книгой
+	partition string // выражение для поиска повторов внутри файла
+}
+
+// по естественному ключу сопоставляются только книги не из архива; по id — любые,
+// иначе вставка упадет на первичном ключе. Строки, которые при загрузке по естественному ключу
+// пошли бы на вставку с уже занятым id, отбрасываются в отчет (dropConflictingImportIDs)
+var bookImportKeys = map[string]bookImportKey{
+	BookImportKeyID: {
+		match:     `b.id = s.id`,
+		partition: `s.id`,
+	},
+	BookImportKeyTitle: {
+		match:     `b.title = s.title and b.deleted_at is null`,
+		partition: `s.title`,
+	},
+	BookImportKeyTitleAuthor: {
+		match:     `b.title = s.title and b.author = s.author and b.deleted_at is null`,
+		partition: `s.title, s.author`,
+	},
+}
+
+var bookImportRarities = map[string]struct{}{
+	impl.BookRarityCommon: {},
+	impl.BookRarityRare:   {},
+	impl.BookRarityUnique: {},
+}
+
+// bookImportRow — строка входного файла. Имена полей совпадают с колонками bs.book,
+// а значит, и с заголовком файла, из которого заполнялась база (000002_fill_table_book)
+type bookImportRow struct {
+	ID             *uuid.UUID `json:"id"`
+	Title          string     `json:"title"`
+	Author         string     `json:"author"`
+	Publisher      string     `json:"publisher"`
+	CopiesNumber   int        `json:"copies_number"`
+	Rarity         string     `json:"rarity"`
+	Genre          string     `json:"genre"`
+	PublishingYear int        `json:"publishing_year"`
+	Language       string     `json:"language"`
+	AgeLimit       int        `json:"age_limit"`
+
+	skipped []string // поля исходной записи, которым нет места в книге (для MARC)
+}
+
+// Import загружает книги из CSV (с заголовком), JSON Lines, MARC21 или MARCXML. Строки копируются через COPY
+// во временную таблицу, после чего одним запросом обновляются совпавшие по ключу книги
+// и другим вставляются новые. Ошибочные строки не прерывают загрузку и попадают в отчет.
+// На время загрузки таблица книг закрыта для записи другими транзакциями
+func (br *BookRepo) Import(ctx context.Context, r io.Reader, params *repodto.BookImportParamsDTO) (_ *repodto.BookImportReportDTO, err error) {
+	ctx, end := br.start(ctx, "Import")
+	defer end(&err)
+
+	br.logger.Debugf("importing books from %s by key %s", params.Format, params.Key)
+
+	key, ok := bookImportKeys[params.Key]
+	if !ok {
+		br.logger.Debugf("unknown book import key: %s", params.Key)
+		return nil, repoerrs.ErrUnknownBookImportKey
+	}
+	next, err := newBookImportReader(r, params)
+	if err != nil {
+		br.logger.Debugf("error reading book import: %v", err)
+		return nil, err
+	}
+
+	var report *repodto.BookImportReportDTO
+	err = br.txRunner.DoOnce(ctx, func(ctx context.Context) error {
+		report = &repodto.BookImportReportDTO{
+			Errors:        make([]*repodto.BookImportErrorDTO, 0),
+			SkippedFields: make(map[string]int),
+		}
+
+		if err := checkBranchExists(ctx, br.getter.DefaultTrOrDB(ctx, br.db), params.BranchID); err != nil {
+			return err
+		}
+
+		staged, err := br.stageImport(ctx, next, params.Key, report)
+		if err != nil {
+			return err
+		}
+		superseded, err := br.dropSupersededImportRows(ctx, key, report)
+		if err != nil {
+			return err
+		}
+
+		if _, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, `lock table bs.book in share row exclusive mode`); err != nil {
+			return err
+		}
+		conflicting, err := br.dropConflictingImportIDs(ctx, key, report)
+		if err != nil {
+			return err
+		}
+		mismatched, err := br.dropInventoryMismatches(ctx, key, report)
+		if err != nil {
+			return err
+		}
+		if report.Updated, err = br.updateImported(ctx, key); err != nil {
+			return err
+		}
+		if report.Inserted, err = br.insertImported(ctx, key); err != nil {
+			return err
+		}
+		if err = br.linkImportedAuthors(ctx, key); err != nil {
+			return err
+		}
+		if params.BranchID != nil {
+			if err = br.addImportedInventory(ctx, key, *params.BranchID); err != nil {
+				return err
+			}
+		}
+
+		report.Unchanged = staged - superseded - conflicting - mismatched - report.Updated - report.Inserted
+		report.Failed += superseded + conflicting + mismatched
+
+		return nil
+	})
+	if err != nil {
+		br.logger.Debugf("error importing books: %v", err)
+		return nil, err
+	}
+
+	br.logger.Debugf("imported books: %d inserted, %d updated, %d failed", report.Inserted, report.Updated, report.Failed)
+
+	return report, nil
+}
+
+// stageImport копирует корректные строки во временную таблицу и возвращает их число
+func (br *BookRepo) stageImport(
+	ctx context.Context,
+	next func() (int, *bookImportRow, error),
+	keyName string,
+	report *repodto.BookImportReportDTO,
+) (int, error) {
+	tx := br.getter.DefaultTrOrDB(ctx, br.db)
+
+	if _, err := tx.ExecContext(ctx, `drop table if exists pg_temp.book_import`); err != nil {
+		return 0, err
+	}
+
+	query := `create temp table book_import (line int not null, like bs.book including defaults) on commit drop`
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.PreparexContext(ctx, pq.CopyIn("book_import",
+		"line", "id", "title", "author", "publisher", "copies_number",
+		"rarity", "genre", "publishing_year", "language", "age_limit",
+	))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	staged := 0
+	for {
+		line, row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		report.Total++
+
+		var rowErr *bookImportRowError
+		if errors.As(err, &rowErr) {
+			report.Errors = append(report.Errors, &repodto.BookImportErrorDTO{Line: line, Field: rowErr.field, Message: rowErr.message})
+			report.Failed++
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		for _, tag := range row.skipped {
+			report.SkippedFields[tag]++
+		}
+		if rowErrs := validateBookImportRow(line, row, keyName); len(rowErrs) > 0 {
+			report.Errors = append(report.Errors, rowErrs...)
+			report.Failed++
+			continue
+		}
+
+		ID := uuid.New()
+		if row.ID != nil {
+			ID = *row.ID
+		}
+
+		_, err = stmt.ExecContext(ctx,
+			line,
+			ID,
+			row.Title,
+			row.Author,
+			row.Publisher,
+			row.CopiesNumber,
+			row.Rarity,
+			row.Genre,
+			row.PublishingYear,
+			row.Language,
+			row.AgeLimit,
+		)
+		if err != nil {
+			return 0, err
+		}
+		staged++
+	}
+
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return 0, err
+	}
+
+	return staged, nil
+}
+
+// dropSupersededImportRows оставляет из строк с одинаковым ключом последнюю по файлу
+func (br *BookRepo) dropSupersededImportRows(ctx context.Context, key bookImportKey, report *repodto.BookImportReportDTO) (int, error) {
+	query := fmt.Sprintf(`delete from book_import
+			  where line in (
+			      select line
+			      from (select s.line, row_number() over (partition by %s order by s.line desc) as rn
+			            from book_import s) ranked
+			      where rn > 1)
+			  returning line`, key.partition)
+
+	var lines []int
+	if err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &lines, query); err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		report.Errors = append(report.Errors, &repodto.BookImportErrorDTO{
+			Line:    line,
+			Message: "superseded by a later row with the same key",
+		})
+	}
+
+	return len(lines), nil
+}
+
+// dropConflictingImportIDs убирает строки, которые не совпали с книгой по ключу и поэтому пошли бы
+// на вставку, но чей id уже занят книгой в базе или более поздней строкой файла. Без этого одна
+// такая строка роняла бы всю загрузку на первичном ключе. Вызывается под блокировкой таблицы книг
+func (br *BookRepo) dropConflictingImportIDs(ctx context.Context, key bookImportKey, report *repodto.BookImportReportDTO) (int, error) {
+	query := fmt.Sprintf(`with pending as (
+			      select s.line, s.id
+			      from book_import s
+			      where not exists (select 1 from bs.book b where %s)),
+			  conflicting as (
+			      select p.line, exists (select 1 from bs.book b where b.id = p.id) as taken
+			      from pending p
+			      where exists (select 1 from bs.book b where b.id = p.id) or
+			            exists (select 1 from pending q where q.id = p.id and q.line > p.line))
+			  delete from book_import s
+			  using conflicting c
+			  where s.line = c.line
+			  returning s.line, c.taken`, key.match)
+
+	var rows []struct {
+		Line  int  `db:"line"`
+		Taken bool `db:"taken"`
+	}
+	if err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &rows, query); err != nil {
+		return 0, err
+	}
+
+	for _, row := range rows {
+		message := "used by a later row of the file"
+		if row.Taken {
+			message = "already taken by another book"
+		}
+		report.Errors = append(report.Errors, &repodto.BookImportErrorDTO{Line: row.Line, Field: "id", Message: message})
+	}
+
+	return len(rows), nil
+}
+
+// dropInventoryMismatches убирает строки, которые меняли бы copies_number книги с фондом по филиалам:
+// у такой книги общее число — сумма фонда и задается через SetInventory
+func (br *BookRepo) dropInventoryMismatches(ctx context.Context, key bookImportKey, report *repodto.BookImportReportDTO) (int, error) {
+	query := fmt.Sprintf(`delete from book_import s
+			  using bs.book b
+			  where %s and
+			        exists (select 1 from bs.book_inventory i where i.book_id = b.id) and
+			        s.copies_number <> b.copies_number
+			  returning s.line`, key.match)
+
+	var lines []int
+	if err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &lines, query); err != nil {
+		return 0, err
+	}
+
+	for _, line := range lines {
+		report.Errors = append(report.Errors, &repodto.BookImportErrorDTO{
+			Line:    line,
+			Field:   "copies_number",
+			Message: "book has branch inventory, set copies per branch",
+		})
+	}
+
+	return len(lines), nil
+}
+
+// updateImported обновляет совпавшие книги, у которых что-то изменилось, и пишет журнал и события
+// так же, как BookRepo.Update, только одним запросом на всю загрузку
+func (br *BookRepo) updateImported(ctx context.Context, key bookImportKey) (int, error) {
+	query := fmt.Sprintf(`with matched as (
+			      select b.id, to_jsonb(b) as data
+			      from bs.book b join book_import s on %[1]s),
+			  updated as (
+			      update bs.book b
+			      set title = s.title,
+			          author = s.author,
+			          publisher = s.publisher,
+			          copies_number = s.copies_number,
+			          rarity = s.rarity,
+			          genre = s.genre,
+			          publishing_year = s.publishing_year,
+			          language = s.language,
+			          age_limit = s.age_limit,
+			          publisher_id = case when lower(b.publisher) = lower(s.publisher) then b.publisher_id
+			                              else (select pa.publisher_id from bs.publisher_alias pa
+			                                    where lower(pa.alias) = lower(s.publisher)) end
+			      from book_import s
+			      where %[1]s and
+			            (b.title, b.author, b.publisher, b.copies_number, b.rarity,
+			             b.genre, b.publishing_year, b.language, b.age_limit) is distinct from
+			            (s.title, s.author, s.publisher, s.copies_number, s.rarity,
+			             s.genre, s.publishing_year, s.language, s.age_limit)
+			      returning b.*),
+			  audited as (
+			      insert into bs.audit_log
+			          (entity_type, entity_id, operation, before_data, after_data, actor_id, request_id)
+			      select $1, u.id, $2,
+			             (select jsonb_object_agg(f.key, f.value) from jsonb_each(m.data) f
+			              where to_jsonb(u) -> f.key is distinct from f.value),
+			             (select jsonb_object_agg(f.key, f.value) from jsonb_each(to_jsonb(u)) f
+			              where m.data -> f.key is distinct from f.value),
+			             $3, $4
+			      from updated u join matched m on m.id = u.id),
+			  unlinked as (
+			      delete from bs.book_author ba
+			      using updated u, matched m, bs.author_alias a
+			      where m.id = u.id and ba.book_id = u.id and ba.role = 'Author' and
+			            lower(m.data ->> 'author') <> lower(u.author) and
+			            a.author_id = ba.author_id and lower(a.alias) = lower(m.data ->> 'author')
+			      returning ba.book_id, ba.author_id),
+			  unlinked_audited as (
+			      insert into bs.audit_log
+			          (entity_type, entity_id, operation, before_data, after_data, actor_id, request_id)
+			      select $6, ul.book_id, $7, jsonb_build_object('author_id', ul.author_id, 'role', 'Author'), null, $3, $4
+			      from unlinked ul),
+			  published as (
+			      insert into bs.outbox (aggregate_type, aggregate_id, event_type, payload)
+			      select $1, u.id, $5, %[2]s from updated u
+			      returning 1)
+			  select count(*) from published`, key.match, outboxPayloadSQL(bookSnapshot{}, "u"))
+
+	actorID, requestID := auditContext(ctx)
+
+	var updated int
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &updated, query,
+		AuditEntityBook,
+		AuditOperationUpdate,
+		actorID,
+		requestID,
+		EventBookUpdated,
+		AuditEntityBookAuthor,
+		AuditOperationDelete,
+	)
+
+	return updated, err
+}
+
+// insertImported вставляет строки, для которых не нашлось книги по ключу
+func (br *BookRepo) insertImported(ctx context.Context, key bookImportKey) (int, error) {
+	query := fmt.Sprintf(`with inserted as (
+			      insert into bs.book
+			          (id, title, author, publisher, copies_number, rarity, genre, publishing_year, language, age_limit,
+			           publisher_id)
+			      select s.id, s.title, s.author, s.publisher, s.copies_number,
+			             s.rarity, s.genre, s.publishing_year, s.language, s.age_limit,
+			             (select pa.publisher_id from bs.publisher_alias pa where lower(pa.alias) = lower(s.publisher))
+			      from book_import s
+			      where not exists (select 1 from bs.book b where %s)
+			      returning *),
+			  audited as (
+			      insert into bs.audit_log
+			          (entity_type, entity_id, operation, before_data, after_data, actor_id, request_id)
+			      select $1, i.id, $2, null, to_jsonb(i), $3, $4
+			      from inserted i),
+			  published as (
+			      insert into bs.outbox (aggregate_type, aggregate_id, event_type, payload)
+			      select $1, i.id, $5, %s from inserted i
+			      returning 1)
+			  select count(*) from published`, key.match, outboxPayloadSQL(bookSnapshot{}, "i"))
+
+	actorID, requestID := auditContext(ctx)
+
+	var inserted int
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &inserted, query,
+		AuditEntityBook,
+		AuditOperationCreate,
+		actorID,
+		requestID,
+		EventBookCreated,
+	)
+
+	return inserted, err
+}
+
+// linkImportedAuthors связывает загруженные книги с авторами из справочника по полю author,
+// как linkDirectories для одной книги. Уже существующие связи не меняются
+func (br *BookRepo) linkImportedAuthors(ctx context.Context, key bookImportKey) error {
+	query := fmt.Sprintf(`with linked as (
+			      insert into bs.book_author (book_id, author_id)
+			      select b.id, a.author_id
+			      from bs.book b
+			          join book_import s on %s
+			          join bs.author_alias a on lower(a.alias) = lower(b.author)
+			      on conflict do nothing
+			      returning book_id, author_id, role, position)
+			  insert into bs.audit_log
+			      (entity_type, entity_id, operation, before_data, after_data, actor_id, request_id)
+			  select $1, l.book_id, $2, null,
+			         jsonb_build_object('author_id', l.author_id, 'role', l.role, 'position', l.position), $3, $4
+			  from linked l`, key.match)
+
+	actorID, requestID := auditContext(ctx)
+
+	_, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query,
+		AuditEntityBookAuthor,
+		AuditOperationCreate,
+		actorID,
+		requestID,
+	)
+
+	return err
+}
+
+// addImportedInventory заносит в фонд филиала загруженные книги, которые еще не учитываются по филиалам
+func (br *BookRepo) addImportedInventory(ctx context.Context, key bookImportKey, branchID uuid.UUID) error {
+	query := fmt.Sprintf(`insert into bs.book_inventory (book_id, branch_id, copies_number)
+			  select b.id, $1, b.copies_number
+			  from bs.book b join book_import s on %s
+			  where not exists (select 1 from bs.book_inventory i where i.book_id = b.id)`, key.match)
+
+	_, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, branchID)
+
+	return err
+}
+
+// bookImportRowError — строку не удалось разобрать; загрузка продолжается со следующей
+type bookImportRowError struct {
+	field   string
+	message string
+}
+
+func (e *bookImportRowError) Error() string {
+	return e.message
+}
+
+// newBookImportReader возвращает функцию, которая на каждый вызов отдает номер строки файла
+// и разобранную строку, а в конце — io.EOF
+func newBookImportReader(r io.Reader, params *repodto.BookImportParamsDTO) (func() (int, *bookImportRow, error), error) {
+	switch params.Format {
+	case BookImportFormatCSV:
+		return newBookImportCSVReader(r)
+	case BookImportFormatJSONL:
+		return newBookImportJSONLReader(r), nil
+	case BookImportFormatMARC21, BookImportFormatMARCXML:
+		return newMARCImportReader(r, params.Format, params.Defaults), nil
+	default:
+		return nil, repoerrs.ErrUnknownBookImportFormat
+	}
+}
+
+func newBookImportCSVReader(r io.Reader) (func() (int, *bookImportRow, error), error) {
+	reader := csv.NewReader(r)
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]int, len(header))
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	reader.FieldsPerRecord = len(header)
+
+	return func() (int, *bookImportRow, error) {
+		record, err := reader.Read()
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return parseErr.StartLine, nil, &bookImportRowError{message: parseErr.Err.Error()}
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row, err := parseBookImportRecord(record, columns)
+
+		return line, row, err
+	}, nil
+}
+
+func parseBookImportRecord(record []string, columns map[string]int) (*bookImportRow, error) {
+	value := func(column string) string {
+		if i, ok := columns[column]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+	number := func(column string) (int, error) {
+		if value(column) == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(value(column))
+		if err != nil {
+			return 0, &bookImportRowError{field: column, message: "not an integer"}
+		}
+		return n, nil
+	}
+
+	row := &bookImportRow{
+		Title:     value("title"),
+		Author:    value("author"),
+		Publisher: value("publisher"),
+		Rarity:    value("rarity"),
+		Genre:     value("genre"),
+		Language:  value("language"),
+	}
+
+	if idStr := value("id"); idStr != "" {
+		ID, err := uuid.Parse(idStr)
+		if err != nil {
+			return nil, &bookImportRowError{field: "id", message: "not a valid UUID"}
+		}
+		row.ID = &ID
+	}
+
+	var err error
+	if row.CopiesNumber, err = number("copies_number"); err != nil {
+		return nil, err
+	}
+	if row.PublishingYear, err = number("publishing_year"); err != nil {
+		return nil, err
+	}
+	if row.AgeLimit, err = number("age_limit"); err != nil {
+		return nil, err
+	}
+
+	return row, nil
+}
+
+func newBookImportJSONLReader(r io.Reader) func() (int, *bookImportRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), bookImportMaxLineLength)
+	line := 0
+
+	return func() (int, *bookImportRow, error) {
+		for scanner.Scan() {
+			line++
+			data := scanner.Bytes()
+			if len(strings.TrimSpace(string(data))) == 0 {
+				continue
+			}
+
+			var row bookImportRow
+			if err := json.Unmarshal(data, &row); err != nil {
+				return line, nil, &bookImportRowError{message: err.Error()}
+			}
+
+			return line, &row, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return line, nil, err
+		}
+
+		return line, nil, io.EOF
+	}
+}
+
+func validateBookImportRow(line int, row *bookImportRow, keyName string) []*repodto.BookImportErrorDTO {
+	var rowErrs []*repodto.BookImportErrorDTO
+	fail := func(field, message string) {
+		rowErrs = append(rowErrs, &repodto.BookImportErrorDTO{Line: line, Field: field, Message: message})
+	}
+
+	if keyName == BookImportKeyID && row.ID == nil {
+		fail("id", "required when importing by id")
+	}
+	if row.Title == "" {
+		fail("title", "must not be empty")
+	}
+	if row.Author == "" {
+		fail("author", "must not be empty")
+	}
+	if row.CopiesNumber <= 0 {
+		fail("copies_number", "must be positive")
+	}
+	if _, ok := bookImportRarities[row.Rarity]; !ok {
+		fail("rarity", fmt.Sprintf("must be one of %s, %s, %s", impl.BookRarityCommon, impl.BookRarityRare, impl.BookRarityUnique))
+	}
+	if row.PublishingYear <= 0 || row.PublishingYear > time.Now().Year() {
+		fail("publishing_year", fmt.Sprintf("must be between 1 and %d", time.Now().Year()))
+	}
+	if row.AgeLimit < 0 || row.AgeLimit > bookImportMaxAgeLimit {
+		fail("age_limit", fmt.Sprintf("must be between 0 and %d", bookImportMaxAgeLimit))
+	}
+
+	return rowErrs
+}
@@ -0,0 +1,104 @@
+package impl
+
+import (
+	"github.com/google/uuid"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseBookImportRecord(t *testing.T) {
+	columns := map[string]int{"id": 0, "title": 1, "copies_number": 2, "publishing_year": 3}
+	ID := uuid.MustParse("6f1c5a3e-2b7d-4e8a-9c1f-0d2e3f4a5b6c")
+
+	tests := []struct {
+		name      string
+		record    []string
+		want      *bookImportRow
+		wantField string
+	}{
+		{
+			name:   "full",
+			record: []string{ID.String(), " Title ", "3", "2001"},
+			want:   &bookImportRow{ID: &ID, Title: "Title", CopiesNumber: 3, PublishingYear: 2001},
+		},
+		{
+			name:   "empty numbers and id",
+			record: []string{"", "Title", "", ""},
+			want:   &bookImportRow{Title: "Title"},
+		},
+		{name: "bad id", record: []string{"42", "Title", "3", "2001"}, wantField: "id"},
+		{name: "bad copies number", record: []string{"", "Title", "three", "2001"}, wantField: "copies_number"},
+		{name: "bad publishing year", record: []string{"", "Title", "3", "2001.5"}, wantField: "publishing_year"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := parseBookImportRecord(tt.record, columns)
+			if tt.wantField != "" {
+				rowErr, ok := err.(*bookImportRowError)
+				if !ok || rowErr.field != tt.wantField {
+					t.Fatalf("parseBookImportRecord() error = %v, want row error in field %s", err, tt.wantField)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseBookImportRecord() unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(row, tt.want) {
+				t.Errorf("parseBookImportRecord() = %+v, want %+v", row, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateBookImportRow(t *testing.T) {
+	ID := uuid.New()
+	valid := func() *bookImportRow {
+		return &bookImportRow{
+			ID:             &ID,
+			Title:          "Title",
+			Author:         "Author",
+			CopiesNumber:   1,
+			Rarity:         impl.BookRarityCommon,
+			PublishingYear: 2001,
+			AgeLimit:       12,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(row *bookImportRow)
+		keyName    string
+		wantFields []string
+	}{
+		{name: "valid", modify: func(*bookImportRow) {}, keyName: BookImportKeyID},
+		{name: "no id by title", modify: func(row *bookImportRow) { row.ID = nil }, keyName: BookImportKeyTitle},
+		{name: "no id by id", modify: func(row *bookImportRow) { row.ID = nil }, keyName: BookImportKeyID, wantFields: []string{"id"}},
+		{name: "empty title and author", modify: func(row *bookImportRow) { row.Title, row.Author = "", "" }, keyName: BookImportKeyTitle, wantFields: []string{"title", "author"}},
+		{name: "zero copies", modify: func(row *bookImportRow) { row.CopiesNumber = 0 }, keyName: BookImportKeyTitle, wantFields: []string{"copies_number"}},
+		{name: "unknown rarity", modify: func(row *bookImportRow) { row.Rarity = "legendary" }, keyName: BookImportKeyTitle, wantFields: []string{"rarity"}},
+		{name: "future year", modify: func(row *bookImportRow) { row.PublishingYear = time.Now().Year() + 1 }, keyName: BookImportKeyTitle, wantFields: []string{"publishing_year"}},
+		{name: "age limit too high", modify: func(row *bookImportRow) { row.AgeLimit = bookImportMaxAgeLimit + 1 }, keyName: BookImportKeyTitle, wantFields: []string{"age_limit"}},
+		{name: "negative age limit", modify: func(row *bookImportRow) { row.AgeLimit = -1 }, keyName: BookImportKeyTitle, wantFields: []string{"age_limit"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := valid()
+			tt.modify(row)
+
+			var fields []string
+			for _, rowErr := range validateBookImportRow(7, row, tt.keyName) {
+				if rowErr.Line != 7 {
+					t.Errorf("error in field %s has line %d, want 7", rowErr.Field, rowErr.Line)
+				}
+				fields = append(fields, rowErr.Field)
+			}
+			if !reflect.DeepEqual(fields, tt.wantFields) {
+				t.Errorf("validateBookImportRow() failed fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
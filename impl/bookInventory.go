@@ -0,0 +1,116 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+)
+
+// SetInventory задает число экземпляров книги в филиале. copies_number книги остается суммой
+// по всем филиалам, в том числе нулевой: сервисный слой по-прежнему считает по нему общую доступность
+func (br *BookRepo) SetInventory(ctx context.Context, bookID, branchID uuid.UUID, copiesNumber uint) (err error) {
+	ctx, end := br.start(ctx, "SetInventory", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("setting inventory of book with ID %s in branch %s: %d", bookID, branchID, copiesNumber)
+
+	syncQuery := `update bs.book
+				  set copies_number = (select sum(copies_number) from bs.book_inventory where book_id = $1)
+				  where id = $1`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getActiveForUpdate(ctx, bookID)
+		if err != nil {
+			return err
+		}
+
+		if err = br.upsertInventory(ctx, bookID, branchID, copiesNumber); err != nil {
+			return err
+		}
+
+		after := map[string]any{"branch_id": branchID, "copies_number": copiesNumber}
+		if err = br.audit.write(ctx, AuditEntityBookInventory, bookID, AuditOperationUpdate, nil, after); err != nil {
+			return err
+		}
+
+		if _, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, syncQuery, bookID); err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, bookID, AuditOperationUpdate, EventBookUpdated, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) || errors.Is(err, repoerrs.ErrBranchDoesNotExists)) {
+		br.logger.Debugf("inventory of book with ID %s can't be set: %v", bookID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error setting inventory: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("set inventory of book with ID %s in branch %s", bookID, branchID)
+
+	return nil
+}
+
+// GetInventory возвращает фонд книги по филиалам вместе с числом свободных экземпляров
+func (br *BookRepo) GetInventory(ctx context.Context, bookID uuid.UUID) (_ []*repodto.BookInventoryDTO, err error) {
+	ctx, end := br.start(ctx, "GetInventory", entityIDAttr(bookID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting inventory of book with ID: %s", bookID)
+
+	query := `select
+    			i.branch_id,
+    			b.code as branch_code,
+    			b.name as branch_name,
+    			i.copies_number,
+    			greatest(i.copies_number - (select count(*) from bs.reservation r
+    			                            where r.book_id = i.book_id and r.branch_id = i.branch_id and r.state != $2), 0) as available
+			  from bs.book_inventory i join bs.branch b on b.id = i.branch_id
+			  where i.book_id = $1
+			  order by b.code`
+
+	var inventory []*repodto.BookInventoryDTO
+	err = br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &inventory, query, bookID, impl.ReservationClosed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting inventory of book: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(inventory) == 0 {
+		br.logger.Debugf("inventory of book with ID not found: %s", bookID)
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("found inventory of book with ID %s in %d branches", bookID, len(inventory))
+
+	return inventory, nil
+}
+
+// inventoryTotal возвращает сумму фонда книги по филиалам или nil, если книга не учитывается по филиалам
+func (br *BookRepo) inventoryTotal(ctx context.Context, bookID uuid.UUID) (*uint, error) {
+	query := `select sum(copies_number) from bs.book_inventory where book_id = $1`
+
+	var total *uint
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &total, query, bookID)
+
+	return total, err
+}
+
+func (br *BookRepo) upsertInventory(ctx context.Context, bookID, branchID uuid.UUID, copiesNumber uint) error {
+	query := `insert into bs.book_inventory (book_id, branch_id, copies_number)
+			  values ($1, $2, $3)
+			  on conflict (book_id, branch_id) do update set copies_number = excluded.copies_number`
+
+	_, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, bookID, branchID, copiesNumber)
+	if err != nil && isForeignKeyViolation(err) {
+		return repoerrs.ErrBranchDoesNotExists
+	}
+
+	return err
+}
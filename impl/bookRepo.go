@@ -4,66 +4,116 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
 	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
 	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
 	"github.com/nikitalystsev/BookSmart-services/core/dto"
 	"github.com/nikitalystsev/BookSmart-services/core/models"
 	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
 	"github.com/nikitalystsev/BookSmart-services/intfRepo"
-	"github.com/sirupsen/logrus"
+	"time"
 )
 
 type BookRepo struct {
-	db     *sqlx.DB
-	getter *trmsqlx.CtxGetter
-	logger *logrus.Entry
+	instrumentation
+
+	db       *sqlx.DB
+	getter   *trmsqlx.CtxGetter
+	txRunner *TxRunner
+	audit    *auditWriter
+	outbox   *outboxWriter
+}
+
+var _ intfRepo.IBookRepo = (*BookRepo)(nil)
+
+// NewBookRepo возвращает репозиторий как интерфейс сервисного слоя
+func NewBookRepo(db *sqlx.DB, logger Logger) intfRepo.IBookRepo {
+	return NewBookRepoImpl(db, logger)
+}
+
+// NewBookRepoImpl возвращает BookRepo с методами, которых нет в intfRepo.IBookRepo
+func NewBookRepoImpl(db *sqlx.DB, logger Logger) *BookRepo {
+	return &BookRepo{
+		instrumentation: instrumentation{repo: "book", dbSystem: dbSystemPostgres, table: "bs.book", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		txRunner:        NewTxRunner(db, TxRunnerConfig{}, logger),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		outbox:          newOutboxWriter(db, trmsqlx.DefaultCtxGetter),
+	}
 }
 
-func NewBookRepo(db *sqlx.DB, logger *logrus.Entry) intfRepo.IBookRepo {
-	return &BookRepo{db: db, getter: trmsqlx.DefaultCtxGetter, logger: logger}
+// SetMetrics подключает метрики и к вызовам репозитория, и к повторам его транзакций
+func (br *BookRepo) SetMetrics(metrics *Metrics) {
+	br.instrumentation.SetMetrics(metrics)
+	br.txRunner.SetMetrics(metrics)
 }
 
-func (br *BookRepo) Create(ctx context.Context, book *models.BookModel) error {
-	br.logger.Infof("inserting book with ID: %s", book.ID)
+func (br *BookRepo) Create(ctx context.Context, book *models.BookModel) (err error) {
+	ctx, end := br.start(ctx, "Create", entityIDAttr(book.ID))
+	defer end(&err)
+
+	br.logger.Debugf("inserting book with ID: %s", book.ID)
 
 	query := `insert into bs.book values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
 
-	result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(
-		ctx, query,
-		book.ID,
-		book.Title,
-		book.Author,
-		book.Publisher,
-		book.CopiesNumber,
-		book.Rarity,
-		book.Genre,
-		book.PublishingYear,
-		book.Language,
-		book.AgeLimit,
-	)
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(
+			ctx, query,
+			book.ID,
+			book.Title,
+			book.Author,
+			book.Publisher,
+			book.CopiesNumber,
+			book.Rarity,
+			book.Genre,
+			book.PublishingYear,
+			book.Language,
+			book.AgeLimit,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("bookRepo.Create: expected 1 row affected, got %d", rows)
+		}
+		// новая книга поступает в фонд филиала, в котором ее заводят
+		if branchID := branchIDArg(ctx); branchID != nil {
+			if err = br.upsertInventory(ctx, book.ID, *branchID, book.CopiesNumber); err != nil {
+				return err
+			}
+		}
+		if err = br.linkDirectories(ctx, book.ID, book.Author, book.Publisher, nil); err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, book.ID, AuditOperationCreate, EventBookCreated, nil)
+	})
 	if err != nil {
-		br.logger.Errorf("error inserting book: %v", err)
+		br.logger.Debugf("error inserting book: %v", err)
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		br.logger.Errorf("error inserting book: %v", err)
-		return err
-	}
-	if rows != 1 {
-		br.logger.Errorf("error inserting book: expected 1 row affected, got %d", rows)
-		return errors.New("bookRepo.Create: expected 1 row affected")
-	}
 
-	br.logger.Infof("inserted book with ID: %s", book.ID)
+	br.logger.Debugf("inserted book with ID: %s", book.ID)
 
 	return nil
 }
 
-func (br *BookRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.BookModel, error) {
-	br.logger.Infof("selecting book with ID: %s", ID)
+func (br *BookRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting book with ID: %s", ID)
 
 	query := `select 
     			id, 
@@ -77,26 +127,29 @@ func (br *BookRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.BookMode
     			language, 
     			age_limit
 			  from bs.book 
-			  where id = $1`
+			  where id = $1 and ($2 or deleted_at is null)`
 
 	var book repomodels.BookModel
-	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &book, query, ID)
+	err = br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &book, query, ID, withDeletedBooks(ctx))
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		br.logger.Errorf("error selecting book with ID: %v", err)
+		br.logger.Debugf("error selecting book with ID: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		br.logger.Warnf("book with this ID not found %s", ID)
+		br.logger.Debugf("book with this ID not found %s", ID)
 		return nil, errs.ErrBookDoesNotExists
 	}
 
-	br.logger.Infof("selected book with ID: %s", ID)
+	br.logger.Debugf("selected book with ID: %s", ID)
 
 	return br.convertToBookModel(&book), nil
 }
 
-func (br *BookRepo) GetByTitle(ctx context.Context, title string) (*models.BookModel, error) {
-	br.logger.Infof("selecting book by title: %s", title)
+func (br *BookRepo) GetByTitle(ctx context.Context, title string) (_ *models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByTitle")
+	defer end(&err)
+
+	br.logger.Debugf("selecting book by title: %s", title)
 
 	query := `select 
     			id, 
@@ -110,51 +163,221 @@ func (br *BookRepo) GetByTitle(ctx context.Context, title string) (*models.BookM
     			language, 
     			age_limit
 			  from bs.book 
-			  where title = $1`
+			  where title = $1 and ($2 or deleted_at is null)`
 
 	var book repomodels.BookModel
-	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &book, query, title)
+	err = br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &book, query, title, withDeletedBooks(ctx))
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		br.logger.Errorf("error selecting book by title: %v", err)
+		br.logger.Debugf("error selecting book by title: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		br.logger.Warnf("book with this title not found: %s", title)
+		br.logger.Debugf("book with this title not found: %s", title)
 		return nil, errs.ErrBookDoesNotExists
 	}
 
-	br.logger.Infof("selected book with title: %s", title)
+	br.logger.Debugf("selected book with title: %s", title)
 
 	return br.convertToBookModel(&book), nil
 }
 
-func (br *BookRepo) Delete(ctx context.Context, ID uuid.UUID) error {
-	br.logger.Infof("deleting book with ID: %s", ID)
+// GetByISBN ищет книгу по ISBN-10 или ISBN-13 в любом написании. ISBN уникален только среди книг
+// не из архива, поэтому вместе с архивом первой возвращается действующая книга, затем последняя архивная
+func (br *BookRepo) GetByISBN(ctx context.Context, isbn string) (_ *models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByISBN")
+	defer end(&err)
 
-	query := `delete from bs.book where id = $1`
+	br.logger.Debugf("selecting book by ISBN: %s", isbn)
 
-	result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, ID)
+	isbn13, _, err := NormalizeISBN(isbn)
 	if err != nil {
-		br.logger.Errorf("error deleting book: %v", err)
+		br.logger.Debugf("error selecting book by ISBN: %v", err)
+		return nil, err
+	}
+
+	query := `select 
+    			id, 
+    			title,
+    			author, 
+    			publisher,
+    			copies_number, 
+    			rarity, 
+    			genre, 
+    			publishing_year, 
+    			language, 
+    			age_limit
+			  from bs.book 
+			  where isbn_13 = $1 and ($2 or deleted_at is null)
+			  order by deleted_at desc nulls first
+			  limit 1`
+
+	var book repomodels.BookModel
+	err = br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &book, query, isbn13, withDeletedBooks(ctx))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting book by ISBN: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("book with this ISBN not found: %s", isbn13)
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("selected book with ISBN: %s", isbn13)
+
+	return br.convertToBookModel(&book), nil
+}
+
+// SetISBN сохраняет ISBN книги в обоих форматах; пустая строка стирает его. В модели
+// сервисного слоя ISBN нет, поэтому он задается отдельно от Create и Update
+func (br *BookRepo) SetISBN(ctx context.Context, ID uuid.UUID, isbn string) (err error) {
+	ctx, end := br.start(ctx, "SetISBN", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("setting ISBN of book with ID: %s", ID)
+
+	var isbn13, isbn10 *string
+	if isbn != "" {
+		normalized13, normalized10, err := NormalizeISBN(isbn)
+		if err != nil {
+			br.logger.Debugf("error setting ISBN: %v", err)
+			return err
+		}
+		isbn13 = &normalized13
+		if normalized10 != "" {
+			isbn10 = &normalized10
+		}
+	}
+
+	query := `update bs.book set isbn_10 = $1, isbn_13 = $2 where id = $3`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getActiveForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, isbn10, isbn13, ID)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBookISBNAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, ID, AuditOperationUpdate, EventBookUpdated, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) || errors.Is(err, repoerrs.ErrBookISBNAlreadyExists)) {
+		br.logger.Debugf("ISBN of book with ID %s can't be set: %v", ID, err)
 		return err
 	}
-	rows, err := result.RowsAffected()
 	if err != nil {
-		br.logger.Errorf("error deleting book: %v", err)
+		br.logger.Debugf("error setting ISBN: %v", err)
 		return err
 	}
-	if rows != 1 {
-		br.logger.Errorf("error deleting book: expected 1 row affected, got %d", rows)
-		return errors.New("bookRepo.Delete: expected 1 row affected")
+
+	br.logger.Debugf("set ISBN of book with ID: %s", ID)
+
+	return nil
+}
+
+// Delete переносит книгу в архив: строка остается, чтобы не терять историю
+// бронирований и оценок. Книгу, которая сейчас на руках у читателей, удалить нельзя
+func (br *BookRepo) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := br.start(ctx, "Delete", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("deleting book with ID: %s", ID)
+
+	query := `update bs.book set deleted_at = now(), deleted_by = $1 where id = $2`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getActiveForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		hasActiveReservations, err := br.hasActiveReservations(ctx, ID)
+		if err != nil {
+			return err
+		}
+		if hasActiveReservations {
+			return repoerrs.ErrBookHasActiveReservations
+		}
+
+		var deletedBy *uuid.UUID
+		if actorID, ok := ActorIDFromContext(ctx); ok {
+			deletedBy = &actorID
+		}
+
+		if _, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, deletedBy, ID); err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, ID, AuditOperationDelete, EventBookDeleted, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) || errors.Is(err, repoerrs.ErrBookHasActiveReservations)) {
+		br.logger.Debugf("book with ID %s can't be deleted: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error deleting book: %v", err)
+		return err
 	}
 
-	br.logger.Infof("deleted book with ID: %s", ID)
+	br.logger.Debugf("deleted book with ID: %s", ID)
 
 	return nil
 }
 
-func (br *BookRepo) Update(ctx context.Context, book *models.BookModel) error {
-	br.logger.Infof("updating book with ID: %s", book.ID)
+func (br *BookRepo) Restore(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := br.start(ctx, "Restore", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("restoring book with ID: %s", ID)
+
+	query := `update bs.book set deleted_at = null, deleted_by = null where id = $1`
+
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+		if before.DeletedAt == nil {
+			return repoerrs.ErrBookIsNotDeleted
+		}
+
+		// пока книга была в архиве, ее ISBN мог получить другая книга
+		_, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, ID)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBookISBNAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, ID, AuditOperationRestore, EventBookRestored, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrBookDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrBookIsNotDeleted) ||
+		errors.Is(err, repoerrs.ErrBookISBNAlreadyExists)) {
+		br.logger.Debugf("book with ID %s can't be restored: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error restoring book: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("restored book with ID: %s", ID)
+
+	return nil
+}
+
+func (br *BookRepo) Update(ctx context.Context, book *models.BookModel) (err error) {
+	ctx, end := br.start(ctx, "Update", entityIDAttr(book.ID))
+	defer end(&err)
+
+	br.logger.Debugf("updating book with ID: %s", book.ID)
 
 	query := `update bs.book 
 			  set title = $1, 
@@ -168,44 +391,127 @@ func (br *BookRepo) Update(ctx context.Context, book *models.BookModel) error {
 			      age_limit = $9
 			  where id = $10`
 
-	result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(
-		ctx, query,
-		book.Title,
-		book.Author,
-		book.Publisher,
-		book.CopiesNumber,
-		book.Rarity,
-		book.Genre,
-		book.PublishingYear,
-		book.Language,
-		book.AgeLimit,
-		book.ID,
-	)
-	if err != nil {
-		br.logger.Errorf("error updating book: %v", err)
+	err = br.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getActiveForUpdate(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		// у книги с фондом по филиалам общее число экземпляров меняется только через SetInventory
+		total, err := br.inventoryTotal(ctx, book.ID)
+		if err != nil {
+			return err
+		}
+		if total != nil && *total != book.CopiesNumber {
+			return repoerrs.ErrBookCopiesNumberMismatch
+		}
+
+		result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(
+			ctx, query,
+			book.Title,
+			book.Author,
+			book.Publisher,
+			book.CopiesNumber,
+			book.Rarity,
+			book.Genre,
+			book.PublishingYear,
+			book.Language,
+			book.AgeLimit,
+			book.ID,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("bookRepo.Update: expected 1 row affected, got %d", rows)
+		}
+		if err = br.linkDirectories(ctx, book.ID, book.Author, book.Publisher, before); err != nil {
+			return err
+		}
+
+		return br.recordChange(ctx, book.ID, AuditOperationUpdate, EventBookUpdated, before)
+	})
+	if err != nil && errors.Is(err, errs.ErrBookDoesNotExists) {
+		br.logger.Debugf("book with this ID not found: %s", book.ID)
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		br.logger.Errorf("error updating book: %v", err)
+	if err != nil && errors.Is(err, repoerrs.ErrBookCopiesNumberMismatch) {
+		br.logger.Debugf("book with ID %s can't be updated: %v", book.ID, err)
 		return err
 	}
-	if rows != 1 {
-		br.logger.Errorf("error updating book: expected 1 row affected, got %d", rows)
-		return errors.New("bookRepo.Update: expected 1 row affected")
+	if err != nil {
+		br.logger.Debugf("error updating book: %v", err)
+		return err
 	}
 
-	br.logger.Infof("updated book with ID: %s", book.ID)
+	br.logger.Debugf("updated book with ID: %s", book.ID)
 
 	return nil
 }
 
 func (br *BookRepo) GetByParams(ctx context.Context, params *dto.BookParamsDTO) ([]*models.BookModel, error) {
-	br.logger.Infof("selecting books with params")
+	return br.GetByParamsExt(ctx, &repodto.BookParamsDTO{BookParamsDTO: *params})
+}
+
+// GetByParamsExt — GetByParams с фильтрами, которых нет в BookParamsDTO сервисного слоя (ISBN, филиал)
+func (br *BookRepo) GetByParamsExt(ctx context.Context, params *repodto.BookParamsDTO) (_ []*models.BookModel, err error) {
+	ctx, end := br.start(ctx, "GetByParams")
+	defer end(&err)
+
+	br.logger.Debugf("selecting books with params")
 
-	query := `select * 
+	query := `select 
+    			id, 
+    			title,
+    			author, 
+    			publisher,
+    			copies_number, 
+    			rarity, 
+    			genre, 
+    			publishing_year, 
+    			language, 
+    			age_limit
 	          from bs.book 
-	          where ($1 = '' or title ilike '%' || $1 || '%') and 
+	          where ` + bookParamsCondition + `
+	          limit $10 offset $11`
+
+	args, err := bookParamsArgs(ctx, params)
+	if err != nil {
+		br.logger.Debugf("error selecting books with params: %v", err)
+		return nil, err
+	}
+
+	var coreBooks []*repomodels.BookModel
+
+	err = br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &coreBooks, query, args...)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting books with params")
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreBooks) == 0 {
+		br.logger.Debugf("books not found with this params")
+		return nil, errs.ErrBookDoesNotExists
+	}
+
+	br.logger.Debugf("found %d books", len(coreBooks))
+
+	books := make([]*models.BookModel, len(coreBooks))
+	for i, book := range coreBooks {
+		books[i] = br.convertToBookModel(book)
+	}
+
+	return books, nil
+}
+
+// bookParamsCondition — фильтр GetByParams; $10 и $11 оставлены под limit и offset, $13 — ISBN-13,
+// $14 — филиал, в котором книга должна быть в наличии
+const bookParamsCondition = `($12 or deleted_at is null) and 
+	                ($1 = '' or title ilike '%' || $1 || '%') and 
 	                ($2 = '' or author ilike '%' || $2 || '%') and 
 	                ($3 = '' or publisher ilike '%' || $3 || '%') and 
 	                ($4 = 0 or copies_number = $4) and 
@@ -213,42 +519,119 @@ func (br *BookRepo) GetByParams(ctx context.Context, params *dto.BookParamsDTO)
 	                ($6 = '' or genre ilike '%' || $6 || '%') and 
 	                ($7 = 0 or publishing_year = $7) and 
 	                ($8 = '' or language ilike '%' || $8 || '%') and 
-	                ($9 = 0 or age_limit = $9)
-	          limit $10 offset $11`
-
-	var coreBooks []*repomodels.BookModel
+	                ($9 = 0 or age_limit = $9) and 
+	                ($13 = '' or isbn_13 = $13) and 
+	                ($14::uuid is null or exists (select 1 from bs.book_inventory i
+	                                              where i.book_id = bs.book.id and i.branch_id = $14 and
+	                                                    i.copies_number > (select count(*) from bs.reservation r
+	                                                                       where r.book_id = i.book_id and 
+	                                                                             r.branch_id = i.branch_id and 
+	                                                                             r.state != 'Closed')))`
+
+// bookParamsArgs возвращает ErrInvalidISBN, если фильтр по ISBN не проходит проверку
+func bookParamsArgs(ctx context.Context, params *repodto.BookParamsDTO) ([]any, error) {
+	var isbn13 string
+	if params.ISBN != "" {
+		var err error
+		if isbn13, _, err = NormalizeISBN(params.ISBN); err != nil {
+			return nil, err
+		}
+	}
 
-	err := br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &coreBooks, query,
-		params.Title,
-		params.Author,
-		params.Publisher,
+	return []any{
+		escapeLike(params.Title),
+		escapeLike(params.Author),
+		escapeLike(params.Publisher),
 		params.CopiesNumber,
 		params.Rarity,
-		params.Genre,
+		escapeLike(params.Genre),
 		params.PublishingYear,
-		params.Language,
+		escapeLike(params.Language),
 		params.AgeLimit,
 		params.Limit,
 		params.Offset,
-	)
+		withDeletedBooks(ctx),
+		isbn13,
+		params.BranchID,
+	}, nil
+}
 
+// bookSnapshot — состояние строки книги для журнала изменений, включая признаки архивации
+type bookSnapshot struct {
+	repomodels.BookModel
+	DeletedAt *time.Time `db:"deleted_at"`
+	DeletedBy *uuid.UUID `db:"deleted_by"`
+}
+
+// getForUpdate блокирует строку книги до конца транзакции и возвращает ее состояние
+func (br *BookRepo) getForUpdate(ctx context.Context, ID uuid.UUID) (*bookSnapshot, error) {
+	query := `select 
+    			id, 
+    			title,
+    			author, 
+    			publisher,
+    			copies_number, 
+    			rarity, 
+    			genre, 
+    			publishing_year, 
+    			language, 
+    			age_limit, 
+    			isbn_10, 
+    			isbn_13, 
+    			publisher_id, 
+    			deleted_at, 
+    			deleted_by
+			  from bs.book 
+			  where id = $1 
+			  for update`
+
+	var snapshot bookSnapshot
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &snapshot, query, ID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		br.logger.Errorf("error selecting books with params")
 		return nil, err
 	}
-	if errors.Is(err, sql.ErrNoRows) || len(coreBooks) == 0 {
-		br.logger.Warnf("books not found with this params")
+	if errors.Is(err, sql.ErrNoRows) {
 		return nil, errs.ErrBookDoesNotExists
 	}
 
-	br.logger.Infof("found %d books", len(coreBooks))
+	return &snapshot, nil
+}
 
-	books := make([]*models.BookModel, len(coreBooks))
-	for i, book := range coreBooks {
-		books[i] = br.convertToBookModel(book)
+// getActiveForUpdate считает архивную книгу несуществующей
+func (br *BookRepo) getActiveForUpdate(ctx context.Context, ID uuid.UUID) (*bookSnapshot, error) {
+	snapshot, err := br.getForUpdate(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.DeletedAt != nil {
+		return nil, errs.ErrBookDoesNotExists
 	}
 
-	return books, nil
+	return snapshot, nil
+}
+
+func (br *BookRepo) hasActiveReservations(ctx context.Context, ID uuid.UUID) (bool, error) {
+	query := `select exists(select 1 from bs.reservation where book_id = $1 and state != $2)`
+
+	var exists bool
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &exists, query, ID, impl.ReservationClosed)
+
+	return exists, err
+}
+
+// recordChange перечитывает книгу после изменения, пишет разницу с before в журнал
+// и доменное событие в outbox
+func (br *BookRepo) recordChange(ctx context.Context, ID uuid.UUID, operation, eventType string, before *bookSnapshot) error {
+	after, err := br.getForUpdate(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	if err = br.audit.write(ctx, AuditEntityBook, ID, operation, before, after); err != nil {
+		return err
+	}
+
+	return br.outbox.write(ctx, AuditEntityBook, ID, eventType, after)
 }
 
 func (br *BookRepo) convertToBookModel(book *repomodels.BookModel) *models.BookModel {
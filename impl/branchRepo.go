@@ -0,0 +1,276 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+)
+
+// BranchRepo — филиалы городской сети библиотек. Код филиала — тот же префикс, что стоит
+// в номерах читательских билетов (LibCardNumConfig.BranchPrefix)
+type BranchRepo struct {
+	instrumentation
+
+	db        *sqlx.DB
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+}
+
+func NewBranchRepo(db *sqlx.DB, logger Logger) *BranchRepo {
+	return &BranchRepo{
+		instrumentation: instrumentation{repo: "branch", dbSystem: dbSystemPostgres, table: "bs.branch", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+	}
+}
+
+func (br *BranchRepo) Create(ctx context.Context, branch *repomodels.BranchModel) (err error) {
+	ctx, end := br.start(ctx, "Create", entityIDAttr(branch.ID))
+	defer end(&err)
+
+	br.logger.Debugf("inserting branch with ID: %s", branch.ID)
+
+	if err = validateBranchCode(branch.Code); err != nil {
+		br.logger.Debugf("invalid branch code: %s", branch.Code)
+		return err
+	}
+
+	query := `insert into bs.branch (id, code, name, address) values ($1, $2, $3, $4)`
+
+	err = br.trManager.Do(ctx, func(ctx context.Context) error {
+		result, err := br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query,
+			branch.ID,
+			branch.Code,
+			branch.Name,
+			branch.Address,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrBranchAlreadyExists
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("branchRepo.Create: expected 1 row affected, got %d", rows)
+		}
+
+		return br.audit.write(ctx, AuditEntityBranch, branch.ID, AuditOperationCreate, nil, branch)
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrBranchAlreadyExists) {
+		br.logger.Debugf("branch with this code already exists: %s", branch.Code)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error inserting branch: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("inserted branch with ID: %s", branch.ID)
+
+	return nil
+}
+
+func (br *BranchRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *repomodels.BranchModel, err error) {
+	ctx, end := br.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("selecting branch with ID: %s", ID)
+
+	query := `select id, code, name, address, created_at from bs.branch where id = $1`
+
+	branch, err := br.get(ctx, query, ID)
+	if err != nil {
+		br.logger.Debugf("error selecting branch with ID %s: %v", ID, err)
+		return nil, err
+	}
+
+	br.logger.Debugf("selected branch with ID: %s", ID)
+
+	return branch, nil
+}
+
+func (br *BranchRepo) GetByCode(ctx context.Context, code string) (_ *repomodels.BranchModel, err error) {
+	ctx, end := br.start(ctx, "GetByCode")
+	defer end(&err)
+
+	br.logger.Debugf("selecting branch with code: %s", code)
+
+	query := `select id, code, name, address, created_at from bs.branch where code = $1`
+
+	branch, err := br.get(ctx, query, code)
+	if err != nil {
+		br.logger.Debugf("error selecting branch with code %s: %v", code, err)
+		return nil, err
+	}
+
+	br.logger.Debugf("selected branch with code: %s", code)
+
+	return branch, nil
+}
+
+func (br *BranchRepo) GetAll(ctx context.Context) (_ []*repomodels.BranchModel, err error) {
+	ctx, end := br.start(ctx, "GetAll")
+	defer end(&err)
+
+	br.logger.Debugf("selecting all branches")
+
+	query := `select id, code, name, address, created_at from bs.branch order by code`
+
+	var branches []*repomodels.BranchModel
+	err = br.getter.DefaultTrOrDB(ctx, br.db).SelectContext(ctx, &branches, query)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		br.logger.Debugf("error selecting branches: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(branches) == 0 {
+		br.logger.Debugf("branches not found")
+		return nil, repoerrs.ErrBranchDoesNotExists
+	}
+
+	br.logger.Debugf("found %d branches", len(branches))
+
+	return branches, nil
+}
+
+// Update меняет название и адрес. Код филиала не меняется: он уже напечатан в выданных билетах
+func (br *BranchRepo) Update(ctx context.Context, branch *repomodels.BranchModel) (err error) {
+	ctx, end := br.start(ctx, "Update", entityIDAttr(branch.ID))
+	defer end(&err)
+
+	br.logger.Debugf("updating branch with ID: %s", branch.ID)
+
+	query := `update bs.branch set name = $1, address = $2 where id = $3`
+
+	err = br.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getForUpdate(ctx, branch.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, branch.Name, branch.Address, branch.ID); err != nil {
+			return err
+		}
+
+		after, err := br.getForUpdate(ctx, branch.ID)
+		if err != nil {
+			return err
+		}
+
+		return br.audit.write(ctx, AuditEntityBranch, branch.ID, AuditOperationUpdate, before, after)
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrBranchDoesNotExists) {
+		br.logger.Debugf("branch with this ID not found: %s", branch.ID)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error updating branch: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("updated branch with ID: %s", branch.ID)
+
+	return nil
+}
+
+// Delete удаляет только пустой филиал: без фонда, экземпляров, бронирований и билетов
+func (br *BranchRepo) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := br.start(ctx, "Delete", entityIDAttr(ID))
+	defer end(&err)
+
+	br.logger.Debugf("deleting branch with ID: %s", ID)
+
+	query := `delete from bs.branch where id = $1`
+
+	err = br.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := br.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = br.getter.DefaultTrOrDB(ctx, br.db).ExecContext(ctx, query, ID)
+		if err != nil && isForeignKeyViolation(err) {
+			return repoerrs.ErrBranchIsInUse
+		}
+		if err != nil {
+			return err
+		}
+
+		return br.audit.write(ctx, AuditEntityBranch, ID, AuditOperationDelete, before, nil)
+	})
+	if err != nil && (errors.Is(err, repoerrs.ErrBranchDoesNotExists) || errors.Is(err, repoerrs.ErrBranchIsInUse)) {
+		br.logger.Debugf("branch with ID %s can't be deleted: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		br.logger.Debugf("error deleting branch: %v", err)
+		return err
+	}
+
+	br.logger.Debugf("deleted branch with ID: %s", ID)
+
+	return nil
+}
+
+func (br *BranchRepo) get(ctx context.Context, query string, args ...any) (*repomodels.BranchModel, error) {
+	var branch repomodels.BranchModel
+	err := br.getter.DefaultTrOrDB(ctx, br.db).GetContext(ctx, &branch, query, args...)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repoerrs.ErrBranchDoesNotExists
+	}
+
+	return &branch, nil
+}
+
+func (br *BranchRepo) getForUpdate(ctx context.Context, ID uuid.UUID) (*repomodels.BranchModel, error) {
+	query := `select id, code, name, address, created_at from bs.branch where id = $1 for update`
+
+	return br.get(ctx, query, ID)
+}
+
+// validateBranchCode требует тот же формат, что и у префикса номера билета
+func validateBranchCode(code string) error {
+	config := LibCardNumConfig{BranchPrefix: code}
+	if err := config.validate(); err != nil {
+		return repoerrs.ErrInvalidBranchCode
+	}
+
+	return nil
+}
+
+// checkBranchExists проверяет филиал до вставки: по нарушению внешнего ключа не отличить
+// несуществующий филиал от несуществующей книги или читателя. nil — филиал не задан
+func checkBranchExists(ctx context.Context, tr trmsqlx.Tr, branchID *uuid.UUID) error {
+	if branchID == nil {
+		return nil
+	}
+
+	query := `select exists (select 1 from bs.branch where id = $1)`
+
+	var exists bool
+	if err := tr.GetContext(ctx, &exists, query, *branchID); err != nil {
+		return err
+	}
+	if !exists {
+		return repoerrs.ErrBranchDoesNotExists
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package impl
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+type ctxKey int
+
+const (
+	actorIDCtxKey ctxKey = iota
+	requestIDCtxKey
+	withDeletedBooksCtxKey
+	branchIDCtxKey
+)
+
+// WithActorID сохраняет в контексте ID пользователя, от имени которого выполняется запрос
+func WithActorID(ctx context.Context, actorID uuid.UUID) context.Context {
+	return context.WithValue(ctx, actorIDCtxKey, actorID)
+}
+
+func ActorIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	actorID, ok := ctx.Value(actorIDCtxKey).(uuid.UUID)
+	return actorID, ok
+}
+
+// WithRequestID сохраняет в контексте ID входящего запроса для сквозной трассировки
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey, requestID)
+}
+
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDCtxKey).(string)
+	return requestID, ok
+}
+
+// WithDeletedBooks включает удаленные (архивные) книги в выборки BookRepo
+func WithDeletedBooks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withDeletedBooksCtxKey, true)
+}
+
+func withDeletedBooks(ctx context.Context) bool {
+	withDeleted, _ := ctx.Value(withDeletedBooksCtxKey).(bool)
+	return withDeleted
+}
+
+// WithBranchID сохраняет в контексте филиал, в котором работает библиотекарь: в нем
+// создаются бронирования и читательские билеты
+func WithBranchID(ctx context.Context, branchID uuid.UUID) context.Context {
+	return context.WithValue(ctx, branchIDCtxKey, branchID)
+}
+
+func BranchIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	branchID, ok := ctx.Value(branchIDCtxKey).(uuid.UUID)
+	return branchID, ok
+}
+
+// branchIDArg — филиал из контекста для записи в колонку branch_id; без филиала — NULL
+func branchIDArg(ctx context.Context) *uuid.UUID {
+	branchID, ok := BranchIDFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	return &branchID
+}
@@ -0,0 +1,288 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	"strings"
+	"time"
+)
+
+// directoryErrors — ошибки конкретного справочника, чтобы вызывающий код различал авторов и издательства
+type directoryErrors struct {
+	notFound      error
+	alreadyExists error
+	hasBooks      error
+	aliasNotFound error
+	aliasIsName   error
+}
+
+// directoryEntry совпадает по полям с AuthorModel и PublisherModel и приводится к ним напрямую
+type directoryEntry struct {
+	ID        uuid.UUID `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// directory — общий код справочников с именем и псевдонимами (bs.author, bs.publisher).
+// Таблица псевдонимов называется <table>_alias и ссылается на справочник колонкой <table>_id.
+// Текущее имя тоже хранится как псевдоним, поэтому уникальность и поиск проверяются только по ним
+type directory struct {
+	db        *sqlx.DB
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+	table     string
+	entity    string
+	errs      directoryErrors
+	// onRename вызывается в транзакции переименования, например чтобы обновить копии имени в книгах
+	onRename func(ctx context.Context, ID uuid.UUID, name string) error
+}
+
+func newDirectory(db *sqlx.DB, table, entity string, errs directoryErrors) *directory {
+	return &directory{
+		db:        db,
+		getter:    trmsqlx.DefaultCtxGetter,
+		trManager: manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:     newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		table:     table,
+		entity:    entity,
+		errs:      errs,
+	}
+}
+
+func (d *directory) create(ctx context.Context, ID uuid.UUID, name string) error {
+	name = strings.TrimSpace(name)
+	query := fmt.Sprintf(`insert into bs.%s (id, name) values ($1, $2)`, d.table)
+
+	return d.trManager.Do(ctx, func(ctx context.Context) error {
+		if _, err := d.getter.DefaultTrOrDB(ctx, d.db).ExecContext(ctx, query, ID, name); err != nil {
+			return err
+		}
+		if err := d.insertAlias(ctx, ID, name); err != nil {
+			return err
+		}
+
+		return d.audit.write(ctx, d.entity, ID, AuditOperationCreate, nil, map[string]any{"name": name})
+	})
+}
+
+func (d *directory) getByID(ctx context.Context, ID uuid.UUID) (*directoryEntry, error) {
+	query := fmt.Sprintf(`select id, name, created_at from bs.%s where id = $1`, d.table)
+
+	var entry directoryEntry
+	err := d.getter.DefaultTrOrDB(ctx, d.db).GetContext(ctx, &entry, query, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, d.errs.notFound
+	}
+
+	return &entry, nil
+}
+
+// getByName ищет запись по текущему имени или любому псевдониму без учета регистра
+func (d *directory) getByName(ctx context.Context, name string) (*directoryEntry, error) {
+	query := fmt.Sprintf(`select e.id, e.name, e.created_at
+			  from bs.%[1]s e join bs.%[1]s_alias a on a.%[1]s_id = e.id
+			  where lower(a.alias) = lower($1)`, d.table)
+
+	var entry directoryEntry
+	err := d.getter.DefaultTrOrDB(ctx, d.db).GetContext(ctx, &entry, query, strings.TrimSpace(name))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, d.errs.notFound
+	}
+
+	return &entry, nil
+}
+
+func (d *directory) search(ctx context.Context, params *repodto.DirectoryParamsDTO) ([]*directoryEntry, error) {
+	query := fmt.Sprintf(`select e.id, e.name, e.created_at
+			  from bs.%[1]s e
+			  where $1 = '' or exists (select 1 from bs.%[1]s_alias a
+			                           where a.%[1]s_id = e.id and a.alias ilike '%%' || $1 || '%%')
+			  order by e.name, e.id
+			  limit $2 offset $3`, d.table)
+
+	var entries []*directoryEntry
+	err := d.getter.DefaultTrOrDB(ctx, d.db).SelectContext(ctx, &entries, query, escapeLike(params.Name), params.Limit, params.Offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(entries) == 0 {
+		return nil, d.errs.notFound
+	}
+
+	return entries, nil
+}
+
+// update переименовывает запись; прежнее имя остается псевдонимом, чтобы старые написания
+// по-прежнему находили ее
+func (d *directory) update(ctx context.Context, ID uuid.UUID, name string) error {
+	name = strings.TrimSpace(name)
+	query := fmt.Sprintf(`update bs.%s set name = $1 where id = $2`, d.table)
+
+	return d.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := d.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		owner, err := d.aliasOwner(ctx, name)
+		if err != nil {
+			return err
+		}
+		if owner != nil && *owner != ID {
+			return d.errs.alreadyExists
+		}
+		if owner == nil {
+			if err = d.insertAlias(ctx, ID, name); err != nil {
+				return err
+			}
+		}
+
+		if _, err = d.getter.DefaultTrOrDB(ctx, d.db).ExecContext(ctx, query, name, ID); err != nil {
+			return err
+		}
+		if d.onRename != nil {
+			if err = d.onRename(ctx, ID, name); err != nil {
+				return err
+			}
+		}
+
+		return d.audit.write(ctx, d.entity, ID, AuditOperationUpdate,
+			map[string]any{"name": before.Name}, map[string]any{"name": name})
+	})
+}
+
+// delete не удаляет запись, на которую ссылаются книги
+func (d *directory) delete(ctx context.Context, ID uuid.UUID) error {
+	query := fmt.Sprintf(`delete from bs.%s where id = $1`, d.table)
+
+	return d.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := d.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = d.getter.DefaultTrOrDB(ctx, d.db).ExecContext(ctx, query, ID)
+		if err != nil && isForeignKeyViolation(err) {
+			return d.errs.hasBooks
+		}
+		if err != nil {
+			return err
+		}
+
+		return d.audit.write(ctx, d.entity, ID, AuditOperationDelete, map[string]any{"name": before.Name}, nil)
+	})
+}
+
+func (d *directory) addAlias(ctx context.Context, ID uuid.UUID, alias string) error {
+	return d.trManager.Do(ctx, func(ctx context.Context) error {
+		if _, err := d.getForUpdate(ctx, ID); err != nil {
+			return err
+		}
+		if err := d.insertAlias(ctx, ID, alias); err != nil {
+			return err
+		}
+
+		return d.audit.write(ctx, d.entity, ID, AuditOperationAddAlias, nil, map[string]any{"alias": strings.TrimSpace(alias)})
+	})
+}
+
+func (d *directory) deleteAlias(ctx context.Context, ID uuid.UUID, alias string) error {
+	query := fmt.Sprintf(`delete from bs.%[1]s_alias where %[1]s_id = $1 and lower(alias) = lower($2)`, d.table)
+
+	return d.trManager.Do(ctx, func(ctx context.Context) error {
+		entry, err := d.getForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(entry.Name, strings.TrimSpace(alias)) {
+			return d.errs.aliasIsName
+		}
+
+		result, err := d.getter.DefaultTrOrDB(ctx, d.db).ExecContext(ctx, query, ID, strings.TrimSpace(alias))
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows == 0 {
+			return d.errs.aliasNotFound
+		}
+
+		return d.audit.write(ctx, d.entity, ID, AuditOperationDeleteAlias, map[string]any{"alias": strings.TrimSpace(alias)}, nil)
+	})
+}
+
+func (d *directory) getAliases(ctx context.Context, ID uuid.UUID) ([]string, error) {
+	query := fmt.Sprintf(`select alias from bs.%[1]s_alias where %[1]s_id = $1 order by alias`, d.table)
+
+	var aliases []string
+	err := d.getter.DefaultTrOrDB(ctx, d.db).SelectContext(ctx, &aliases, query, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	// у существующей записи всегда есть хотя бы псевдоним-имя
+	if errors.Is(err, sql.ErrNoRows) || len(aliases) == 0 {
+		return nil, d.errs.notFound
+	}
+
+	return aliases, nil
+}
+
+func (d *directory) getForUpdate(ctx context.Context, ID uuid.UUID) (*directoryEntry, error) {
+	query := fmt.Sprintf(`select id, name, created_at from bs.%s where id = $1 for update`, d.table)
+
+	var entry directoryEntry
+	err := d.getter.DefaultTrOrDB(ctx, d.db).GetContext(ctx, &entry, query, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, d.errs.notFound
+	}
+
+	return &entry, nil
+}
+
+func (d *directory) insertAlias(ctx context.Context, ID uuid.UUID, alias string) error {
+	query := fmt.Sprintf(`insert into bs.%[1]s_alias (%[1]s_id, alias) values ($1, $2)`, d.table)
+
+	_, err := d.getter.DefaultTrOrDB(ctx, d.db).ExecContext(ctx, query, ID, strings.TrimSpace(alias))
+	if err != nil && isUniqueViolation(err) {
+		return d.errs.alreadyExists
+	}
+
+	return err
+}
+
+// aliasOwner возвращает ID записи, которой принадлежит псевдоним, или nil
+func (d *directory) aliasOwner(ctx context.Context, alias string) (*uuid.UUID, error) {
+	query := fmt.Sprintf(`select %[1]s_id from bs.%[1]s_alias where lower(alias) = lower($1)`, d.table)
+
+	var owner uuid.UUID
+	err := d.getter.DefaultTrOrDB(ctx, d.db).GetContext(ctx, &owner, query, strings.TrimSpace(alias))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return &owner, nil
+}
@@ -0,0 +1,173 @@
+package impl
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+	"time"
+)
+
+const (
+	tracerName  = "github.com/nikitalystsev/BookSmart-repo-postgres"
+	entityIDKey = attribute.Key("entity.id")
+
+	dbSystemPostgres = "postgresql"
+	dbSystemRedis    = "redis"
+)
+
+// redisSystemAttr переопределяет db.system у методов, которые работают только с Redis
+var redisSystemAttr = attribute.String("db.system", dbSystemRedis)
+
+// entityIDAttr помечает вызов ID сущности, с которой он работает; попадает и в спан, и в лог
+func entityIDAttr(ID uuid.UUID) attribute.KeyValue {
+	return entityIDKey.String(ID.String())
+}
+
+// instrumentation встраивается в репозитории и снимает логи, метрики и трассировку с их публичных методов.
+// Пока метрики и tracer provider не подключены, start только пишет итоговую запись в лог
+type instrumentation struct {
+	repo      string
+	dbSystem  string
+	table     string
+	logger    Logger
+	logLevels *LogLevels
+	metrics   *Metrics
+	tracer    trace.Tracer
+}
+
+// SetMetrics подключает метрики к репозиторию. Вызывается до начала работы с репозиторием
+func (in *instrumentation) SetMetrics(metrics *Metrics) {
+	in.metrics = metrics
+}
+
+// SetTracerProvider включает трассировку вызовов репозитория. Вызывается до начала работы с репозиторием
+func (in *instrumentation) SetTracerProvider(provider trace.TracerProvider) {
+	in.tracer = provider.Tracer(tracerName)
+}
+
+// start вызывается первой строкой публичного метода:
+//
+//	ctx, end := xr.start(ctx, "Method")
+//	defer end(&err)
+//
+// Возвращенный контекст несет спан метода, поэтому его нужно передавать дальше в запросы
+func (in *instrumentation) start(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, func(err *error)) {
+	started := time.Now()
+
+	var span trace.Span
+	if in.tracer != nil {
+		ctx, span = in.tracer.Start(ctx, in.repo+"."+method,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("db.system", in.dbSystem),
+				attribute.String("db.operation", method),
+				attribute.String("db.sql.table", in.table),
+			),
+			trace.WithAttributes(attrs...),
+		)
+	}
+
+	fields := map[string]any{"repo": in.repo, "method": method}
+	if requestID, ok := RequestIDFromContext(ctx); ok {
+		fields["request_id"] = requestID
+	}
+	for _, attr := range attrs {
+		if attr.Key == entityIDKey {
+			fields["entity_id"] = attr.Value.AsString()
+		}
+	}
+
+	return ctx, func(err *error) {
+		in.logCall(fields, method, started, *err)
+		if in.metrics != nil {
+			in.metrics.observe(in.repo, method, started, *err)
+		}
+		if span != nil {
+			endSpan(span, *err)
+		}
+	}
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("error.class", classifyError(err)))
+	}
+
+	span.End()
+}
+
+// setRowsAffected дописывает к текущему спану число затронутых запросом строк
+func setRowsAffected(ctx context.Context, rows int64) {
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int64("db.rows_affected", rows))
+}
+
+// redisTracingHook открывает дочерний спан на каждую команду или конвейер Redis
+type redisTracingHook struct {
+	tracer trace.Tracer
+}
+
+var _ redis.Hook = (*redisTracingHook)(nil)
+
+func (rth *redisTracingHook) BeforeProcess(ctx context.Context, cmd redis.Cmder) (context.Context, error) {
+	ctx, _ = rth.tracer.Start(ctx, "redis."+cmd.Name(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			redisSystemAttr,
+			attribute.String("db.operation", cmd.Name()),
+		),
+	)
+
+	return ctx, nil
+}
+
+func (rth *redisTracingHook) AfterProcess(ctx context.Context, cmd redis.Cmder) error {
+	endSpan(trace.SpanFromContext(ctx), redisCmdError(cmd.Err()))
+
+	return nil
+}
+
+func (rth *redisTracingHook) BeforeProcessPipeline(ctx context.Context, cmds []redis.Cmder) (context.Context, error) {
+	names := make([]string, len(cmds))
+	for i, cmd := range cmds {
+		names[i] = cmd.Name()
+	}
+
+	ctx, _ = rth.tracer.Start(ctx, "redis.pipeline",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			redisSystemAttr,
+			attribute.String("db.operation", strings.Join(names, " ")),
+			attribute.Int("db.redis.num_cmd", len(cmds)),
+		),
+	)
+
+	return ctx, nil
+}
+
+func (rth *redisTracingHook) AfterProcessPipeline(ctx context.Context, cmds []redis.Cmder) error {
+	var err error
+	for _, cmd := range cmds {
+		if err = redisCmdError(cmd.Err()); err != nil {
+			break
+		}
+	}
+
+	endSpan(trace.SpanFromContext(ctx), err)
+
+	return nil
+}
+
+// redisCmdError не считает ошибкой отсутствие ключа
+func redisCmdError(err error) error {
+	if err == redis.Nil {
+		return nil
+	}
+
+	return err
+}
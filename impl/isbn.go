@@ -0,0 +1,75 @@
+package impl
+
+import (
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"strings"
+)
+
+const (
+	isbn10Length = 10
+	isbn13Length = 13
+
+	isbn13BooklandPrefix = "978" // единственный префикс, у номеров которого есть ISBN-10
+	isbn13MusicPrefix    = "979"
+)
+
+// NormalizeISBN принимает ISBN-10 или ISBN-13 в любом написании (с дефисами, пробелами,
+// строчной x), проверяет контрольную цифру и возвращает оба номера без разделителей.
+// У номеров с префиксом 979 ISBN-10 нет, для них isbn10 пустой
+func NormalizeISBN(isbn string) (isbn13, isbn10 string, err error) {
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(isbn)))
+
+	switch len(isbn) {
+	case isbn10Length:
+		if !isDigits(isbn[:isbn10Length-1]) || isbn10CheckDigit(isbn[:isbn10Length-1]) != isbn[isbn10Length-1] {
+			return "", "", repoerrs.ErrInvalidISBN
+		}
+		payload := isbn13BooklandPrefix + isbn[:isbn10Length-1]
+
+		return payload + string(isbn13CheckDigit(payload)), isbn, nil
+	case isbn13Length:
+		if !isDigits(isbn) || isbn13CheckDigit(isbn[:isbn13Length-1]) != isbn[isbn13Length-1] {
+			return "", "", repoerrs.ErrInvalidISBN
+		}
+		if strings.HasPrefix(isbn, isbn13MusicPrefix) {
+			return isbn, "", nil
+		}
+		if !strings.HasPrefix(isbn, isbn13BooklandPrefix) {
+			return "", "", repoerrs.ErrInvalidISBN
+		}
+		payload := isbn[len(isbn13BooklandPrefix) : isbn13Length-1]
+
+		return isbn, payload + string(isbn10CheckDigit(payload)), nil
+	default:
+		return "", "", repoerrs.ErrInvalidISBN
+	}
+}
+
+// isbn10CheckDigit — взвешенная сумма по модулю 11; остаток 10 записывается как X
+func isbn10CheckDigit(payload string) byte {
+	sum := 0
+	for i := 0; i < len(payload); i++ {
+		sum += (isbn10Length - i) * int(payload[i]-'0')
+	}
+
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+
+	return byte('0' + check)
+}
+
+// isbn13CheckDigit — сумма с весами 1 и 3 по модулю 10, как у EAN-13
+func isbn13CheckDigit(payload string) byte {
+	sum := 0
+	for i := 0; i < len(payload); i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(payload[i]-'0')
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
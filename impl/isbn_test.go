@@ -0,0 +1,47 @@
+package impl
+
+import (
+	"errors"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		isbn       string
+		wantISBN13 string
+		wantISBN10 string
+		wantErr    bool
+	}{
+		{isbn: "0-306-40615-2", wantISBN13: "9780306406157", wantISBN10: "0306406152"},
+		{isbn: "978-0-306-40615-7", wantISBN13: "9780306406157", wantISBN10: "0306406152"},
+		{isbn: " 978 0 306 40615 7 ", wantISBN13: "9780306406157", wantISBN10: "0306406152"},
+		{isbn: "080442957x", wantISBN13: "9780804429573", wantISBN10: "080442957X"},
+		{isbn: "9791034300303", wantISBN13: "9791034300303"},
+		{isbn: "0-306-40615-3", wantErr: true},
+		{isbn: "978-0-306-40615-8", wantErr: true},
+		{isbn: "9770306406158", wantErr: true},
+		{isbn: "X306406152", wantErr: true},
+		{isbn: "97803064061X7", wantErr: true},
+		{isbn: "030640615", wantErr: true},
+		{isbn: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			isbn13, isbn10, err := NormalizeISBN(tt.isbn)
+			if tt.wantErr {
+				if !errors.Is(err, repoerrs.ErrInvalidISBN) {
+					t.Errorf("NormalizeISBN(%q) error = %v, want ErrInvalidISBN", tt.isbn, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NormalizeISBN(%q) unexpected error: %v", tt.isbn, err)
+			}
+			if isbn13 != tt.wantISBN13 || isbn10 != tt.wantISBN10 {
+				t.Errorf("NormalizeISBN(%q) = (%s, %s), want (%s, %s)", tt.isbn, isbn13, isbn10, tt.wantISBN13, tt.wantISBN10)
+			}
+		})
+	}
+}
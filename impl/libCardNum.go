@@ -0,0 +1,109 @@
+package impl
+
+import (
+	"fmt"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"strconv"
+	"strings"
+)
+
+const (
+	libCardNumLength = 13
+	libCardYearLen   = 2
+	libCardCheckLen  = 1
+
+	DefaultLibCardBranchPrefix = "01"
+	maxLibCardBranchPrefixLen  = 4
+)
+
+// LibCardNumConfig задает формат номера читательского билета:
+// <префикс филиала><две последние цифры года><порядковый номер><контрольная цифра Луна>.
+// Общая длина номера всегда 13 цифр, порядковый номер занимает оставшиеся разряды
+type LibCardNumConfig struct {
+	BranchPrefix string
+	// StrictNums дополнительно проверяет префикс филиала и год выдачи в номерах, которые передает
+	// вызывающий. Длина, цифры и контрольная цифра проверяются всегда
+	StrictNums bool
+}
+
+func (c *LibCardNumConfig) serialLen() int {
+	return libCardNumLength - len(c.BranchPrefix) - libCardYearLen - libCardCheckLen
+}
+
+func (c *LibCardNumConfig) validate() error {
+	if len(c.BranchPrefix) == 0 || len(c.BranchPrefix) > maxLibCardBranchPrefixLen || !isDigits(c.BranchPrefix) {
+		return repoerrs.ErrInvalidLibCardNumConfig
+	}
+
+	return nil
+}
+
+// formatLibCardNum собирает номер из порядкового номера, полученного из последовательности
+func formatLibCardNum(config *LibCardNumConfig, issueYear int, serial int64) (string, error) {
+	serialLen := config.serialLen()
+
+	serialStr := fmt.Sprintf("%0*d", serialLen, serial)
+	if len(serialStr) > serialLen {
+		return "", repoerrs.ErrLibCardNumsExhausted
+	}
+
+	payload := fmt.Sprintf("%s%02d%s", config.BranchPrefix, issueYear%100, serialStr)
+
+	return payload + string(luhnCheckDigit(payload)), nil
+}
+
+// validateLibCardNum проверяет номер, который передал вызывающий. currentYear нужен, чтобы
+// отсечь номера с годом выдачи из будущего
+func validateLibCardNum(config *LibCardNumConfig, libCardNum string, currentYear int) error {
+	if len(libCardNum) != libCardNumLength || !isDigits(libCardNum) {
+		return repoerrs.ErrInvalidLibCardNum
+	}
+	payload, check := libCardNum[:libCardNumLength-1], libCardNum[libCardNumLength-1]
+	if luhnCheckDigit(payload) != check {
+		return repoerrs.ErrInvalidLibCardNum
+	}
+	if !config.StrictNums {
+		return nil
+	}
+
+	if !strings.HasPrefix(libCardNum, config.BranchPrefix) {
+		return repoerrs.ErrInvalidLibCardNum
+	}
+	yearStart := len(config.BranchPrefix)
+	year, err := strconv.Atoi(libCardNum[yearStart : yearStart+libCardYearLen])
+	if err != nil || year > currentYear%100 {
+		return repoerrs.ErrInvalidLibCardNum
+	}
+
+	return nil
+}
+
+// luhnCheckDigit вычисляет контрольную цифру по алгоритму Луна, который ловит
+// любую одиночную опечатку и большинство перестановок соседних цифр
+func luhnCheckDigit(payload string) byte {
+	sum := 0
+	double := true
+	for i := len(payload) - 1; i >= 0; i-- {
+		d := int(payload[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+
+	return byte('0' + (10-sum%10)%10)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
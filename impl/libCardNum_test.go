@@ -0,0 +1,84 @@
+package impl
+
+import (
+	"errors"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"testing"
+)
+
+func TestLuhnCheckDigit(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    byte
+	}{
+		{payload: "7992739871", want: '3'},
+		{payload: "0", want: '0'},
+		{payload: "1", want: '8'},
+		{payload: "012600000001", want: '1'},
+		{payload: "000000000000", want: '0'},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			if got := luhnCheckDigit(tt.payload); got != tt.want {
+				t.Errorf("luhnCheckDigit(%q) = %c, want %c", tt.payload, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatLibCardNum(t *testing.T) {
+	config := &LibCardNumConfig{BranchPrefix: "01", StrictNums: true}
+
+	libCardNum, err := formatLibCardNum(config, 2026, 1)
+	if err != nil {
+		t.Fatalf("formatLibCardNum: unexpected error: %v", err)
+	}
+	if libCardNum != "0126000000011" {
+		t.Errorf("formatLibCardNum = %s, want 0126000000011", libCardNum)
+	}
+	if err = validateLibCardNum(config, libCardNum, 2026); err != nil {
+		t.Errorf("generated num %s does not pass validation: %v", libCardNum, err)
+	}
+
+	if _, err = formatLibCardNum(config, 2026, 100000000); !errors.Is(err, repoerrs.ErrLibCardNumsExhausted) {
+		t.Errorf("formatLibCardNum with overflowing serial: got %v, want ErrLibCardNumsExhausted", err)
+	}
+}
+
+func TestValidateLibCardNum(t *testing.T) {
+	permissive := &LibCardNumConfig{BranchPrefix: "01"}
+	strict := &LibCardNumConfig{BranchPrefix: "01", StrictNums: true}
+
+	tests := []struct {
+		name       string
+		config     *LibCardNumConfig
+		libCardNum string
+		wantErr    bool
+	}{
+		{name: "permissive other branch", config: permissive, libCardNum: "4829105736217"},
+		{name: "permissive bad check digit", config: permissive, libCardNum: "4829105736210", wantErr: true},
+		{name: "permissive typo", config: permissive, libCardNum: "0126000000021", wantErr: true},
+		{name: "permissive short", config: permissive, libCardNum: "012600000001", wantErr: true},
+		{name: "permissive long", config: permissive, libCardNum: "01260000000190", wantErr: true},
+		{name: "permissive not digits", config: permissive, libCardNum: "01260000000a9", wantErr: true},
+		{name: "strict valid", config: strict, libCardNum: "0126000000011"},
+		{name: "strict valid past year", config: strict, libCardNum: "0124000000016"},
+		{name: "strict bad check digit", config: strict, libCardNum: "0126000000018", wantErr: true},
+		{name: "strict other branch", config: strict, libCardNum: "0226000000019", wantErr: true},
+		{name: "strict future year", config: strict, libCardNum: "0127000000019", wantErr: true},
+		{name: "strict random digits", config: strict, libCardNum: "4829105736217", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateLibCardNum(tt.config, tt.libCardNum, 2026)
+			if tt.wantErr && !errors.Is(err, repoerrs.ErrInvalidLibCardNum) {
+				t.Errorf("validateLibCardNum(%s) = %v, want ErrInvalidLibCardNum", tt.libCardNum, err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("validateLibCardNum(%s) = %v, want nil", tt.libCardNum, err)
+			}
+		})
+	}
+}
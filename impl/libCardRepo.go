@@ -4,60 +4,153 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
 	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
 	"github.com/nikitalystsev/BookSmart-services/core/models"
 	"github.com/nikitalystsev/BookSmart-services/errs"
 	"github.com/nikitalystsev/BookSmart-services/intfRepo"
-	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	LibCardLost     = "Lost"
+	LibCardStolen   = "Stolen"
+	LibCardReplaced = "Replaced"
+)
+
+const (
+	LibCardActive   = "Active"
+	LibCardExpired  = "Expired"
+	LibCardInactive = "Inactive"
 )
 
 type LibCardRepo struct {
-	db     *sqlx.DB
-	logger *logrus.Entry
+	instrumentation
+
+	db        *sqlx.DB
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+	numConfig LibCardNumConfig
 }
 
-func NewLibCardRepo(db *sqlx.DB, logger *logrus.Entry) intfRepo.ILibCardRepo {
-	return &LibCardRepo{db: db, logger: logger}
+var _ intfRepo.ILibCardRepo = (*LibCardRepo)(nil)
+
+// NewLibCardRepo возвращает репозиторий как интерфейс сервисного слоя
+func NewLibCardRepo(db *sqlx.DB, numConfig LibCardNumConfig, logger Logger) intfRepo.ILibCardRepo {
+	return NewLibCardRepoImpl(db, numConfig, logger)
 }
 
-func (lcr *LibCardRepo) Create(ctx context.Context, libCard *models.LibCardModel) error {
-	lcr.logger.Infof("inserting libCard with ID: %s", libCard.ID)
+// NewLibCardRepoImpl возвращает LibCardRepo с методами, которых нет в intfRepo.ILibCardRepo
+func NewLibCardRepoImpl(db *sqlx.DB, numConfig LibCardNumConfig, logger Logger) *LibCardRepo {
+	if numConfig.BranchPrefix == "" {
+		numConfig.BranchPrefix = DefaultLibCardBranchPrefix
+	}
 
-	query := `insert into bs.lib_card values ($1, $2, $3, $4, $5, $6)`
+	return &LibCardRepo{
+		instrumentation: instrumentation{repo: "lib_card", dbSystem: dbSystemPostgres, table: "bs.lib_card", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		numConfig:       numConfig,
+	}
+}
 
-	result, err := lcr.db.ExecContext(
-		ctx, query,
-		libCard.ID,
-		libCard.ReaderID,
-		libCard.LibCardNum,
-		libCard.Validity,
-		libCard.IssueDate,
-		libCard.ActionStatus,
-	)
+// Create сам выделяет номер билета, если вызывающий его не указал. Билет выдается в филиале
+// из контекста (WithBranchID), а без него — вне филиалов; код, которому филиал известен, вызывает CreateInBranch
+func (lcr *LibCardRepo) Create(ctx context.Context, libCard *models.LibCardModel) (err error) {
+	ctx, end := lcr.start(ctx, "Create", entityIDAttr(libCard.ID))
+	defer end(&err)
 
-	if err != nil {
-		lcr.logger.Errorf("error inserting libCard: %v", err)
+	return lcr.create(ctx, libCard, branchIDArg(ctx))
+}
+
+// CreateInBranch — Create с явно указанным филиалом выдачи
+func (lcr *LibCardRepo) CreateInBranch(ctx context.Context, libCard *models.LibCardModel, branchID uuid.UUID) (err error) {
+	ctx, end := lcr.start(ctx, "CreateInBranch", entityIDAttr(libCard.ID))
+	defer end(&err)
+
+	return lcr.create(ctx, libCard, &branchID)
+}
+
+func (lcr *LibCardRepo) create(ctx context.Context, libCard *models.LibCardModel, branchID *uuid.UUID) error {
+	lcr.logger.Debugf("inserting libCard with ID: %s", libCard.ID)
+
+	if libCard.LibCardNum == "" {
+		libCardNum, err := lcr.NextNum(ctx)
+		if err != nil {
+			return err
+		}
+		libCard.LibCardNum = libCardNum
+	} else if err := validateLibCardNum(&lcr.numConfig, libCard.LibCardNum, time.Now().Year()); err != nil {
+		lcr.logger.Debugf("invalid libCard num: %s", libCard.LibCardNum)
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		lcr.logger.Errorf("error inserting libCard: %v", err)
+
+	query := `insert into bs.lib_card (id, reader_id, lib_card_num, validity, issue_date, action_status, branch_id) 
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+
+	err := lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		if err := checkBranchExists(ctx, lcr.getter.DefaultTrOrDB(ctx, lcr.db), branchID); err != nil {
+			return err
+		}
+
+		result, err := lcr.getter.DefaultTrOrDB(ctx, lcr.db).ExecContext(
+			ctx, query,
+			libCard.ID,
+			libCard.ReaderID,
+			libCard.LibCardNum,
+			libCard.Validity,
+			libCard.IssueDate,
+			libCard.ActionStatus,
+			branchID,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return errs.ErrLibCardAlreadyExist
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("libCardRepo.Create: expected 1 row affected, got %d", rows)
+		}
+
+		return lcr.auditChange(ctx, libCard.ID, AuditOperationCreate, nil)
+	})
+	if err != nil && errors.Is(err, errs.ErrLibCardAlreadyExist) {
+		lcr.logger.Debugf("reader %s already has a libCard or num %s is taken", libCard.ReaderID, libCard.LibCardNum)
+		return err
+	}
+	if err != nil && errors.Is(err, repoerrs.ErrBranchDoesNotExists) {
+		lcr.logger.Debugf("libCard branch not found: %v", err)
 		return err
 	}
-	if rows != 1 {
-		lcr.logger.Errorf("error inserting libCard: expected 1 row affected, got %d", rows)
-		return errors.New("libCardRepo.Create: expected 1 row affected")
+	if err != nil {
+		lcr.logger.Debugf("error inserting libCard: %v", err)
+		return err
 	}
 
-	lcr.logger.Infof("inserted libCard with ID: %s", libCard.ID)
+	lcr.logger.Debugf("inserted libCard with ID: %s", libCard.ID)
 
-	return err
+	return nil
 }
 
-func (lcr *LibCardRepo) GetByReaderID(ctx context.Context, readerID uuid.UUID) (*models.LibCardModel, error) {
-	lcr.logger.Infof("selecting libCard with readerID: %s", readerID)
+func (lcr *LibCardRepo) GetByReaderID(ctx context.Context, readerID uuid.UUID) (_ *models.LibCardModel, err error) {
+	ctx, end := lcr.start(ctx, "GetByReaderID", entityIDAttr(readerID))
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting libCard with readerID: %s", readerID)
 
 	query := `select 
     			id, 
@@ -67,26 +160,35 @@ func (lcr *LibCardRepo) GetByReaderID(ctx context.Context, readerID uuid.UUID) (
     			issue_date, 
     			action_status 
 			  from bs.lib_card_view 
-			  where reader_id = $1`
+			  where reader_id = $1 and blocked_at is null`
 
 	var libCard repomodels.LibCardModel
-	err := lcr.db.GetContext(ctx, &libCard, query, readerID)
+	err = lcr.db.GetContext(ctx, &libCard, query, readerID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		lcr.logger.Errorf("error selecting libCard: %v", err)
+		lcr.logger.Debugf("error selecting libCard: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		lcr.logger.Warnf("libCard with this readerID not found: %v", readerID)
+		lcr.logger.Debugf("libCard with this readerID not found: %v", readerID)
 		return nil, errs.ErrLibCardDoesNotExists
 	}
 
-	lcr.logger.Infof("selected libCard with readerID: %s", readerID)
+	lcr.logger.Debugf("selected libCard with readerID: %s", readerID)
 
 	return lcr.convertToLibCardModel(&libCard), nil
 }
 
-func (lcr *LibCardRepo) GetByNum(ctx context.Context, libCardNum string) (*models.LibCardModel, error) {
-	lcr.logger.Infof("selecting libCard with num: %s", libCardNum)
+func (lcr *LibCardRepo) GetByNum(ctx context.Context, libCardNum string) (_ *models.LibCardModel, err error) {
+	ctx, end := lcr.start(ctx, "GetByNum")
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting libCard with num: %s", libCardNum)
+
+	// номер с опечаткой отсекается по контрольной цифре, не доходя до базы
+	if err = validateLibCardNum(&lcr.numConfig, libCardNum, time.Now().Year()); err != nil {
+		lcr.logger.Debugf("invalid libCard num: %s", libCardNum)
+		return nil, err
+	}
 
 	query := `select 
     			id, 
@@ -98,26 +200,29 @@ func (lcr *LibCardRepo) GetByNum(ctx context.Context, libCardNum string) (*model
 			  from bs.lib_card_view 
 			  where lib_card_num = $1`
 
-	lcr.logger.Infof("executing query: %s", query)
+	lcr.logger.Debugf("executing query: %s", query)
 
 	var libCard repomodels.LibCardModel
-	err := lcr.db.GetContext(ctx, &libCard, query, libCardNum)
+	err = lcr.db.GetContext(ctx, &libCard, query, libCardNum)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		lcr.logger.Errorf("error selected libCard with num: %v", err)
+		lcr.logger.Debugf("error selected libCard with num: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		lcr.logger.Warnf("libCard with this num not found: %v", libCardNum)
+		lcr.logger.Debugf("libCard with this num not found: %v", libCardNum)
 		return nil, errs.ErrLibCardDoesNotExists
 	}
 
-	lcr.logger.Infof("selected libCard with num: %s", libCardNum)
+	lcr.logger.Debugf("selected libCard with num: %s", libCardNum)
 
 	return lcr.convertToLibCardModel(&libCard), nil
 }
 
-func (lcr *LibCardRepo) Update(ctx context.Context, libCard *models.LibCardModel) error {
-	lcr.logger.Infof("updating libCard with ID: %s", libCard.ID)
+func (lcr *LibCardRepo) Update(ctx context.Context, libCard *models.LibCardModel) (err error) {
+	ctx, end := lcr.start(ctx, "Update", entityIDAttr(libCard.ID))
+	defer end(&err)
+
+	lcr.logger.Debugf("updating libCard with ID: %s", libCard.ID)
 
 	query := `update bs.lib_card 
 			  set reader_id = $1, 
@@ -127,34 +232,523 @@ func (lcr *LibCardRepo) Update(ctx context.Context, libCard *models.LibCardModel
 			      action_status = $5
 			  where id = $6`
 
-	result, err := lcr.db.ExecContext(
-		ctx, query,
-		libCard.ReaderID,
-		libCard.LibCardNum,
-		libCard.Validity,
-		libCard.IssueDate,
-		libCard.ActionStatus,
-		libCard.ID,
-	)
+	err = lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := lcr.getForUpdate(ctx, libCard.ID)
+		if err != nil {
+			return err
+		}
+
+		result, err := lcr.getter.DefaultTrOrDB(ctx, lcr.db).ExecContext(
+			ctx, query,
+			libCard.ReaderID,
+			libCard.LibCardNum,
+			libCard.Validity,
+			libCard.IssueDate,
+			libCard.ActionStatus,
+			libCard.ID,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("libCardRepo.Update: expected 1 row affected, got %d", rows)
+		}
+
+		return lcr.auditChange(ctx, libCard.ID, AuditOperationUpdate, before)
+	})
+	if err != nil && errors.Is(err, errs.ErrLibCardDoesNotExists) {
+		lcr.logger.Debugf("libCard with this ID not found: %s", libCard.ID)
+		return err
+	}
+	if err != nil {
+		lcr.logger.Debugf("error updating libCard: %v", err)
+		return err
+	}
+
+	lcr.logger.Debugf("updated libCard with ID: %s", libCard.ID)
+
+	return nil
+}
+
+// NextNum выделяет новый номер билета из последовательности bs.lib_card_num_seq. Номер,
+// заданный вызывающим, может с ним совпасть: такую вставку отклоняет уникальный индекс
+func (lcr *LibCardRepo) NextNum(ctx context.Context) (_ string, err error) {
+	ctx, end := lcr.start(ctx, "NextNum")
+	defer end(&err)
+
+	lcr.logger.Debugf("allocating libCard num")
+
+	if err := lcr.numConfig.validate(); err != nil {
+		lcr.logger.Debugf("error allocating libCard num: %v", err)
+		return "", err
+	}
+
+	query := `select nextval('bs.lib_card_num_seq')`
+
+	var serial int64
+	if err := lcr.db.GetContext(ctx, &serial, query); err != nil {
+		lcr.logger.Debugf("error allocating libCard num: %v", err)
+		return "", err
+	}
+
+	libCardNum, err := formatLibCardNum(&lcr.numConfig, time.Now().Year(), serial)
+	if err != nil {
+		lcr.logger.Debugf("error allocating libCard num: %v", err)
+		return "", err
+	}
+
+	lcr.logger.Debugf("allocated libCard num: %s", libCardNum)
+
+	return libCardNum, nil
+}
+
+func (lcr *LibCardRepo) ValidateNum(libCardNum string) error {
+	return validateLibCardNum(&lcr.numConfig, libCardNum, time.Now().Year())
+}
+
+// GetExpiringWithin возвращает действующие билеты, срок которых истекает в ближайшие days дней
+func (lcr *LibCardRepo) GetExpiringWithin(ctx context.Context, days int) (_ []*models.LibCardModel, err error) {
+	ctx, end := lcr.start(ctx, "GetExpiringWithin")
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting libCards expiring within %d days", days)
+
+	query := `select 
+    			id, 
+    			reader_id, 
+    			lib_card_num, 
+    			validity, 
+    			issue_date, 
+    			action_status 
+			  from bs.lib_card 
+			  where action_status = true and 
+			        issue_date + validity >= current_date and 
+			        issue_date + validity <= current_date + $1::int
+			  order by issue_date + validity`
+
+	var coreLibCards []*repomodels.LibCardModel
+	err = lcr.db.SelectContext(ctx, &coreLibCards, query, days)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		lcr.logger.Debugf("error selecting expiring libCards: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreLibCards) == 0 {
+		lcr.logger.Debugf("libCards expiring within %d days not found", days)
+		return nil, errs.ErrLibCardDoesNotExists
+	}
+
+	lcr.logger.Debugf("found %d libCards expiring within %d days", len(coreLibCards), days)
+
+	return lcr.convertToLibCardModels(coreLibCards), nil
+}
+
+// GetExpired возвращает билеты с истекшим сроком действия, в том числе еще не деактивированные
+func (lcr *LibCardRepo) GetExpired(ctx context.Context) (_ []*models.LibCardModel, err error) {
+	ctx, end := lcr.start(ctx, "GetExpired")
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting expired libCards")
+
+	query := `select 
+    			id, 
+    			reader_id, 
+    			lib_card_num, 
+    			validity, 
+    			issue_date, 
+    			action_status 
+			  from bs.lib_card 
+			  where blocked_at is null and issue_date + validity < current_date
+			  order by issue_date + validity`
+
+	var coreLibCards []*repomodels.LibCardModel
+	err = lcr.db.SelectContext(ctx, &coreLibCards, query)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		lcr.logger.Debugf("error selecting expired libCards: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreLibCards) == 0 {
+		lcr.logger.Debugf("expired libCards not found")
+		return nil, errs.ErrLibCardDoesNotExists
+	}
+
+	lcr.logger.Debugf("found %d expired libCards", len(coreLibCards))
+
+	return lcr.convertToLibCardModels(coreLibCards), nil
+}
+
+// DeactivateExpired деактивирует все просроченные билеты и возвращает их количество
+func (lcr *LibCardRepo) DeactivateExpired(ctx context.Context) (_ int64, err error) {
+	ctx, end := lcr.start(ctx, "DeactivateExpired")
+	defer end(&err)
+
+	lcr.logger.Debugf("deactivating expired libCards")
+
+	query := `update bs.lib_card 
+			  set action_status = false 
+			  where action_status = true and issue_date + validity < current_date 
+			  returning id`
+
+	var libCardIDs []uuid.UUID
+	err = lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		err := lcr.getter.DefaultTrOrDB(ctx, lcr.db).SelectContext(ctx, &libCardIDs, query)
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, int64(len(libCardIDs)))
+
+		for _, libCardID := range libCardIDs {
+			err = lcr.audit.write(ctx, AuditEntityLibCard, libCardID, AuditOperationUpdate,
+				map[string]any{"action_status": true}, map[string]any{"action_status": false})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		lcr.logger.Debugf("error deactivating expired libCards: %v", err)
+		return 0, err
+	}
+
+	lcr.logger.Debugf("deactivated %d expired libCards", len(libCardIDs))
+
+	return int64(len(libCardIDs)), nil
+}
+
+// Renew продлевает билет на extensionDays дней, не трогая дату выдачи. Продление отсчитывается
+// от текущего срока окончания, а если билет уже просрочен, то от сегодняшнего дня
+func (lcr *LibCardRepo) Renew(ctx context.Context, libCardID uuid.UUID, extensionDays int) (err error) {
+	ctx, end := lcr.start(ctx, "Renew", entityIDAttr(libCardID))
+	defer end(&err)
+
+	lcr.logger.Debugf("renewing libCard with ID: %s", libCardID)
+
+	if extensionDays <= 0 {
+		lcr.logger.Debugf("invalid libCard extension period: %d", extensionDays)
+		return repoerrs.ErrInvalidLibCardExtension
+	}
+
+	err = lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		tr := lcr.getter.DefaultTrOrDB(ctx, lcr.db)
+
+		before, err := lcr.getForUpdate(ctx, libCardID)
+		if err != nil {
+			return err
+		}
+		if before.BlockedAt != nil {
+			return repoerrs.ErrLibCardIsBlocked
+		}
+
+		query := `insert into bs.lib_card_renewal 
+				 	(lib_card_id, previous_validity, new_validity, previous_expiry, new_expiry) 
+				 select id, 
+				        validity, 
+				        greatest(issue_date + validity, current_date) + $2::int - issue_date, 
+				        issue_date + validity, 
+				        greatest(issue_date + validity, current_date) + $2::int 
+				 from bs.lib_card 
+				 where id = $1 
+				 returning new_validity`
+
+		var newValidity int
+		if err = tr.GetContext(ctx, &newValidity, query, libCardID, extensionDays); err != nil {
+			return err
+		}
+
+		query = `update bs.lib_card set validity = $1, action_status = true where id = $2`
+
+		if _, err = tr.ExecContext(ctx, query, newValidity, libCardID); err != nil {
+			return err
+		}
+
+		return lcr.auditChange(ctx, libCardID, AuditOperationUpdate, before)
+	})
+	if err != nil && (errors.Is(err, errs.ErrLibCardDoesNotExists) || errors.Is(err, repoerrs.ErrLibCardIsBlocked)) {
+		lcr.logger.Debugf("libCard with ID %s can't be renewed: %v", libCardID, err)
+		return err
+	}
 	if err != nil {
-		lcr.logger.Errorf("error updating libCard: %v", err)
+		lcr.logger.Debugf("error renewing libCard: %v", err)
+		return err
+	}
+
+	lcr.logger.Debugf("renewed libCard with ID: %s", libCardID)
+
+	return nil
+}
+
+func (lcr *LibCardRepo) GetRenewalHistory(ctx context.Context, libCardID uuid.UUID) (_ []*repomodels.LibCardRenewalModel, err error) {
+	ctx, end := lcr.start(ctx, "GetRenewalHistory", entityIDAttr(libCardID))
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting renewal history of libCard with ID: %s", libCardID)
+
+	query := `select 
+    			id, 
+    			lib_card_id, 
+    			previous_validity, 
+    			new_validity, 
+    			previous_expiry, 
+    			new_expiry, 
+    			renewed_at 
+			  from bs.lib_card_renewal 
+			  where lib_card_id = $1 
+			  order by renewed_at`
+
+	var renewals []*repomodels.LibCardRenewalModel
+	err = lcr.db.SelectContext(ctx, &renewals, query, libCardID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		lcr.logger.Debugf("error selecting renewal history: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(renewals) == 0 {
+		lcr.logger.Debugf("renewal history of libCard with ID not found: %s", libCardID)
+		return nil, repoerrs.ErrLibCardRenewalDoesNotExist
+	}
+
+	lcr.logger.Debugf("found %d renewals of libCard with ID: %s", len(renewals), libCardID)
+
+	return renewals, nil
+}
+
+// Block блокирует утерянный или украденный билет. Заблокированный билет больше
+// не считается текущим билетом читателя
+func (lcr *LibCardRepo) Block(ctx context.Context, libCardID uuid.UUID, reason string) (err error) {
+	ctx, end := lcr.start(ctx, "Block", entityIDAttr(libCardID))
+	defer end(&err)
+
+	lcr.logger.Debugf("blocking libCard with ID: %s", libCardID)
+
+	if reason != LibCardLost && reason != LibCardStolen && reason != LibCardReplaced {
+		lcr.logger.Debugf("invalid libCard block reason: %s", reason)
+		return repoerrs.ErrInvalidLibCardBlockReason
+	}
+
+	err = lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		return lcr.block(ctx, libCardID, reason)
+	})
+	if err != nil && (errors.Is(err, errs.ErrLibCardDoesNotExists) || errors.Is(err, repoerrs.ErrLibCardIsBlocked)) {
+		lcr.logger.Debugf("libCard with ID %s can't be blocked: %v", libCardID, err)
 		return err
 	}
-	rows, err := result.RowsAffected()
 	if err != nil {
-		lcr.logger.Errorf("error updating libCard: %v", err)
+		lcr.logger.Debugf("error blocking libCard: %v", err)
+		return err
+	}
+
+	lcr.logger.Debugf("blocked libCard with ID: %s", libCardID)
+
+	return nil
+}
+
+// IssueReplacement выпускает новый билет взамен старого. Если старый билет еще
+// не заблокирован, он блокируется с причиной Replaced. Новый билет выдается в филиале из контекста
+// (WithBranchID), а без него — в филиале прежнего билета
+func (lcr *LibCardRepo) IssueReplacement(ctx context.Context, oldLibCardID uuid.UUID, libCard *models.LibCardModel) (err error) {
+	ctx, end := lcr.start(ctx, "IssueReplacement", entityIDAttr(oldLibCardID))
+	defer end(&err)
+
+	return lcr.issueReplacement(ctx, oldLibCardID, libCard, branchIDArg(ctx))
+}
+
+// IssueReplacementInBranch — IssueReplacement с явно указанным филиалом выдачи нового билета
+func (lcr *LibCardRepo) IssueReplacementInBranch(ctx context.Context, oldLibCardID uuid.UUID, libCard *models.LibCardModel, branchID uuid.UUID) (err error) {
+	ctx, end := lcr.start(ctx, "IssueReplacementInBranch", entityIDAttr(oldLibCardID))
+	defer end(&err)
+
+	return lcr.issueReplacement(ctx, oldLibCardID, libCard, &branchID)
+}
+
+func (lcr *LibCardRepo) issueReplacement(ctx context.Context, oldLibCardID uuid.UUID, libCard *models.LibCardModel, branchID *uuid.UUID) error {
+	lcr.logger.Debugf("issuing replacement for libCard with ID: %s", oldLibCardID)
+
+	if libCard == nil {
+		lcr.logger.Debugf("replacement libCard object is nil")
+		return errs.ErrLibCardObjectIsNil
+	}
+
+	err := lcr.trManager.Do(ctx, func(ctx context.Context) error {
+		oldLibCard, err := lcr.getForUpdate(ctx, oldLibCardID)
+		if err != nil {
+			return err
+		}
+		if oldLibCard.BlockedAt == nil {
+			if err = lcr.block(ctx, oldLibCardID, LibCardReplaced); err != nil {
+				return err
+			}
+		}
+
+		if libCard.LibCardNum == "" {
+			if libCard.LibCardNum, err = lcr.NextNum(ctx); err != nil {
+				return err
+			}
+		} else if err = validateLibCardNum(&lcr.numConfig, libCard.LibCardNum, time.Now().Year()); err != nil {
+			return err
+		}
+		libCard.ReaderID = oldLibCard.ReaderID
+		if err = checkBranchExists(ctx, lcr.getter.DefaultTrOrDB(ctx, lcr.db), branchID); err != nil {
+			return err
+		}
+
+		// без филиала замена остается в филиале прежнего билета
+		query := `insert into bs.lib_card 
+				 	(id, reader_id, lib_card_num, validity, issue_date, action_status, replaces_id, branch_id) 
+				 values ($1, $2, $3, $4, $5, $6, $7, coalesce($8::uuid, (select branch_id from bs.lib_card where id = $7)))`
+
+		_, err = lcr.getter.DefaultTrOrDB(ctx, lcr.db).ExecContext(
+			ctx, query,
+			libCard.ID,
+			libCard.ReaderID,
+			libCard.LibCardNum,
+			libCard.Validity,
+			libCard.IssueDate,
+			libCard.ActionStatus,
+			oldLibCardID,
+			branchID,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return errs.ErrLibCardAlreadyExist
+		}
+		if err != nil {
+			return err
+		}
+
+		return lcr.auditChange(ctx, libCard.ID, AuditOperationCreate, nil)
+	})
+	if err != nil && (errors.Is(err, errs.ErrLibCardDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrInvalidLibCardNum) ||
+		errors.Is(err, repoerrs.ErrBranchDoesNotExists) ||
+		errors.Is(err, errs.ErrLibCardAlreadyExist)) {
+		lcr.logger.Debugf("replacement for libCard with ID %s can't be issued: %v", oldLibCardID, err)
 		return err
 	}
-	if rows != 1 {
-		lcr.logger.Errorf("error updating libCard: expected 1 row affected, got %d", rows)
-		return errors.New("libCardRepo.Update: expected 1 row affected")
+	if err != nil {
+		lcr.logger.Debugf("error issuing replacement libCard: %v", err)
+		return err
 	}
 
-	lcr.logger.Infof("updated libCard with ID: %s", libCard.ID)
+	lcr.logger.Debugf("issued libCard with ID %s replacing libCard with ID: %s", libCard.ID, oldLibCardID)
 
 	return nil
 }
 
+// GetAllByReaderID возвращает все билеты читателя, включая заблокированные, вместе с их статусами
+func (lcr *LibCardRepo) GetAllByReaderID(ctx context.Context, readerID uuid.UUID) (_ []*repomodels.LibCardStatusModel, err error) {
+	ctx, end := lcr.start(ctx, "GetAllByReaderID", entityIDAttr(readerID))
+	defer end(&err)
+
+	lcr.logger.Debugf("selecting all libCards with readerID: %s", readerID)
+
+	query := `select 
+    			id, 
+    			reader_id, 
+    			lib_card_num, 
+    			validity, 
+    			issue_date, 
+    			action_status, 
+    			block_reason, 
+    			blocked_at, 
+    			replaces_id, 
+    			case 
+    			    when block_reason is not null then block_reason::text 
+    			    when issue_date + validity < current_date then $2 
+    			    when action_status then $3 
+    			    else $4 
+    			end as status 
+			  from bs.lib_card_view 
+			  where reader_id = $1 
+			  order by issue_date desc`
+
+	var libCards []*repomodels.LibCardStatusModel
+	err = lcr.db.SelectContext(ctx, &libCards, query, readerID, LibCardExpired, LibCardActive, LibCardInactive)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		lcr.logger.Debugf("error selecting libCards: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(libCards) == 0 {
+		lcr.logger.Debugf("libCards with this readerID not found: %v", readerID)
+		return nil, errs.ErrLibCardDoesNotExists
+	}
+
+	lcr.logger.Debugf("found %d libCards with readerID: %s", len(libCards), readerID)
+
+	return libCards, nil
+}
+
+func (lcr *LibCardRepo) block(ctx context.Context, libCardID uuid.UUID, reason string) error {
+	before, err := lcr.getForUpdate(ctx, libCardID)
+	if err != nil {
+		return err
+	}
+	if before.BlockedAt != nil {
+		return repoerrs.ErrLibCardIsBlocked
+	}
+
+	query := `update bs.lib_card 
+			  set action_status = false, 
+			      block_reason = $1, 
+			      blocked_at = now() 
+			  where id = $2`
+
+	if _, err = lcr.getter.DefaultTrOrDB(ctx, lcr.db).ExecContext(ctx, query, reason, libCardID); err != nil {
+		return err
+	}
+
+	return lcr.auditChange(ctx, libCardID, AuditOperationUpdate, before)
+}
+
+// libCardSnapshot — полное состояние строки билета, которое сохраняется в журнал изменений
+type libCardSnapshot struct {
+	repomodels.LibCardModel
+	BlockReason *string    `db:"block_reason"`
+	BlockedAt   *time.Time `db:"blocked_at"`
+	ReplacesID  *uuid.UUID `db:"replaces_id"`
+}
+
+// getForUpdate блокирует строку билета до конца транзакции и возвращает ее состояние
+func (lcr *LibCardRepo) getForUpdate(ctx context.Context, libCardID uuid.UUID) (*libCardSnapshot, error) {
+	query := `select 
+    			id, 
+    			reader_id, 
+    			lib_card_num, 
+    			validity, 
+    			issue_date, 
+    			action_status, 
+    			block_reason, 
+    			blocked_at, 
+    			replaces_id 
+			  from bs.lib_card 
+			  where id = $1 
+			  for update`
+
+	var snapshot libCardSnapshot
+	err := lcr.getter.DefaultTrOrDB(ctx, lcr.db).GetContext(ctx, &snapshot, query, libCardID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrLibCardDoesNotExists
+	}
+
+	return &snapshot, nil
+}
+
+// auditChange перечитывает билет после изменения и пишет разницу с before в журнал
+func (lcr *LibCardRepo) auditChange(ctx context.Context, libCardID uuid.UUID, operation string, before *libCardSnapshot) error {
+	after, err := lcr.getForUpdate(ctx, libCardID)
+	if err != nil {
+		return err
+	}
+
+	return lcr.audit.write(ctx, AuditEntityLibCard, libCardID, operation, before, after)
+}
+
 func (lcr *LibCardRepo) convertToLibCardModel(libCard *repomodels.LibCardModel) *models.LibCardModel {
 	return &models.LibCardModel{
 		ID:           libCard.ID,
@@ -165,3 +759,12 @@ func (lcr *LibCardRepo) convertToLibCardModel(libCard *repomodels.LibCardModel)
 		ActionStatus: libCard.ActionStatus,
 	}
 }
+
+func (lcr *LibCardRepo) convertToLibCardModels(coreLibCards []*repomodels.LibCardModel) []*models.LibCardModel {
+	libCards := make([]*models.LibCardModel, len(coreLibCards))
+	for i, libCard := range coreLibCards {
+		libCards[i] = lcr.convertToLibCardModel(libCard)
+	}
+
+	return libCards
+}
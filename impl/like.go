@@ -0,0 +1,11 @@
+package impl
+
+import "strings"
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// escapeLike экранирует \, % и _ во введенной пользователем строке, чтобы like/ilike
+// искали ее как подстроку, а не как шаблон
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,91 @@
+package impl
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+)
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+// Logger — то, что репозиториям нужно от логгера сервиса. Реализация для log/slog
+// создается через NewSlogLogger, для logrus — через пакет logrusadapter, чтобы сервисам
+// на slog не приходилось тянуть logrus
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Warnf(format string, args ...any)
+	Errorf(format string, args ...any)
+	// LogFields пишет структурированную запись с дополнительными полями
+	LogFields(level LogLevel, msg string, fields map[string]any)
+}
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (sl *slogLogger) Debugf(format string, args ...any) {
+	sl.logf(slog.LevelDebug, format, args...)
+}
+
+func (sl *slogLogger) Infof(format string, args ...any) {
+	sl.logf(slog.LevelInfo, format, args...)
+}
+
+func (sl *slogLogger) Warnf(format string, args ...any) {
+	sl.logf(slog.LevelWarn, format, args...)
+}
+
+func (sl *slogLogger) Errorf(format string, args ...any) {
+	sl.logf(slog.LevelError, format, args...)
+}
+
+func (sl *slogLogger) LogFields(level LogLevel, msg string, fields map[string]any) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]slog.Attr, len(keys))
+	for i, key := range keys {
+		attrs[i] = slog.Any(key, fields[key])
+	}
+
+	sl.logger.LogAttrs(context.Background(), sl.level(level), msg, attrs...)
+}
+
+// logf не форматирует сообщение, если уровень отключен: большая часть записей репозиториев — debug
+func (sl *slogLogger) logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !sl.logger.Enabled(ctx, level) {
+		return
+	}
+
+	sl.logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+func (sl *slogLogger) level(level LogLevel) slog.Level {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelWarn:
+		return slog.LevelWarn
+	case LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
@@ -0,0 +1,94 @@
+package impl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+const redactedValue = "[redacted]"
+
+// LogLevels задает уровни итоговой записи об успешном вызове репозитория.
+// Ошибки всегда пишутся на уровне warn (ожидаемые) или error
+type LogLevels struct {
+	Read  LogLevel // методы Get*, Is*, Search, Export
+	Write LogLevel // все остальные методы
+}
+
+var DefaultLogLevels = LogLevels{Read: LogLevelDebug, Write: LogLevelInfo}
+
+var readMethodPrefixes = []string{"Get", "Is", "Search", "Export"}
+
+// SetLogLevels меняет уровни итоговых записей о вызовах. Вызывается до начала работы с репозиторием
+func (in *instrumentation) SetLogLevels(levels LogLevels) {
+	in.logLevels = &levels
+}
+
+// logCall пишет одну структурированную запись об итоге вызова метода
+func (in *instrumentation) logCall(fields map[string]any, method string, started time.Time, err error) {
+	if in.logger == nil {
+		return
+	}
+
+	levels := DefaultLogLevels
+	if in.logLevels != nil {
+		levels = *in.logLevels
+	}
+
+	fields["duration_ms"] = float64(time.Since(started).Microseconds()) / 1000
+
+	if err != nil {
+		fields["error"] = err.Error()
+		fields["error_class"] = classifyError(err)
+		if isExpectedError(err) {
+			in.logger.LogFields(LogLevelWarn, "repository call failed", fields)
+		} else {
+			in.logger.LogFields(LogLevelError, "repository call failed", fields)
+		}
+		return
+	}
+
+	level := levels.Write
+	if isReadMethod(method) {
+		level = levels.Read
+	}
+
+	in.logger.LogFields(level, "repository call succeeded", fields)
+}
+
+func isReadMethod(method string) bool {
+	for _, prefix := range readMethodPrefixes {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExpectedError отделяет штатные отказы (нет записи, конфликт, отмена запроса) от сбоев
+func isExpectedError(err error) bool {
+	switch classifyError(err) {
+	case ErrorClassNotFound, ErrorClassConflict, ErrorClassRejected, ErrorClassCanceled:
+		return true
+	default:
+		return false
+	}
+}
+
+// redactPhone оставляет только две последние цифры номера телефона
+func redactPhone(phoneNumber string) string {
+	if len(phoneNumber) <= 2 {
+		return redactedValue
+	}
+
+	return strings.Repeat("*", len(phoneNumber)-2) + phoneNumber[len(phoneNumber)-2:]
+}
+
+// redactToken заменяет токен префиксом его хеша, чтобы записи об одном токене можно было сопоставить
+func redactToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return "sha256:" + hex.EncodeToString(sum[:4])
+}
@@ -0,0 +1,242 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultMaxFailedLogins    = 5
+	DefaultFailedLoginsWindow = 15 * time.Minute
+	DefaultLoginLockoutTime   = 30 * time.Minute
+)
+
+type LoginAttemptConfig struct {
+	MaxFailures int           // число неудачных попыток, после которого вход блокируется
+	Window      time.Duration // скользящее окно, в котором считаются неудачные попытки
+	LockoutTime time.Duration // длительность блокировки
+}
+
+type LoginAttemptRepo struct {
+	instrumentation
+
+	db     *sqlx.DB
+	client *redis.Client
+	config LoginAttemptConfig
+}
+
+func NewLoginAttemptRepo(db *sqlx.DB, client *redis.Client, config LoginAttemptConfig, logger Logger) *LoginAttemptRepo {
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = DefaultMaxFailedLogins
+	}
+	if config.Window <= 0 {
+		config.Window = DefaultFailedLoginsWindow
+	}
+	if config.LockoutTime <= 0 {
+		config.LockoutTime = DefaultLoginLockoutTime
+	}
+
+	return &LoginAttemptRepo{
+		instrumentation: instrumentation{repo: "login_attempt", dbSystem: dbSystemPostgres, table: "bs.login_history", logger: logger},
+		db:              db,
+		client:          client,
+		config:          config,
+	}
+}
+
+// RegisterFailure учитывает неудачную попытку входа и при превышении лимита блокирует вход
+// для пары (номер телефона, IP)
+func (lar *LoginAttemptRepo) RegisterFailure(ctx context.Context, phoneNumber, ip string) (_ *repodto.LoginLockoutDTO, err error) {
+	ctx, end := lar.start(ctx, "RegisterFailure", redisSystemAttr)
+	defer end(&err)
+
+	lar.logger.Debugf("registering failed login from ip: %s", ip)
+
+	now := time.Now()
+	failuresKey := lar.failuresKey(phoneNumber, ip)
+
+	var count *redis.IntCmd
+	_, err = lar.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(ctx, failuresKey, "-inf", strconv.FormatInt(now.Add(-lar.config.Window).UnixNano(), 10))
+		pipe.ZAdd(ctx, failuresKey, &redis.Z{Score: float64(now.UnixNano()), Member: uuid.New().String()})
+		count = pipe.ZCard(ctx, failuresKey)
+		pipe.Expire(ctx, failuresKey, lar.config.Window)
+		return nil
+	})
+	if err != nil {
+		lar.logger.Debugf("error registering failed login: %v", err)
+		return nil, err
+	}
+
+	lockout := &repodto.LoginLockoutDTO{FailedAttempts: int(count.Val())}
+	if lockout.FailedAttempts < lar.config.MaxFailures {
+		lar.logger.Debugf("registered failed login, attempts in window: %d", lockout.FailedAttempts)
+		return lockout, nil
+	}
+
+	// уже действующая блокировка не продлевается: иначе новыми неудачными попытками
+	// можно держать чужой номер заблокированным бесконечно
+	lockoutKey := lar.lockoutKey(phoneNumber, ip)
+	locked, err := lar.client.SetNX(ctx, lockoutKey, now.Unix(), lar.config.LockoutTime).Result()
+	if err != nil {
+		lar.logger.Debugf("error locking out login: %v", err)
+		return nil, err
+	}
+
+	lockout.IsLocked = true
+	lockout.RemainingTime = lar.config.LockoutTime
+
+	if !locked {
+		ttl, err := lar.client.PTTL(ctx, lockoutKey).Result()
+		if err != nil {
+			lar.logger.Debugf("error checking login lockout: %v", err)
+			return nil, err
+		}
+		if ttl > 0 {
+			lockout.RemainingTime = ttl
+		}
+
+		lar.logger.Debugf("login from ip %s is already locked out", ip)
+
+		return lockout, nil
+	}
+
+	lar.logger.Warnf("login from ip %s locked out for %s", ip, lar.config.LockoutTime)
+
+	return lockout, nil
+}
+
+func (lar *LoginAttemptRepo) GetLockout(ctx context.Context, phoneNumber, ip string) (_ *repodto.LoginLockoutDTO, err error) {
+	ctx, end := lar.start(ctx, "GetLockout", redisSystemAttr)
+	defer end(&err)
+
+	lar.logger.Debugf("checking login lockout for ip: %s", ip)
+
+	failuresKey := lar.failuresKey(phoneNumber, ip)
+	minScore := strconv.FormatInt(time.Now().Add(-lar.config.Window).UnixNano(), 10)
+
+	var (
+		count *redis.IntCmd
+		ttl   *redis.DurationCmd
+	)
+	_, err = lar.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = pipe.ZCount(ctx, failuresKey, minScore, "+inf")
+		ttl = pipe.PTTL(ctx, lar.lockoutKey(phoneNumber, ip))
+		return nil
+	})
+	if err != nil {
+		lar.logger.Debugf("error checking login lockout: %v", err)
+		return nil, err
+	}
+
+	// PTTL возвращает отрицательное значение, если ключа нет или у него нет срока жизни
+	lockout := &repodto.LoginLockoutDTO{FailedAttempts: int(count.Val())}
+	if ttl.Val() > 0 {
+		lockout.IsLocked = true
+		lockout.RemainingTime = ttl.Val()
+	}
+
+	lar.logger.Debugf("checked login lockout for ip: %s", ip)
+
+	return lockout, nil
+}
+
+// Reset вызывается после успешного входа и снимает счетчик и блокировку
+func (lar *LoginAttemptRepo) Reset(ctx context.Context, phoneNumber, ip string) (err error) {
+	ctx, end := lar.start(ctx, "Reset", redisSystemAttr)
+	defer end(&err)
+
+	lar.logger.Debugf("resetting failed logins for ip: %s", ip)
+
+	err = lar.client.Del(ctx, lar.failuresKey(phoneNumber, ip), lar.lockoutKey(phoneNumber, ip)).Err()
+	if err != nil {
+		lar.logger.Debugf("error resetting failed logins: %v", err)
+		return err
+	}
+
+	lar.logger.Debugf("reset failed logins for ip: %s", ip)
+
+	return nil
+}
+
+func (lar *LoginAttemptRepo) SaveLogin(ctx context.Context, login *repomodels.LoginHistoryModel) (err error) {
+	ctx, end := lar.start(ctx, "SaveLogin", entityIDAttr(login.ReaderID))
+	defer end(&err)
+
+	lar.logger.Debugf("inserting login of reader with ID: %s", login.ReaderID)
+
+	query := `insert into bs.login_history values ($1, $2, $3, $4, $5)`
+
+	result, err := lar.db.ExecContext(
+		ctx, query,
+		login.ID,
+		login.ReaderID,
+		login.LoginTime,
+		login.Success,
+		login.IP,
+	)
+	if err != nil {
+		lar.logger.Debugf("error inserting login: %v", err)
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		lar.logger.Debugf("error inserting login: %v", err)
+		return err
+	}
+	setRowsAffected(ctx, rows)
+	if rows != 1 {
+		lar.logger.Debugf("error inserting login: expected 1 row affected, got %d", rows)
+		return errors.New("loginAttemptRepo.SaveLogin: expected 1 row affected")
+	}
+
+	lar.logger.Debugf("inserted login of reader with ID: %s", login.ReaderID)
+
+	return nil
+}
+
+func (lar *LoginAttemptRepo) GetHistoryByReaderID(ctx context.Context, readerID uuid.UUID, limit uint, offset int) (_ []*repomodels.LoginHistoryModel, err error) {
+	ctx, end := lar.start(ctx, "GetHistoryByReaderID", entityIDAttr(readerID))
+	defer end(&err)
+
+	lar.logger.Debugf("selecting login history of reader with ID: %s", readerID)
+
+	query := `select id, reader_id, login_time, success, ip 
+			  from bs.login_history 
+			  where reader_id = $1 
+			  order by login_time desc 
+			  limit $2 offset $3`
+
+	var history []*repomodels.LoginHistoryModel
+	err = lar.db.SelectContext(ctx, &history, query, readerID, limit, offset)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		lar.logger.Debugf("error selecting login history: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(history) == 0 {
+		lar.logger.Debugf("login history of reader with ID not found: %s", readerID)
+		return nil, repoerrs.ErrLoginHistoryDoesNotExists
+	}
+
+	lar.logger.Debugf("found %d logins of reader with ID: %s", len(history), readerID)
+
+	return history, nil
+}
+
+func (lar *LoginAttemptRepo) failuresKey(phoneNumber, ip string) string {
+	return fmt.Sprintf("login:%s:%s:failures", phoneNumber, ip)
+}
+
+func (lar *LoginAttemptRepo) lockoutKey(phoneNumber, ip string) string {
+	return fmt.Sprintf("login:%s:%s:lockout", phoneNumber, ip)
+}
@@ -0,0 +1,34 @@
+package logrusadapter
+
+import (
+	"github.com/nikitalystsev/BookSmart-repo-postgres/impl"
+	"github.com/sirupsen/logrus"
+)
+
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+var _ impl.Logger = (*logrusLogger)(nil)
+
+// New оборачивает logrus.Entry в логгер для репозиториев
+func New(entry *logrus.Entry) impl.Logger {
+	return &logrusLogger{Entry: entry}
+}
+
+func (ll *logrusLogger) LogFields(level impl.LogLevel, msg string, fields map[string]any) {
+	ll.Entry.WithFields(fields).Log(ll.level(level), msg)
+}
+
+func (ll *logrusLogger) level(level impl.LogLevel) logrus.Level {
+	switch level {
+	case impl.LogLevelDebug:
+		return logrus.DebugLevel
+	case impl.LogLevelWarn:
+		return logrus.WarnLevel
+	case impl.LogLevelError:
+		return logrus.ErrorLevel
+	default:
+		return logrus.InfoLevel
+	}
+}
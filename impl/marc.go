@@ -0,0 +1,321 @@
+package impl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	BookImportFormatMARC21  = "marc21"
+	BookImportFormatMARCXML = "marcxml"
+)
+
+// в MARC нет экземпляров, редкости и возрастного ограничения; без явных значений
+// в BookImportParamsDTO.Defaults книги загружаются с этими
+const (
+	DefaultMARCCopiesNumber = 1
+	DefaultMARCRarity       = impl.BookRarityCommon
+	DefaultMARCAgeLimit     = 0
+)
+
+// разделители ISO 2709
+const (
+	marcLeaderLength      = 24
+	marcDirEntryLength    = 12
+	marcSubfieldDelimiter = 0x1F
+	marcFieldTerminator   = 0x1E
+	marcRecordTerminator  = 0x1D
+	marcUnicodeCoding     = 'a' // 9-я позиция маркера: кодировка UCS/Unicode
+)
+
+// marcMappedTags — поля, которые переносятся в книгу; остальные попадают в отчет как пропущенные
+var marcMappedTags = map[string]struct{}{
+	"008": {}, "041": {}, "100": {}, "110": {}, "245": {}, "260": {}, "264": {}, "650": {}, "655": {},
+}
+
+var marcYearRegexp = regexp.MustCompile(`\d{4}`)
+
+type marcSubfield struct {
+	code  string
+	value string
+}
+
+type marcField struct {
+	tag       string
+	ind2      string
+	value     string // только для управляющих полей 00X
+	subfields []marcSubfield
+}
+
+type marcRecord struct {
+	leader string
+	fields []marcField
+}
+
+func (mr *marcRecord) field(tags ...string) *marcField {
+	for _, tag := range tags {
+		for i := range mr.fields {
+			if mr.fields[i].tag == tag {
+				return &mr.fields[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func (mf *marcField) subfield(code string) string {
+	if mf == nil {
+		return ""
+	}
+	for _, sf := range mf.subfields {
+		if sf.code == code {
+			return sf.value
+		}
+	}
+
+	return ""
+}
+
+// newMARCImportReader читает записи MARC и отдает их как строки загрузки. Номер «строки» — номер записи в файле
+func newMARCImportReader(r io.Reader, format string, defaults *repodto.BookImportDefaultsDTO) func() (int, *bookImportRow, error) {
+	var next func() (*marcRecord, error)
+	if format == BookImportFormatMARCXML {
+		next = newMARCXMLReader(r)
+	} else {
+		next = newMARC21Reader(r)
+	}
+
+	if defaults == nil {
+		defaults = &repodto.BookImportDefaultsDTO{
+			CopiesNumber: DefaultMARCCopiesNumber,
+			Rarity:       DefaultMARCRarity,
+			AgeLimit:     DefaultMARCAgeLimit,
+		}
+	}
+
+	num := 0
+
+	return func() (int, *bookImportRow, error) {
+		record, err := next()
+		if errors.Is(err, io.EOF) {
+			return 0, nil, io.EOF
+		}
+		num++
+		if err != nil {
+			return num, nil, err
+		}
+
+		return num, mapMARCRecord(record, defaults), nil
+	}
+}
+
+// mapMARCRecord переносит поля записи в книгу: 245 — название, 100 (110) — автор,
+// 264 (260) — издательство и год, 041 (008/35-37) — язык, 650 (655) — жанр
+func mapMARCRecord(record *marcRecord, defaults *repodto.BookImportDefaultsDTO) *bookImportRow {
+	row := &bookImportRow{
+		CopiesNumber: int(defaults.CopiesNumber),
+		Rarity:       defaults.Rarity,
+		AgeLimit:     int(defaults.AgeLimit),
+	}
+
+	title := record.field("245")
+	row.Title = trimMARCPunctuation(strings.TrimSpace(title.subfield("a") + " " + trimMARCPunctuation(title.subfield("b"))))
+	row.Author = trimMARCPunctuation(record.field("100", "110").subfield("a"))
+
+	publication := record.publicationField()
+	row.Publisher = trimMARCPunctuation(publication.subfield("b"))
+	if year := marcYearRegexp.FindString(publication.subfield("c")); year != "" {
+		row.PublishingYear, _ = strconv.Atoi(year)
+	}
+
+	row.Language = record.field("041").subfield("a")
+	row.Genre = trimMARCPunctuation(record.field("650", "655").subfield("a"))
+
+	// 008: позиции 7-10 — год издания, 35-37 — код языка
+	if fixed := record.field("008"); fixed != nil && len(fixed.value) >= 38 {
+		if row.PublishingYear == 0 {
+			row.PublishingYear, _ = strconv.Atoi(fixed.value[7:11])
+		}
+		if row.Language == "" {
+			row.Language = strings.TrimSpace(fixed.value[35:38])
+		}
+	}
+
+	for _, field := range record.fields {
+		if _, mapped := marcMappedTags[field.tag]; !mapped {
+			row.skipped = append(row.skipped, field.tag)
+		}
+	}
+
+	return row
+}
+
+// publicationField предпочитает 264 со вторым индикатором 1 (публикация), затем любое 264, затем 260
+func (mr *marcRecord) publicationField() *marcField {
+	for i := range mr.fields {
+		if mr.fields[i].tag == "264" && mr.fields[i].ind2 == "1" {
+			return &mr.fields[i]
+		}
+	}
+
+	return mr.field("264", "260")
+}
+
+// trimMARCPunctuation убирает завершающую пунктуацию ISBD: «Война и мир /» -> «Война и мир»
+func trimMARCPunctuation(value string) string {
+	return strings.TrimRight(strings.TrimSpace(value), " /:;,.=")
+}
+
+func newMARC21Reader(r io.Reader) func() (*marcRecord, error) {
+	reader := bufio.NewReader(r)
+
+	return func() (*marcRecord, error) {
+		var data []byte
+		for len(data) == 0 {
+			chunk, err := reader.ReadBytes(marcRecordTerminator)
+			if err != nil && (!errors.Is(err, io.EOF) || len(bytes.TrimSpace(chunk)) == 0) {
+				return nil, err
+			}
+			// записи в файлах поставщиков часто разделены переводами строк
+			data = bytes.TrimLeft(chunk, "\r\n ")
+		}
+
+		return parseMARC21Record(data)
+	}
+}
+
+func parseMARC21Record(data []byte) (*marcRecord, error) {
+	if len(data) < marcLeaderLength || data[len(data)-1] != marcRecordTerminator {
+		return nil, &bookImportRowError{message: "truncated MARC record"}
+	}
+
+	leader := string(data[:marcLeaderLength])
+	if leader[9] != marcUnicodeCoding && !utf8.Valid(data) {
+		return nil, &bookImportRowError{message: "MARC-8 encoded records are not supported, convert the file to UTF-8"}
+	}
+
+	baseAddress, ok := parseMARCNumber(data[12:17])
+	if !ok || baseAddress <= marcLeaderLength || baseAddress > len(data) {
+		return nil, &bookImportRowError{message: "invalid MARC leader"}
+	}
+
+	record := &marcRecord{leader: leader}
+	directory := data[marcLeaderLength : baseAddress-1]
+	for len(directory) >= marcDirEntryLength {
+		entry := directory[:marcDirEntryLength]
+		directory = directory[marcDirEntryLength:]
+
+		length, lengthOk := parseMARCNumber(entry[3:7])
+		start, startOk := parseMARCNumber(entry[7:12])
+		if !lengthOk || !startOk || baseAddress+start+length > len(data) {
+			return nil, &bookImportRowError{message: fmt.Sprintf("invalid MARC directory entry for field %s", entry[:3])}
+		}
+
+		fieldData := bytes.TrimRight(data[baseAddress+start:baseAddress+start+length], string(rune(marcFieldTerminator)))
+		record.fields = append(record.fields, parseMARC21Field(string(entry[:3]), fieldData))
+	}
+
+	return record, nil
+}
+
+// parseMARCNumber разбирает числовую позицию маркера или справочника. В отличие от strconv.Atoi
+// допускает только цифры: знак в длине или начале поля давал бы выход за границы записи
+func parseMARCNumber(data []byte) (int, bool) {
+	n := 0
+	for _, b := range data {
+		if b < '0' || b > '9' {
+			return 0, false
+		}
+		n = n*10 + int(b-'0')
+	}
+
+	return n, len(data) > 0
+}
+
+func parseMARC21Field(tag string, data []byte) marcField {
+	field := marcField{tag: tag}
+	if strings.HasPrefix(tag, "00") {
+		field.value = string(data)
+		return field
+	}
+
+	if len(data) >= 2 {
+		field.ind2 = string(data[1])
+		data = data[2:]
+	}
+
+	for _, chunk := range bytes.Split(data, []byte{marcSubfieldDelimiter}) {
+		if len(chunk) == 0 {
+			continue
+		}
+		field.subfields = append(field.subfields, marcSubfield{code: string(chunk[0]), value: string(chunk[1:])})
+	}
+
+	return field
+}
+
+type marcXMLRecord struct {
+	Leader        string `xml:"leader"`
+	ControlFields []struct {
+		Tag   string `xml:"tag,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"controlfield"`
+	DataFields []struct {
+		Tag       string `xml:"tag,attr"`
+		Ind2      string `xml:"ind2,attr"`
+		Subfields []struct {
+			Code  string `xml:"code,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"subfield"`
+	} `xml:"datafield"`
+}
+
+// newMARCXMLReader читает элементы record в любом месте документа, поэтому подходит
+// и для collection, и для одиночной записи
+func newMARCXMLReader(r io.Reader) func() (*marcRecord, error) {
+	decoder := xml.NewDecoder(r)
+
+	return func() (*marcRecord, error) {
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			start, ok := token.(xml.StartElement)
+			if !ok || start.Name.Local != "record" {
+				continue
+			}
+
+			var xmlRecord marcXMLRecord
+			if err = decoder.DecodeElement(&xmlRecord, &start); err != nil {
+				return nil, err
+			}
+
+			record := &marcRecord{leader: xmlRecord.Leader}
+			for _, cf := range xmlRecord.ControlFields {
+				record.fields = append(record.fields, marcField{tag: cf.Tag, value: cf.Value})
+			}
+			for _, df := range xmlRecord.DataFields {
+				field := marcField{tag: df.Tag, ind2: df.Ind2}
+				for _, sf := range df.Subfields {
+					field.subfields = append(field.subfields, marcSubfield{code: sf.Code, value: sf.Value})
+				}
+				record.fields = append(record.fields, field)
+			}
+
+			return record, nil
+		}
+	}
+}
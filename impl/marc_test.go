@@ -0,0 +1,166 @@
+package impl
+
+import (
+	"bytes"
+	"fmt"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type marcTestField struct {
+	tag  string
+	data string
+}
+
+// buildMARC21Record собирает запись ISO 2709 с корректными маркером и справочником
+func buildMARC21Record(fields ...marcTestField) []byte {
+	var directory, body bytes.Buffer
+	for _, field := range fields {
+		data := field.data + string(rune(marcFieldTerminator))
+		fmt.Fprintf(&directory, "%s%04d%05d", field.tag, len(data), body.Len())
+		body.WriteString(data)
+	}
+	directory.WriteByte(marcFieldTerminator)
+
+	baseAddress := marcLeaderLength + directory.Len()
+	length := baseAddress + body.Len() + 1
+
+	var record bytes.Buffer
+	fmt.Fprintf(&record, "%05dnam a22%05d   4500", length, baseAddress)
+	record.Write(directory.Bytes())
+	record.Write(body.Bytes())
+	record.WriteByte(marcRecordTerminator)
+
+	return record.Bytes()
+}
+
+func marcSubfields(ind string, subfields ...string) string {
+	var b strings.Builder
+	b.WriteString(ind)
+	for _, sf := range subfields {
+		b.WriteByte(marcSubfieldDelimiter)
+		b.WriteString(sf)
+	}
+
+	return b.String()
+}
+
+// corruptMARCDirectory заменяет первую запись справочника
+func corruptMARCDirectory(record []byte, entry string) []byte {
+	corrupted := bytes.Clone(record)
+	copy(corrupted[marcLeaderLength:], entry)
+
+	return corrupted
+}
+
+func TestParseMARC21Record(t *testing.T) {
+	valid := buildMARC21Record(
+		marcTestField{tag: "001", data: "42"},
+		marcTestField{tag: "245", data: marcSubfields("10", "aTitle /", "cAuthor")},
+	)
+
+	record, err := parseMARC21Record(valid)
+	if err != nil {
+		t.Fatalf("parseMARC21Record(valid) unexpected error: %v", err)
+	}
+	wantFields := []marcField{
+		{tag: "001", value: "42"},
+		{tag: "245", ind2: "0", subfields: []marcSubfield{{code: "a", value: "Title /"}, {code: "c", value: "Author"}}},
+	}
+	if !reflect.DeepEqual(record.fields, wantFields) {
+		t.Errorf("parseMARC21Record(valid) fields = %+v, want %+v", record.fields, wantFields)
+	}
+
+	baseAddressAt := bytes.Clone(valid)
+	copy(baseAddressAt[12:17], "+0049")
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "too short", data: []byte("00024nam")},
+		{name: "no record terminator", data: valid[:len(valid)-1]},
+		{name: "signed base address", data: baseAddressAt},
+		{name: "negative length", data: corruptMARCDirectory(valid, "245-00100000")},
+		{name: "negative start", data: corruptMARCDirectory(valid, "2450003-0001")},
+		{name: "non-digit length", data: corruptMARCDirectory(valid, "24500a100000")},
+		{name: "start past end", data: corruptMARCDirectory(valid, "245000399999")},
+		{name: "length past end", data: corruptMARCDirectory(valid, "245999900000")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMARC21Record(tt.data)
+			if _, ok := err.(*bookImportRowError); !ok {
+				t.Errorf("parseMARC21Record() error = %v, want *bookImportRowError", err)
+			}
+		})
+	}
+}
+
+func TestMapMARCRecord(t *testing.T) {
+	defaults := &repodto.BookImportDefaultsDTO{CopiesNumber: 2, Rarity: DefaultMARCRarity, AgeLimit: 6}
+	fixed := "190101s2015" + strings.Repeat(" ", 24) + "rus d"
+
+	tests := []struct {
+		name   string
+		fields []marcField
+		want   *bookImportRow
+	}{
+		{
+			name: "publication field 264",
+			fields: []marcField{
+				{tag: "008", value: fixed},
+				{tag: "100", subfields: []marcSubfield{{code: "a", value: "Толстой, Л. Н.,"}}},
+				{tag: "245", subfields: []marcSubfield{{code: "a", value: "Война и мир :"}, {code: "b", value: "роман /"}}},
+				{tag: "260", subfields: []marcSubfield{{code: "b", value: "Старое изд.,"}, {code: "c", value: "1999."}}},
+				{tag: "264", ind2: "1", subfields: []marcSubfield{{code: "b", value: "Эксмо,"}, {code: "c", value: "c2019."}}},
+				{tag: "500", subfields: []marcSubfield{{code: "a", value: "Примечание"}}},
+				{tag: "650", subfields: []marcSubfield{{code: "a", value: "Роман."}}},
+			},
+			want: &bookImportRow{
+				Title:          "Война и мир : роман",
+				Author:         "Толстой, Л. Н",
+				Publisher:      "Эксмо",
+				PublishingYear: 2019,
+				Language:       "rus",
+				Genre:          "Роман",
+				CopiesNumber:   2,
+				Rarity:         DefaultMARCRarity,
+				AgeLimit:       6,
+				skipped:        []string{"500"},
+			},
+		},
+		{
+			name: "fallbacks to 008, 110 and 655",
+			fields: []marcField{
+				{tag: "008", value: fixed},
+				{tag: "041", subfields: []marcSubfield{{code: "a", value: "eng"}}},
+				{tag: "110", subfields: []marcSubfield{{code: "a", value: "Коллектив авторов."}}},
+				{tag: "245", subfields: []marcSubfield{{code: "a", value: "Сборник"}}},
+				{tag: "655", subfields: []marcSubfield{{code: "a", value: "Справочник"}}},
+			},
+			want: &bookImportRow{
+				Title:          "Сборник",
+				Author:         "Коллектив авторов",
+				PublishingYear: 2015,
+				Language:       "eng",
+				Genre:          "Справочник",
+				CopiesNumber:   2,
+				Rarity:         DefaultMARCRarity,
+				AgeLimit:       6,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := mapMARCRecord(&marcRecord{fields: tt.fields}, defaults)
+			if !reflect.DeepEqual(row, tt.want) {
+				t.Errorf("mapMARCRecord() = %+v, want %+v", row, tt.want)
+			}
+		})
+	}
+}
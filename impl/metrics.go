@@ -0,0 +1,285 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"time"
+)
+
+const metricsNamespace = "booksmart_repo"
+
+// классы ошибок для метки error_class
+const (
+	ErrorClassNotFound      = "not_found"
+	ErrorClassConflict      = "conflict"
+	ErrorClassRejected      = "rejected"
+	ErrorClassCanceled      = "canceled"
+	ErrorClassTimeout       = "timeout"
+	ErrorClassSerialization = "serialization"
+	ErrorClassDB            = "db"
+	ErrorClassRedis         = "redis"
+	ErrorClassInternal      = "internal"
+)
+
+var notFoundErrors = []error{
+	sql.ErrNoRows,
+	redis.Nil,
+	errs.ErrBookDoesNotExists,
+	errs.ErrReaderDoesNotExists,
+	errs.ErrLibCardDoesNotExists,
+	errs.ErrReservationDoesNotExists,
+	errs.ErrRatingDoesNotExists,
+	repoerrs.ErrAuditLogDoesNotExists,
+	repoerrs.ErrLibCardRenewalDoesNotExist,
+	repoerrs.ErrLoginHistoryDoesNotExists,
+	repoerrs.ErrVerificationCodeDoesNotExists,
+	repoerrs.ErrAuthorDoesNotExists,
+	repoerrs.ErrAuthorAliasDoesNotExists,
+	repoerrs.ErrPublisherDoesNotExists,
+	repoerrs.ErrPublisherAliasDoesNotExists,
+	repoerrs.ErrBookAuthorDoesNotExists,
+	repoerrs.ErrBookCopyDoesNotExists,
+	repoerrs.ErrBranchDoesNotExists,
+	repoerrs.ErrReservationRenewalDoesNotExist,
+	repoerrs.ErrBookHoldDoesNotExists,
+}
+
+var conflictErrors = []error{
+	errs.ErrLibCardAlreadyExist,
+	repoerrs.ErrReaderPhoneNumberAlreadyExist,
+	repoerrs.ErrBookHasActiveReservations,
+	repoerrs.ErrBookIsNotDeleted,
+	repoerrs.ErrBookISBNAlreadyExists,
+	repoerrs.ErrBookCopiesNumberMismatch,
+	repoerrs.ErrAuthorAlreadyExists,
+	repoerrs.ErrAuthorHasBooks,
+	repoerrs.ErrPublisherAlreadyExists,
+	repoerrs.ErrPublisherHasBooks,
+	repoerrs.ErrBookCopyBarcodeAlreadyExists,
+	repoerrs.ErrBookCopyIsIssued,
+	repoerrs.ErrBookCopyIsNotAvailable,
+	repoerrs.ErrBookCopyIsLostByReader,
+	repoerrs.ErrBranchAlreadyExists,
+	repoerrs.ErrBranchIsInUse,
+	errs.ErrReservationIsAlreadyClosed,
+	errs.ErrReservationIsAlreadyExpired,
+	repoerrs.ErrReservationRenewalLimitReached,
+	repoerrs.ErrReservationExtensionTooLong,
+	repoerrs.ErrBookHasWaitingReaders,
+	repoerrs.ErrBookHoldAlreadyExists,
+	repoerrs.ErrReaderIsAlreadyAnonymized,
+	repoerrs.ErrReaderIsDeactivated,
+	repoerrs.ErrLibCardIsBlocked,
+}
+
+var rejectedErrors = []error{
+	errs.ErrLibCardObjectIsNil,
+	repoerrs.ErrInvalidISBN,
+	repoerrs.ErrInvalidBookAuthorRole,
+	repoerrs.ErrAuthorAliasIsName,
+	repoerrs.ErrPublisherAliasIsName,
+	repoerrs.ErrInvalidBookCopyBarcode,
+	repoerrs.ErrInvalidBookCopyCondition,
+	repoerrs.ErrInvalidBookCopyStatus,
+	repoerrs.ErrBookCopyOfAnotherBook,
+	repoerrs.ErrBookCopyOfAnotherBranch,
+	repoerrs.ErrInvalidBranchCode,
+	repoerrs.ErrInvalidReservationRenewalPolicy,
+	repoerrs.ErrInvalidLibCardBlockReason,
+	repoerrs.ErrInvalidLibCardExtension,
+	repoerrs.ErrInvalidLibCardNum,
+	repoerrs.ErrVerificationCodeAttemptsExceeded,
+	repoerrs.ErrVerificationCodeIsInvalid,
+	repoerrs.ErrVerificationCodeResendTooEarly,
+}
+
+// Metrics — метрики вызовов репозиториев. Один экземпляр разделяется всеми репозиториями
+// и подключается к ним через SetMetrics
+type Metrics struct {
+	calls    *prometheus.CounterVec
+	errors   *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+
+	txAttempts       prometheus.Histogram
+	txRetries        *prometheus.CounterVec
+	txRetriesDropped prometheus.Counter
+}
+
+// NewMetrics регистрирует метрики вызовов, а также статистику пулов sql.DB и Redis
+// в переданном registerer. db и client могут быть nil, если соответствующий пул не используется
+func NewMetrics(registerer prometheus.Registerer, db *sqlx.DB, client *redis.Client) (*Metrics, error) {
+	metrics := &Metrics{
+		calls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "calls_total",
+			Help:      "Number of repository method calls.",
+		}, []string{"repo", "method"}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "errors_total",
+			Help:      "Number of repository method calls that returned an error.",
+		}, []string{"repo", "method", "error_class"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "call_duration_seconds",
+			Help:      "Repository method call latency.",
+			Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		}, []string{"repo", "method"}),
+		txAttempts: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "tx_attempts",
+			Help:      "Number of attempts a transaction took to complete.",
+			Buckets:   []float64{1, 2, 3, 4, 5, 8},
+		}),
+		txRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tx_retries_total",
+			Help:      "Number of transaction retries by reason.",
+		}, []string{"reason"}),
+		txRetriesDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tx_retries_dropped_total",
+			Help:      "Number of transaction retries skipped because the retry budget was exhausted.",
+		}),
+	}
+
+	toRegister := []prometheus.Collector{
+		metrics.calls,
+		metrics.errors,
+		metrics.duration,
+		metrics.txAttempts,
+		metrics.txRetries,
+		metrics.txRetriesDropped,
+	}
+	if db != nil {
+		toRegister = append(toRegister, collectors.NewDBStatsCollector(db.DB, "booksmart"))
+	}
+	if client != nil {
+		toRegister = append(toRegister, newRedisPoolCollector(client))
+	}
+
+	for _, collector := range toRegister {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
+
+func (m *Metrics) observe(repo, method string, started time.Time, err error) {
+	m.calls.WithLabelValues(repo, method).Inc()
+	m.duration.WithLabelValues(repo, method).Observe(time.Since(started).Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(repo, method, classifyError(err)).Inc()
+	}
+}
+
+func (m *Metrics) observeTxAttempts(attempts int) {
+	if m != nil {
+		m.txAttempts.Observe(float64(attempts))
+	}
+}
+
+func (m *Metrics) observeTxRetry(reason string) {
+	if m != nil {
+		m.txRetries.WithLabelValues(reason).Inc()
+	}
+}
+
+func (m *Metrics) observeTxRetryDropped() {
+	if m != nil {
+		m.txRetriesDropped.Inc()
+	}
+}
+
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrorClassCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorClassTimeout
+	case isAnyOf(err, notFoundErrors):
+		return ErrorClassNotFound
+	case isAnyOf(err, conflictErrors) || isUniqueViolation(err):
+		return ErrorClassConflict
+	case isAnyOf(err, rejectedErrors):
+		return ErrorClassRejected
+	case isSerializationError(err):
+		return ErrorClassSerialization
+	case pgErrorCode(err) != "":
+		return ErrorClassDB
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		return ErrorClassRedis
+	}
+
+	return ErrorClassInternal
+}
+
+func isAnyOf(err error, targets []error) bool {
+	for _, target := range targets {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// redisPoolCollector отдает статистику пула соединений go-redis
+type redisPoolCollector struct {
+	client *redis.Client
+
+	hits       *prometheus.Desc
+	misses     *prometheus.Desc
+	timeouts   *prometheus.Desc
+	totalConns *prometheus.Desc
+	idleConns  *prometheus.Desc
+	staleConns *prometheus.Desc
+}
+
+func newRedisPoolCollector(client *redis.Client) *redisPoolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "redis_pool", name), help, nil, nil)
+	}
+
+	return &redisPoolCollector{
+		client:     client,
+		hits:       desc("hits_total", "Number of times a free connection was found in the pool."),
+		misses:     desc("misses_total", "Number of times a free connection was not found in the pool."),
+		timeouts:   desc("timeouts_total", "Number of times a wait for a connection timed out."),
+		totalConns: desc("total_connections", "Number of connections in the pool."),
+		idleConns:  desc("idle_connections", "Number of idle connections in the pool."),
+		staleConns: desc("stale_connections_total", "Number of stale connections removed from the pool."),
+	}
+}
+
+func (rpc *redisPoolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rpc.hits
+	ch <- rpc.misses
+	ch <- rpc.timeouts
+	ch <- rpc.totalConns
+	ch <- rpc.idleConns
+	ch <- rpc.staleConns
+}
+
+func (rpc *redisPoolCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := rpc.client.PoolStats()
+
+	ch <- prometheus.MustNewConstMetric(rpc.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(rpc.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(rpc.timeouts, prometheus.CounterValue, float64(stats.Timeouts))
+	ch <- prometheus.MustNewConstMetric(rpc.totalConns, prometheus.GaugeValue, float64(stats.TotalConns))
+	ch <- prometheus.MustNewConstMetric(rpc.idleConns, prometheus.GaugeValue, float64(stats.IdleConns))
+	ch <- prometheus.MustNewConstMetric(rpc.staleConns, prometheus.CounterValue, float64(stats.StaleConns))
+}
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS bs.reader_role_age_idx;
+
+ALTER TABLE bs.reader
+    DROP COLUMN IF EXISTS deactivated_at;
@@ -0,0 +1,4 @@
+ALTER TABLE bs.reader
+    ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS reader_role_age_idx ON bs.reader (role, age);
@@ -0,0 +1,2 @@
+ALTER TABLE bs.reader
+    DROP COLUMN IF EXISTS anonymized_at;
@@ -0,0 +1,2 @@
+ALTER TABLE bs.reader
+    ADD COLUMN IF NOT EXISTS anonymized_at TIMESTAMPTZ;
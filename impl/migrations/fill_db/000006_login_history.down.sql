@@ -0,0 +1 @@
+DROP TABLE IF EXISTS bs.login_history;
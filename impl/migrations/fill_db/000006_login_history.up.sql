@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS bs.login_history
+(
+    id         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    reader_id  UUID             NOT NULL,
+    login_time TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    success    BOOLEAN          NOT NULL,
+    ip         VARCHAR(45)      NOT NULL,
+    FOREIGN KEY (reader_id) REFERENCES bs.reader (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS login_history_reader_time_idx ON bs.login_history (reader_id, login_time DESC);
@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS bs.lib_card_num_seq;
@@ -0,0 +1,5 @@
+CREATE SEQUENCE IF NOT EXISTS bs.lib_card_num_seq
+    AS BIGINT
+    START WITH 1
+    INCREMENT BY 1
+    NO CYCLE;
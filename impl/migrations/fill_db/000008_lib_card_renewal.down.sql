@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS bs.lib_card_expiry_idx;
+
+DROP TABLE IF EXISTS bs.lib_card_renewal;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS bs.lib_card_renewal
+(
+    id                UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    lib_card_id       UUID             NOT NULL,
+    previous_validity INT              NOT NULL,
+    new_validity      INT              NOT NULL,
+    previous_expiry   DATE             NOT NULL,
+    new_expiry        DATE             NOT NULL,
+    renewed_at        TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    FOREIGN KEY (lib_card_id) REFERENCES bs.lib_card (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CHECK (previous_validity < new_validity)
+);
+
+CREATE INDEX IF NOT EXISTS lib_card_renewal_card_idx ON bs.lib_card_renewal (lib_card_id, renewed_at);
+
+CREATE INDEX IF NOT EXISTS lib_card_expiry_idx ON bs.lib_card ((issue_date + validity));
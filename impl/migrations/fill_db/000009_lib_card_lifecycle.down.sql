@@ -0,0 +1,21 @@
+DROP VIEW IF EXISTS bs.lib_card_view;
+
+CREATE VIEW bs.lib_card_view AS
+SELECT lc.id,
+       lc.reader_id,
+       lc.lib_card_num,
+       lc.validity,
+       lc.issue_date,
+       lc.action_status
+FROM (SELECT bs.update_inactive_lib_cards()) AS u,
+     bs.lib_card lc;
+
+DROP INDEX IF EXISTS bs.lib_card_current_idx;
+
+ALTER TABLE bs.lib_card
+    DROP CONSTRAINT IF EXISTS lib_card_block_check,
+    DROP COLUMN IF EXISTS replaces_id,
+    DROP COLUMN IF EXISTS blocked_at,
+    DROP COLUMN IF EXISTS block_reason;
+
+DROP TYPE IF EXISTS LIB_CARD_BLOCK_REASON;
@@ -0,0 +1,23 @@
+CREATE TYPE LIB_CARD_BLOCK_REASON AS ENUM ('Lost', 'Stolen', 'Replaced');
+
+ALTER TABLE bs.lib_card
+    ADD COLUMN IF NOT EXISTS block_reason LIB_CARD_BLOCK_REASON,
+    ADD COLUMN IF NOT EXISTS blocked_at   TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS replaces_id  UUID REFERENCES bs.lib_card (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    ADD CONSTRAINT lib_card_block_check CHECK ((block_reason IS NULL) = (blocked_at IS NULL));
+
+-- у читателя может быть сколько угодно заблокированных билетов, но только один текущий
+CREATE UNIQUE INDEX IF NOT EXISTS lib_card_current_idx ON bs.lib_card (reader_id) WHERE blocked_at IS NULL;
+
+CREATE OR REPLACE VIEW bs.lib_card_view AS
+SELECT lc.id,
+       lc.reader_id,
+       lc.lib_card_num,
+       lc.validity,
+       lc.issue_date,
+       lc.action_status,
+       lc.block_reason,
+       lc.blocked_at,
+       lc.replaces_id
+FROM (SELECT bs.update_inactive_lib_cards()) AS u,
+     bs.lib_card lc;
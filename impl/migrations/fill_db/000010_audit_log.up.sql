@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS bs.audit_log
+(
+    id          UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    entity_type TEXT             NOT NULL,
+    entity_id   UUID             NOT NULL,
+    operation   TEXT             NOT NULL,
+    before_data JSONB,
+    after_data  JSONB,
+    actor_id    UUID,
+    request_id  TEXT,
+    created_at  TIMESTAMPTZ      NOT NULL DEFAULT clock_timestamp()
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_entity_idx ON bs.audit_log (entity_type, entity_id, created_at);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_idx ON bs.audit_log (actor_id, created_at) WHERE actor_id IS NOT NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS bs.book_not_deleted_title_idx;
+
+ALTER TABLE bs.book
+    DROP COLUMN IF EXISTS deleted_by,
+    DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+ALTER TABLE bs.book
+    ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS deleted_by UUID;
+
+CREATE INDEX IF NOT EXISTS book_not_deleted_title_idx ON bs.book (title) WHERE deleted_at IS NULL;
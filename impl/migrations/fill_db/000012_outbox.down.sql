@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION bs.update_expired_reservations()
+    RETURNS void AS
+$$
+BEGIN
+    UPDATE bs.reservation
+    SET state = 'Expired'
+    WHERE state != 'Closed'
+      AND return_date < CURRENT_DATE;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS bs.outbox;
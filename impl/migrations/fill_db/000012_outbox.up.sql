@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS bs.outbox
+(
+    id              UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    aggregate_type  TEXT             NOT NULL,
+    aggregate_id    UUID             NOT NULL,
+    event_type      TEXT             NOT NULL,
+    payload         JSONB            NOT NULL,
+    created_at      TIMESTAMPTZ      NOT NULL DEFAULT clock_timestamp(),
+    attempts        INT              NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ      NOT NULL DEFAULT clock_timestamp(),
+    last_error      TEXT,
+    sent_at         TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON bs.outbox (next_attempt_at, created_at) WHERE sent_at IS NULL;
+
+-- бронирования истекают внутри bs.reservation_view, минуя репозиторий,
+-- поэтому событие об истечении пишется прямо в функции
+CREATE OR REPLACE FUNCTION bs.update_expired_reservations()
+    RETURNS void AS
+$$
+BEGIN
+    WITH expired AS (
+        UPDATE bs.reservation
+            SET state = 'Expired'
+            WHERE state NOT IN ('Closed', 'Expired')
+                AND return_date < CURRENT_DATE
+            RETURNING id, reader_id, book_id, issue_date, return_date, state)
+    INSERT
+    INTO bs.outbox (aggregate_type, aggregate_id, event_type, payload)
+    SELECT 'reservation', e.id, 'reservation.expired', to_jsonb(e)
+    FROM expired e;
+END;
+$$ LANGUAGE plpgsql;
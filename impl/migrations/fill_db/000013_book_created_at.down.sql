@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS bs.book_not_deleted_created_at_idx;
+
+ALTER TABLE bs.book
+    DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,4 @@
+ALTER TABLE bs.book
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX IF NOT EXISTS book_not_deleted_created_at_idx ON bs.book (created_at DESC, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS bs.book_isbn_13_key;
+
+ALTER TABLE bs.book
+    DROP COLUMN IF EXISTS isbn_13,
+    DROP COLUMN IF EXISTS isbn_10;
@@ -0,0 +1,6 @@
+ALTER TABLE bs.book
+    ADD COLUMN IF NOT EXISTS isbn_10 TEXT CHECK (isbn_10 ~ '^[0-9]{9}[0-9X]$'),
+    ADD COLUMN IF NOT EXISTS isbn_13 TEXT CHECK (isbn_13 ~ '^97[89][0-9]{10}$');
+
+-- ISBN-10 однозначно выводится из ISBN-13, поэтому уникальности ISBN-13 достаточно
+CREATE UNIQUE INDEX IF NOT EXISTS book_isbn_13_key ON bs.book (isbn_13);
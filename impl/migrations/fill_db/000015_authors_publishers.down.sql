@@ -0,0 +1,12 @@
+DROP INDEX IF EXISTS bs.book_publisher_idx;
+
+ALTER TABLE bs.book
+    DROP COLUMN IF EXISTS publisher_id;
+
+DROP TABLE IF EXISTS bs.book_author;
+DROP TABLE IF EXISTS bs.publisher_alias;
+DROP TABLE IF EXISTS bs.publisher;
+DROP TABLE IF EXISTS bs.author_alias;
+DROP TABLE IF EXISTS bs.author;
+
+DROP TYPE IF EXISTS BOOK_AUTHOR_ROLE;
@@ -0,0 +1,76 @@
+CREATE TYPE BOOK_AUTHOR_ROLE AS ENUM ('Author', 'Translator', 'Editor');
+
+CREATE TABLE IF NOT EXISTS bs.author
+(
+    id         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    name       TEXT             NOT NULL,
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+-- текущее имя тоже хранится как псевдоним: поиск и проверка уникальности идут только по псевдонимам
+CREATE TABLE IF NOT EXISTS bs.author_alias
+(
+    author_id UUID NOT NULL,
+    alias     TEXT NOT NULL,
+    FOREIGN KEY (author_id) REFERENCES bs.author (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS author_alias_key ON bs.author_alias (lower(alias));
+CREATE INDEX IF NOT EXISTS author_alias_author_idx ON bs.author_alias (author_id);
+
+CREATE TABLE IF NOT EXISTS bs.publisher
+(
+    id         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    name       TEXT             NOT NULL,
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS bs.publisher_alias
+(
+    publisher_id UUID NOT NULL,
+    alias        TEXT NOT NULL,
+    FOREIGN KEY (publisher_id) REFERENCES bs.publisher (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS publisher_alias_key ON bs.publisher_alias (lower(alias));
+CREATE INDEX IF NOT EXISTS publisher_alias_publisher_idx ON bs.publisher_alias (publisher_id);
+
+CREATE TABLE IF NOT EXISTS bs.book_author
+(
+    book_id   UUID             NOT NULL,
+    author_id UUID             NOT NULL,
+    role      BOOK_AUTHOR_ROLE NOT NULL DEFAULT 'Author',
+    position  INT              NOT NULL DEFAULT 0,
+    PRIMARY KEY (book_id, author_id, role),
+    FOREIGN KEY (book_id) REFERENCES bs.book (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (author_id) REFERENCES bs.author (id) ON DELETE RESTRICT ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS book_author_author_idx ON bs.book_author (author_id, role);
+
+ALTER TABLE bs.book
+    ADD COLUMN IF NOT EXISTS publisher_id UUID REFERENCES bs.publisher (id) ON DELETE RESTRICT ON UPDATE CASCADE;
+
+CREATE INDEX IF NOT EXISTS book_publisher_idx ON bs.book (publisher_id) WHERE publisher_id IS NOT NULL;
+
+-- текстовые колонки остаются, а справочники заполняются из них; написания,
+-- отличающиеся только регистром, считаются одним автором (издательством)
+WITH names AS (SELECT min(author) AS name FROM bs.book WHERE author <> '' GROUP BY lower(author)),
+     inserted AS (INSERT INTO bs.author (name) SELECT name FROM names RETURNING id, name)
+INSERT INTO bs.author_alias (author_id, alias)
+SELECT id, name FROM inserted;
+
+INSERT INTO bs.book_author (book_id, author_id)
+SELECT b.id, a.author_id
+FROM bs.book b
+         JOIN bs.author_alias a ON lower(a.alias) = lower(b.author);
+
+WITH names AS (SELECT min(publisher) AS name FROM bs.book WHERE publisher <> '' GROUP BY lower(publisher)),
+     inserted AS (INSERT INTO bs.publisher (name) SELECT name FROM names RETURNING id, name)
+INSERT INTO bs.publisher_alias (publisher_id, alias)
+SELECT id, name FROM inserted;
+
+UPDATE bs.book b
+SET publisher_id = a.publisher_id
+FROM bs.publisher_alias a
+WHERE lower(a.alias) = lower(b.publisher);
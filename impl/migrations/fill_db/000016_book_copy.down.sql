@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS bs.reservation_open_copy_key;
+
+ALTER TABLE bs.reservation
+    DROP COLUMN IF EXISTS copy_id;
+
+DROP TABLE IF EXISTS bs.book_copy;
+
+DROP TYPE IF EXISTS BOOK_COPY_STATUS;
+DROP TYPE IF EXISTS BOOK_COPY_CONDITION;
@@ -0,0 +1,25 @@
+CREATE TYPE BOOK_COPY_CONDITION AS ENUM ('New', 'Good', 'Worn', 'Damaged');
+
+CREATE TYPE BOOK_COPY_STATUS AS ENUM ('Available', 'Issued', 'InRepair', 'Lost', 'WrittenOff');
+
+CREATE TABLE IF NOT EXISTS bs.book_copy
+(
+    id         UUID PRIMARY KEY    NOT NULL DEFAULT uuid_generate_v4(),
+    book_id    UUID                NOT NULL,
+    barcode    TEXT                NOT NULL,
+    condition  BOOK_COPY_CONDITION NOT NULL DEFAULT 'Good',
+    location   TEXT                NOT NULL DEFAULT '',
+    status     BOOK_COPY_STATUS    NOT NULL DEFAULT 'Available',
+    created_at TIMESTAMPTZ         NOT NULL DEFAULT now(),
+    FOREIGN KEY (book_id) REFERENCES bs.book (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_copy_barcode_key ON bs.book_copy (barcode);
+CREATE INDEX IF NOT EXISTS book_copy_book_idx ON bs.book_copy (book_id, status);
+
+ALTER TABLE bs.reservation
+    ADD COLUMN IF NOT EXISTS copy_id UUID REFERENCES bs.book_copy (id) ON DELETE SET NULL ON UPDATE CASCADE;
+
+-- просроченное бронирование тоже держит экземпляр: книга еще у читателя
+CREATE UNIQUE INDEX IF NOT EXISTS reservation_open_copy_key ON bs.reservation (copy_id)
+    WHERE copy_id IS NOT NULL AND state != 'Closed';
@@ -0,0 +1,13 @@
+ALTER TABLE bs.book_copy
+    DROP COLUMN IF EXISTS branch_id;
+
+ALTER TABLE bs.lib_card
+    DROP COLUMN IF EXISTS branch_id;
+
+DROP INDEX IF EXISTS bs.reservation_open_branch_idx;
+
+ALTER TABLE bs.reservation
+    DROP COLUMN IF EXISTS branch_id;
+
+DROP TABLE IF EXISTS bs.book_inventory;
+DROP TABLE IF EXISTS bs.branch;
@@ -0,0 +1,53 @@
+CREATE TABLE IF NOT EXISTS bs.branch
+(
+    id         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    code       TEXT             NOT NULL,
+    name       TEXT             NOT NULL,
+    address    TEXT             NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS branch_code_key ON bs.branch (code);
+
+CREATE TABLE IF NOT EXISTS bs.book_inventory
+(
+    book_id       UUID NOT NULL,
+    branch_id     UUID NOT NULL,
+    copies_number INT  NOT NULL CHECK (copies_number >= 0),
+    PRIMARY KEY (book_id, branch_id),
+    FOREIGN KEY (book_id) REFERENCES bs.book (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (branch_id) REFERENCES bs.branch (id) ON DELETE RESTRICT ON UPDATE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS book_inventory_branch_idx ON bs.book_inventory (branch_id, book_id);
+
+ALTER TABLE bs.reservation
+    ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES bs.branch (id) ON DELETE RESTRICT ON UPDATE CASCADE;
+
+CREATE INDEX IF NOT EXISTS reservation_open_branch_idx ON bs.reservation (branch_id, book_id) WHERE state != 'Closed';
+
+ALTER TABLE bs.lib_card
+    ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES bs.branch (id) ON DELETE RESTRICT ON UPDATE CASCADE;
+
+ALTER TABLE bs.book_copy
+    ADD COLUMN IF NOT EXISTS branch_id UUID REFERENCES bs.branch (id) ON DELETE RESTRICT ON UPDATE CASCADE;
+
+-- до появления филиалов библиотека была одна: она становится филиалом с кодом,
+-- который уже стоит в номерах читательских билетов, и получает весь фонд
+INSERT INTO bs.branch (code, name)
+VALUES ('01', 'Центральная библиотека');
+
+INSERT INTO bs.book_inventory (book_id, branch_id, copies_number)
+SELECT b.id, br.id, b.copies_number
+FROM bs.book b,
+     bs.branch br
+WHERE br.code = '01';
+
+UPDATE bs.reservation
+SET branch_id = (SELECT id FROM bs.branch WHERE code = '01');
+
+UPDATE bs.lib_card
+SET branch_id = (SELECT id FROM bs.branch WHERE code = '01');
+
+UPDATE bs.book_copy
+SET branch_id = (SELECT id FROM bs.branch WHERE code = '01');
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS bs.book_hold;
+DROP TABLE IF EXISTS bs.reservation_renewal;
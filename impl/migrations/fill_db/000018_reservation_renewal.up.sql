@@ -0,0 +1,34 @@
+CREATE TABLE IF NOT EXISTS bs.reservation_renewal
+(
+    id                   UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    reservation_id       UUID             NOT NULL,
+    previous_return_date DATE             NOT NULL,
+    new_return_date      DATE             NOT NULL,
+    renewed_at           TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    FOREIGN KEY (reservation_id) REFERENCES bs.reservation (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    CHECK (previous_return_date < new_return_date)
+);
+
+CREATE INDEX IF NOT EXISTS reservation_renewal_reservation_idx ON bs.reservation_renewal (reservation_id, renewed_at);
+
+-- очередь ожидания книги: пока в ней есть читатели, бронирования этой книги не продлеваются
+CREATE TABLE IF NOT EXISTS bs.book_hold
+(
+    id         UUID PRIMARY KEY NOT NULL DEFAULT uuid_generate_v4(),
+    book_id    UUID             NOT NULL,
+    reader_id  UUID             NOT NULL,
+    created_at TIMESTAMPTZ      NOT NULL DEFAULT now(),
+    FOREIGN KEY (book_id) REFERENCES bs.book (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    FOREIGN KEY (reader_id) REFERENCES bs.reader (id) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_hold_book_reader_key ON bs.book_hold (book_id, reader_id);
+
+CREATE INDEX IF NOT EXISTS book_hold_book_idx ON bs.book_hold (book_id, created_at);
+
+-- бронирования, продленные до появления истории, продлевались сервисом ровно один раз на 7 дней
+INSERT INTO bs.reservation_renewal (reservation_id, previous_return_date, new_return_date)
+SELECT id, return_date - 7, return_date
+FROM bs.reservation
+WHERE state = 'Extended'
+  AND return_date - 7 > issue_date;
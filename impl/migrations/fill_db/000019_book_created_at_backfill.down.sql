@@ -0,0 +1,2 @@
+-- прежнее общее время не восстанавливается: уточненное время создания остается
+SELECT 1;
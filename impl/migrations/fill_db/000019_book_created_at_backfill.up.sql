@@ -0,0 +1,12 @@
+-- 000013 проставил всем существующим книгам одно и то же время миграции. Книгам, созданным после
+-- появления журнала изменений (000010), возвращается время из записи о создании. У книг начального
+-- наполнения истории нет: они остаются с общим временем и в новинках упорядочены между собой по id
+UPDATE bs.book b
+SET created_at = a.created_at
+FROM (SELECT entity_id, min(created_at) AS created_at
+      FROM bs.audit_log
+      WHERE entity_type = 'book'
+        AND operation = 'create'
+      GROUP BY entity_id) a
+WHERE a.entity_id = b.id
+  AND a.created_at < b.created_at;
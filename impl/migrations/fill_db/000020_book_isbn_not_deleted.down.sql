@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS bs.book_isbn_13_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS book_isbn_13_key ON bs.book (isbn_13);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS bs.book_isbn_13_key;
+
+-- ISBN уникален только среди книг не из архива: архивная книга не мешает завести новую с тем же ISBN
+CREATE UNIQUE INDEX IF NOT EXISTS book_isbn_13_key ON bs.book (isbn_13) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE bs.book
+    DROP CONSTRAINT IF EXISTS book_copies_number_check,
+    ADD CONSTRAINT book_copies_number_check CHECK (copies_number > 0);
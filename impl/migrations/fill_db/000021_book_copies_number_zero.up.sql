@@ -0,0 +1,4 @@
+-- copies_number книги — сумма фонда по филиалам, и фонд может быть обнулен во всех филиалах
+ALTER TABLE bs.book
+    DROP CONSTRAINT IF EXISTS book_copies_number_check,
+    ADD CONSTRAINT book_copies_number_check CHECK (copies_number >= 0);
@@ -0,0 +1,318 @@
+package opds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	"github.com/nikitalystsev/BookSmart-repo-postgres/impl"
+	"github.com/nikitalystsev/BookSmart-services/core/dto"
+	"github.com/nikitalystsev/BookSmart-services/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	DefaultTitle    = "BookSmart"
+)
+
+// пути лент относительно Config.BaseURL
+const (
+	pathRoot        = "/opds"
+	pathNew         = "/opds/new"
+	pathGenres      = "/opds/genres"
+	pathAuthors     = "/opds/authors"
+	pathSearch      = "/opds/search"
+	pathOpenSearch  = "/opds/opensearch.xml"
+	pathBook        = "/books/"
+	pathReservation = "/reservations"
+)
+
+// BookSource — запросы к каталогу, из которых строятся ленты; их реализует impl.BookRepo
+type BookSource interface {
+	GetByParams(ctx context.Context, params *dto.BookParamsDTO) ([]*models.BookModel, error)
+	GetNewArrivals(ctx context.Context, limit uint, offset int) ([]*models.BookModel, error)
+	GetGenres(ctx context.Context, limit uint, offset int) ([]*repodto.BookFacetDTO, error)
+	GetAuthors(ctx context.Context, limit uint, offset int) ([]*repodto.BookFacetDTO, error)
+	GetByGenre(ctx context.Context, genre string, limit uint, offset int) ([]*models.BookModel, error)
+	GetByAuthorName(ctx context.Context, author string, limit uint, offset int) ([]*models.BookModel, error)
+}
+
+var _ BookSource = (*impl.BookRepo)(nil)
+
+type Config struct {
+	BaseURL  string // адрес сервиса без завершающего слеша, например https://booksmart.example
+	Title    string // название каталога в корневой ленте и описании поиска
+	PageSize uint   // число записей на странице ленты
+}
+
+// Builder строит ленты OPDS 1.2. Страницы задаются смещением offset, как в BookParamsDTO
+type Builder struct {
+	books  BookSource
+	config Config
+	now    func() time.Time
+}
+
+func NewBuilder(books BookSource, config Config) *Builder {
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+	if config.Title == "" {
+		config.Title = DefaultTitle
+	}
+	if config.PageSize == 0 {
+		config.PageSize = DefaultPageSize
+	}
+
+	return &Builder{books: books, config: config, now: time.Now}
+}
+
+// Root — корневая навигационная лента со ссылками на новинки, жанры, авторов и поиск
+func (b *Builder) Root() *Feed {
+	updated := b.updated()
+
+	feed := newFeed(b.url(pathRoot, nil), b.config.Title, updated)
+	feed.Links = append(feed.Links, b.commonLinks(pathRoot, nil, MediaTypeNavigation)...)
+
+	feed.Entries = append(feed.Entries,
+		b.navigationEntry(pathNew, "New arrivals", "Recently added books", MediaTypeAcquisition, RelNew, updated),
+		b.navigationEntry(pathGenres, "By genre", "Browse books by genre", MediaTypeNavigation, RelSubsection, updated),
+		b.navigationEntry(pathAuthors, "By author", "Browse books by author", MediaTypeNavigation, RelSubsection, updated),
+	)
+
+	return feed
+}
+
+// NewArrivals — лента приобретения с последними добавленными книгами
+func (b *Builder) NewArrivals(ctx context.Context, offset int) (*Feed, error) {
+	books, err := b.books.GetNewArrivals(ctx, b.config.PageSize+1, offset)
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	return b.acquisitionFeed(pathNew, nil, "New arrivals", books, offset), nil
+}
+
+// Genres — навигационная лента жанров; каждая запись ведет к книгам жанра
+func (b *Builder) Genres(ctx context.Context, offset int) (*Feed, error) {
+	facets, err := b.books.GetGenres(ctx, b.config.PageSize+1, offset)
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	return b.facetFeed(pathGenres, "By genre", facets, offset), nil
+}
+
+// Authors — навигационная лента авторов; каждая запись ведет к книгам автора
+func (b *Builder) Authors(ctx context.Context, offset int) (*Feed, error) {
+	facets, err := b.books.GetAuthors(ctx, b.config.PageSize+1, offset)
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	return b.facetFeed(pathAuthors, "By author", facets, offset), nil
+}
+
+// ByGenre — лента приобретения с книгами жанра из записи ленты Genres (точное совпадение)
+func (b *Builder) ByGenre(ctx context.Context, genre string, offset int) (*Feed, error) {
+	books, err := b.books.GetByGenre(ctx, genre, b.config.PageSize+1, offset)
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	feed := b.acquisitionFeed(b.facetPath(pathGenres, genre), nil, genre, books, offset)
+	feed.Links = append(feed.Links, Link{Rel: RelUp, Href: b.url(pathGenres, nil), Type: MediaTypeNavigation})
+
+	return feed, nil
+}
+
+// ByAuthor — лента приобретения с книгами автора из записи ленты Authors (точное совпадение)
+func (b *Builder) ByAuthor(ctx context.Context, author string, offset int) (*Feed, error) {
+	books, err := b.books.GetByAuthorName(ctx, author, b.config.PageSize+1, offset)
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	feed := b.acquisitionFeed(b.facetPath(pathAuthors, author), nil, author, books, offset)
+	feed.Links = append(feed.Links, Link{Rel: RelUp, Href: b.url(pathAuthors, nil), Type: MediaTypeNavigation})
+
+	return feed, nil
+}
+
+// Search — лента приобретения с книгами, в названии которых встречается query
+func (b *Builder) Search(ctx context.Context, query string, offset int) (*Feed, error) {
+	books, err := b.books.GetByParams(ctx, &dto.BookParamsDTO{Title: query, Limit: b.config.PageSize + 1, Offset: offset})
+	if err != nil && !errors.Is(err, errs.ErrBookDoesNotExists) {
+		return nil, err
+	}
+
+	feed := b.acquisitionFeed(pathSearch, url.Values{"q": {query}}, fmt.Sprintf("Search: %s", query), books, offset)
+	feed.Links = append(feed.Links, Link{Rel: RelUp, Href: b.url(pathRoot, nil), Type: MediaTypeNavigation})
+
+	return feed, nil
+}
+
+// OpenSearch — описание поиска, на которое ссылается каждая лента
+func (b *Builder) OpenSearch() *OpenSearchDescription {
+	return &OpenSearchDescription{
+		Xmlns:          openSearchNamespace,
+		ShortName:      b.config.Title,
+		Description:    fmt.Sprintf("Search %s catalog by title", b.config.Title),
+		InputEncoding:  "UTF-8",
+		OutputEncoding: "UTF-8",
+		URLs: []OpenSearchURL{{
+			Type:     MediaTypeAcquisition,
+			Template: b.url(pathSearch, nil) + "?q={searchTerms}",
+		}},
+	}
+}
+
+// acquisitionFeed собирает ленту из страницы книг. books запрошены с запасом в одну запись:
+// если она пришла, есть следующая страница
+func (b *Builder) acquisitionFeed(path string, query url.Values, title string, books []*models.BookModel, offset int) *Feed {
+	updated := b.updated()
+	hasNext := uint(len(books)) > b.config.PageSize
+	if hasNext {
+		books = books[:b.config.PageSize]
+	}
+
+	feed := newFeed(b.url(path, query), title, updated)
+	feed.Links = append(feed.Links, b.commonLinks(path, withOffset(query, offset), MediaTypeAcquisition)...)
+	feed.Links = append(feed.Links, b.pageLinks(path, query, MediaTypeAcquisition, offset, hasNext)...)
+	feed.ItemsPerPage = int(b.config.PageSize)
+	feed.StartIndex = offset + 1
+
+	for _, book := range books {
+		feed.Entries = append(feed.Entries, b.bookEntry(book, updated))
+	}
+
+	return feed
+}
+
+func (b *Builder) facetFeed(path, title string, facets []*repodto.BookFacetDTO, offset int) *Feed {
+	updated := b.updated()
+	hasNext := uint(len(facets)) > b.config.PageSize
+	if hasNext {
+		facets = facets[:b.config.PageSize]
+	}
+
+	feed := newFeed(b.url(path, nil), title, updated)
+	feed.Links = append(feed.Links, b.commonLinks(path, withOffset(nil, offset), MediaTypeNavigation)...)
+	feed.Links = append(feed.Links, Link{Rel: RelUp, Href: b.url(pathRoot, nil), Type: MediaTypeNavigation})
+	feed.Links = append(feed.Links, b.pageLinks(path, nil, MediaTypeNavigation, offset, hasNext)...)
+
+	for _, facet := range facets {
+		facetPath := b.facetPath(path, facet.Value)
+		feed.Entries = append(feed.Entries, &Entry{
+			ID:      b.url(facetPath, nil),
+			Title:   facet.Value,
+			Updated: updated,
+			Content: &Content{Type: "text", Value: fmt.Sprintf("%d books", facet.Count)},
+			Links: []Link{{
+				Rel:   RelSubsection,
+				Href:  b.url(facetPath, nil),
+				Type:  MediaTypeAcquisition,
+				Count: facet.Count,
+			}},
+		})
+	}
+
+	return feed
+}
+
+// bookEntry — запись книги. Файлов у библиотеки нет, поэтому приобретение — это бронирование
+func (b *Builder) bookEntry(book *models.BookModel, updated string) *Entry {
+	bookURL := b.url(pathBook+book.ID.String(), nil)
+
+	entry := &Entry{
+		ID:        "urn:uuid:" + book.ID.String(),
+		Title:     book.Title,
+		Updated:   updated,
+		Publisher: book.Publisher,
+		Language:  book.Language,
+		Links: []Link{
+			{Rel: RelAlternate, Href: bookURL, Type: mediaTypeHTML},
+			{Rel: RelAcquisition, Href: bookURL + pathReservation, Type: mediaTypeHTML},
+		},
+	}
+	if book.Author != "" {
+		entry.Authors = []Person{{Name: book.Author, URI: b.url(b.facetPath(pathAuthors, book.Author), nil)}}
+	}
+	if book.PublishingYear != 0 {
+		entry.Issued = strconv.FormatUint(uint64(book.PublishingYear), 10)
+	}
+	if book.Genre != "" {
+		entry.Categories = []Category{{Term: book.Genre, Label: book.Genre}}
+	}
+	if book.AgeLimit != 0 {
+		entry.Content = &Content{Type: "text", Value: fmt.Sprintf("%d+", book.AgeLimit)}
+	}
+
+	return entry
+}
+
+func (b *Builder) navigationEntry(path, title, summary, mediaType, rel, updated string) *Entry {
+	return &Entry{
+		ID:      b.url(path, nil),
+		Title:   title,
+		Updated: updated,
+		Content: &Content{Type: "text", Value: summary},
+		Links:   []Link{{Rel: rel, Href: b.url(path, nil), Type: mediaType}},
+	}
+}
+
+func (b *Builder) commonLinks(path string, query url.Values, mediaType string) []Link {
+	return []Link{
+		{Rel: RelStart, Href: b.url(pathRoot, nil), Type: MediaTypeNavigation},
+		{Rel: RelSearch, Href: b.url(pathOpenSearch, nil), Type: MediaTypeOpenSearch},
+		{Rel: RelSelf, Href: b.url(path, query), Type: mediaType},
+	}
+}
+
+func (b *Builder) pageLinks(path string, query url.Values, mediaType string, offset int, hasNext bool) []Link {
+	var links []Link
+
+	if offset > 0 {
+		prev := offset - int(b.config.PageSize)
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, Link{Rel: RelPrevious, Href: b.url(path, withOffset(query, prev)), Type: mediaType})
+	}
+	if hasNext {
+		next := offset + int(b.config.PageSize)
+		links = append(links, Link{Rel: RelNext, Href: b.url(path, withOffset(query, next)), Type: mediaType})
+	}
+
+	return links
+}
+
+func (b *Builder) facetPath(path, value string) string {
+	return path + "/" + url.PathEscape(value)
+}
+
+func (b *Builder) url(path string, query url.Values) string {
+	if len(query) == 0 {
+		return b.config.BaseURL + path
+	}
+
+	return b.config.BaseURL + path + "?" + query.Encode()
+}
+
+func (b *Builder) updated() string {
+	return b.now().UTC().Format(time.RFC3339)
+}
+
+func withOffset(query url.Values, offset int) url.Values {
+	values := url.Values{}
+	for key, value := range query {
+		values[key] = value
+	}
+	if offset > 0 {
+		values.Set("offset", strconv.Itoa(offset))
+	}
+
+	return values
+}
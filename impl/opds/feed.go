@@ -0,0 +1,136 @@
+package opds
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+const (
+	atomNamespace       = "http://www.w3.org/2005/Atom"
+	dcTermsNamespace    = "http://purl.org/dc/terms/"
+	openSearchNamespace = "http://a9.com/-/spec/opensearch/1.1/"
+	opdsNamespace       = "http://opds-spec.org/2010/catalog"
+	threadNamespace     = "http://purl.org/syndication/thread/1.0"
+)
+
+const (
+	MediaTypeNavigation  = "application/atom+xml;profile=opds-catalog;kind=navigation"
+	MediaTypeAcquisition = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	MediaTypeOpenSearch  = "application/opensearchdescription+xml"
+	mediaTypeHTML        = "text/html"
+)
+
+const (
+	RelSelf        = "self"
+	RelStart       = "start"
+	RelUp          = "up"
+	RelNext        = "next"
+	RelPrevious    = "previous"
+	RelSearch      = "search"
+	RelSubsection  = "subsection"
+	RelAlternate   = "alternate"
+	RelNew         = "http://opds-spec.org/sort/new"
+	RelAcquisition = "http://opds-spec.org/acquisition/borrow"
+)
+
+// Feed — лента Atom: навигационная (записи ссылаются на другие ленты) или
+// ленты приобретения (записи — книги)
+type Feed struct {
+	XMLName         xml.Name `xml:"feed"`
+	Xmlns           string   `xml:"xmlns,attr"`
+	XmlnsDC         string   `xml:"xmlns:dc,attr"`
+	XmlnsOpenSearch string   `xml:"xmlns:opensearch,attr"`
+	XmlnsOPDS       string   `xml:"xmlns:opds,attr"`
+	XmlnsThread     string   `xml:"xmlns:thr,attr"`
+
+	ID           string   `xml:"id"`
+	Title        string   `xml:"title"`
+	Updated      string   `xml:"updated"`
+	Author       *Person  `xml:"author,omitempty"`
+	ItemsPerPage int      `xml:"opensearch:itemsPerPage,omitempty"`
+	StartIndex   int      `xml:"opensearch:startIndex,omitempty"`
+	Links        []Link   `xml:"link"`
+	Entries      []*Entry `xml:"entry"`
+}
+
+type Entry struct {
+	ID         string     `xml:"id"`
+	Title      string     `xml:"title"`
+	Updated    string     `xml:"updated"`
+	Authors    []Person   `xml:"author,omitempty"`
+	Publisher  string     `xml:"dc:publisher,omitempty"`
+	Issued     string     `xml:"dc:issued,omitempty"`
+	Language   string     `xml:"dc:language,omitempty"`
+	Categories []Category `xml:"category,omitempty"`
+	Content    *Content   `xml:"content,omitempty"`
+	Links      []Link     `xml:"link"`
+}
+
+type Person struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type Category struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr,omitempty"`
+}
+
+type Content struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+type Link struct {
+	Rel   string `xml:"rel,attr,omitempty"`
+	Href  string `xml:"href,attr"`
+	Type  string `xml:"type,attr,omitempty"`
+	Title string `xml:"title,attr,omitempty"`
+	Count int    `xml:"thr:count,attr,omitempty"`
+}
+
+// OpenSearchDescription описывает поиск по каталогу для клиентов OPDS
+type OpenSearchDescription struct {
+	XMLName        xml.Name        `xml:"OpenSearchDescription"`
+	Xmlns          string          `xml:"xmlns,attr"`
+	ShortName      string          `xml:"ShortName"`
+	Description    string          `xml:"Description"`
+	InputEncoding  string          `xml:"InputEncoding"`
+	OutputEncoding string          `xml:"OutputEncoding"`
+	URLs           []OpenSearchURL `xml:"Url"`
+}
+
+type OpenSearchURL struct {
+	Type     string `xml:"type,attr"`
+	Template string `xml:"template,attr"`
+}
+
+func newFeed(id, title, updated string) *Feed {
+	return &Feed{
+		Xmlns:           atomNamespace,
+		XmlnsDC:         dcTermsNamespace,
+		XmlnsOpenSearch: openSearchNamespace,
+		XmlnsOPDS:       opdsNamespace,
+		XmlnsThread:     threadNamespace,
+		ID:              id,
+		Title:           title,
+		Updated:         updated,
+		Links:           make([]Link, 0),
+		Entries:         make([]*Entry, 0),
+	}
+}
+
+// Encode пишет документ (Feed или OpenSearchDescription) с XML-заголовком
+func Encode(w io.Writer, document any) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	return encoder.Flush()
+}
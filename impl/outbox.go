@@ -0,0 +1,83 @@
+package impl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"sort"
+	"strings"
+)
+
+const (
+	EventBookCreated  = "book.created"
+	EventBookUpdated  = "book.updated"
+	EventBookDeleted  = "book.deleted"
+	EventBookRestored = "book.restored"
+
+	EventReaderCreated     = "reader.created"
+	EventReaderUpdated     = "reader.updated"
+	EventReaderDeactivated = "reader.deactivated"
+	EventReaderAnonymized  = "reader.anonymized"
+
+	EventBookCopyCreated = "book_copy.created"
+	EventBookCopyUpdated = "book_copy.updated"
+	EventBookCopyDeleted = "book_copy.deleted"
+
+	EventRatingCreated = "rating.created"
+
+	EventReservationCreated = "reservation.created"
+	EventReservationUpdated = "reservation.updated"
+	EventReservationExpired = "reservation.expired"
+)
+
+// outboxWriter пишет доменные события в bs.outbox в транзакции изменения,
+// откуда их забирает OutboxRelay
+type outboxWriter struct {
+	db     *sqlx.DB
+	getter *trmsqlx.CtxGetter
+}
+
+func newOutboxWriter(db *sqlx.DB, getter *trmsqlx.CtxGetter) *outboxWriter {
+	return &outboxWriter{db: db, getter: getter}
+}
+
+// write сохраняет событие. В payload попадают поля модели по тегам db, кроме скрытых (пароль)
+func (ow *outboxWriter) write(ctx context.Context, aggregateType string, aggregateID uuid.UUID, eventType string, state any) error {
+	fields := auditFields(state)
+	if fields == nil {
+		fields = map[string]any{"id": aggregateID}
+	}
+
+	payload, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+
+	query := `insert into bs.outbox (aggregate_type, aggregate_id, event_type, payload) values ($1, $2, $3, $4)`
+
+	_, err = ow.getter.DefaultTrOrDB(ctx, ow.db).ExecContext(ctx, query, aggregateType, aggregateID, eventType, string(payload))
+
+	return err
+}
+
+// outboxPayloadSQL строит для массовых путей выражение jsonb_build_object по колонкам модели
+// из строки alias — тем же полям, что write берет из модели через auditFields
+func outboxPayloadSQL(model any, alias string) string {
+	fields := auditFields(model)
+
+	columns := make([]string, 0, len(fields))
+	for column := range fields {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+
+	pairs := make([]string, len(columns))
+	for i, column := range columns {
+		pairs[i] = fmt.Sprintf("'%s', %s.%s", column, alias, column)
+	}
+
+	return "jsonb_build_object(" + strings.Join(pairs, ", ") + ")"
+}
@@ -0,0 +1,185 @@
+package impl
+
+import (
+	"context"
+	"errors"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"time"
+)
+
+const (
+	DefaultOutboxStreamPrefix = "booksmart:events:"
+	DefaultOutboxBatchSize    = 100
+	DefaultOutboxPollInterval = time.Second
+	DefaultOutboxMaxAttempts  = 10
+	DefaultOutboxRetryBackoff = time.Second
+	maxOutboxRetryBackoff     = 10 * time.Minute
+)
+
+type OutboxRelayConfig struct {
+	StreamPrefix string        // события пишутся в поток <StreamPrefix><aggregate_type>
+	StreamMaxLen int64         // приблизительная максимальная длина потока, 0 — без ограничения
+	BatchSize    int           // сколько событий забирается за одну итерацию
+	PollInterval time.Duration // пауза между итерациями, если очередь пуста
+	MaxAttempts  int           // после стольких неудач событие больше не отправляется
+	RetryBackoff time.Duration // начальная задержка повторной отправки, удваивается с каждой попыткой
+}
+
+// OutboxRelay публикует события из bs.outbox в Redis Streams с гарантией
+// «хотя бы один раз»: событие помечается отправленным только после успешного XADD,
+// поэтому при сбое между ними оно будет отправлено повторно. Потребители должны
+// быть идемпотентны и могут отбрасывать дубликаты по полю event_id.
+// Несколько экземпляров могут работать параллельно благодаря skip locked
+type OutboxRelay struct {
+	instrumentation
+
+	db        *sqlx.DB
+	client    *redis.Client
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	config    OutboxRelayConfig
+}
+
+func NewOutboxRelay(db *sqlx.DB, client *redis.Client, config OutboxRelayConfig, logger Logger) *OutboxRelay {
+	if config.StreamPrefix == "" {
+		config.StreamPrefix = DefaultOutboxStreamPrefix
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultOutboxBatchSize
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultOutboxPollInterval
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultOutboxMaxAttempts
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = DefaultOutboxRetryBackoff
+	}
+
+	return &OutboxRelay{
+		instrumentation: instrumentation{repo: "outbox_relay", dbSystem: dbSystemPostgres, table: "bs.outbox", logger: logger},
+		db:              db,
+		client:          client,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		config:          config,
+	}
+}
+
+// Run публикует события, пока не будет отменен контекст
+func (obr *OutboxRelay) Run(ctx context.Context) error {
+	obr.logger.Infof("starting outbox relay")
+
+	for {
+		published, err := obr.PublishPending(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			obr.logger.Errorf("error publishing outbox events: %v", err)
+		}
+
+		// если пачка заполнена целиком, в очереди, скорее всего, есть еще события
+		if err == nil && published == obr.config.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			obr.logger.Infof("outbox relay stopped")
+			return ctx.Err()
+		case <-time.After(obr.config.PollInterval):
+		}
+	}
+}
+
+// PublishPending отправляет одну пачку готовых к отправке событий и возвращает число отправленных
+func (obr *OutboxRelay) PublishPending(ctx context.Context) (_ int, err error) {
+	ctx, end := obr.start(ctx, "PublishPending")
+	defer end(&err)
+
+	published := 0
+
+	err = obr.trManager.Do(ctx, func(ctx context.Context) error {
+		tr := obr.getter.DefaultTrOrDB(ctx, obr.db)
+
+		query := `select id, aggregate_type, aggregate_id, event_type, payload, created_at, attempts 
+				  from bs.outbox 
+				  where sent_at is null and attempts < $1 and next_attempt_at <= now() 
+				  order by created_at 
+				  limit $2 
+				  for update skip locked`
+
+		var events []*repomodels.OutboxEventModel
+		if err := tr.SelectContext(ctx, &events, query, obr.config.MaxAttempts, obr.config.BatchSize); err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			if err := obr.publish(ctx, event); err != nil {
+				obr.logger.Warnf("error publishing outbox event %s: %v", event.ID, err)
+
+				if err = obr.markFailed(ctx, event, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			query = `update bs.outbox set sent_at = now(), attempts = attempts + 1, last_error = null where id = $1`
+			if _, err := tr.ExecContext(ctx, query, event.ID); err != nil {
+				return err
+			}
+			published++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if published > 0 {
+		obr.logger.Debugf("published %d outbox events", published)
+	}
+
+	return published, nil
+}
+
+func (obr *OutboxRelay) publish(ctx context.Context, event *repomodels.OutboxEventModel) error {
+	args := &redis.XAddArgs{
+		Stream: obr.config.StreamPrefix + event.AggregateType,
+		Values: map[string]any{
+			"event_id":       event.ID.String(),
+			"event_type":     event.EventType,
+			"aggregate_type": event.AggregateType,
+			"aggregate_id":   event.AggregateID.String(),
+			"payload":        event.Payload,
+			"created_at":     event.CreatedAt.Format(time.RFC3339Nano),
+		},
+	}
+	if obr.config.StreamMaxLen > 0 {
+		args.MaxLen = obr.config.StreamMaxLen
+		args.Approx = true
+	}
+
+	return obr.client.XAdd(ctx, args).Err()
+}
+
+func (obr *OutboxRelay) markFailed(ctx context.Context, event *repomodels.OutboxEventModel, publishErr error) error {
+	backoff := obr.config.RetryBackoff << event.Attempts
+	if backoff <= 0 || backoff > maxOutboxRetryBackoff {
+		backoff = maxOutboxRetryBackoff
+	}
+
+	query := `update bs.outbox 
+			  set attempts = attempts + 1, 
+			      last_error = $1, 
+			      next_attempt_at = now() + $2 * interval '1 millisecond' 
+			  where id = $3`
+
+	_, err := obr.getter.DefaultTrOrDB(ctx, obr.db).ExecContext(ctx, query, publishErr.Error(), backoff.Milliseconds(), event.ID)
+
+	return err
+}
@@ -0,0 +1,60 @@
+package impl
+
+import (
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+)
+
+const (
+	pgUniqueViolation       = "23505"
+	pgForeignKeyViolation   = "23503"
+	pgSerializationFailure  = "40001"
+	pgDeadlockDetected      = "40P01"
+	pgAdminShutdown         = "57P01"
+	pgConnectionErrorsClass = "08"
+)
+
+// sqlStateError реализуется ошибками как lib/pq, так и pgx, что позволяет
+// не привязываться к конкретному драйверу
+type sqlStateError interface {
+	SQLState() string
+}
+
+func pgErrorCode(err error) string {
+	var stateErr sqlStateError
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState()
+	}
+
+	return ""
+}
+
+func isUniqueViolation(err error) bool {
+	return pgErrorCode(err) == pgUniqueViolation
+}
+
+func isForeignKeyViolation(err error) bool {
+	return pgErrorCode(err) == pgForeignKeyViolation
+}
+
+// isSerializationError — конфликт конкурентных транзакций, который лечится повтором всей транзакции
+func isSerializationError(err error) bool {
+	code := pgErrorCode(err)
+
+	return code == pgSerializationFailure || code == pgDeadlockDetected
+}
+
+// isConnectionError — соединение с базой потеряно до или во время выполнения запроса
+func isConnectionError(err error) bool {
+	code := pgErrorCode(err)
+	if strings.HasPrefix(code, pgConnectionErrorsClass) || code == pgAdminShutdown {
+		return true
+	}
+
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
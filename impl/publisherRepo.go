@@ -0,0 +1,292 @@
+package impl
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+)
+
+// PublisherRepo — справочник издательств. Псевдонимы сводят разные написания («АСТ», «Издательство АСТ»)
+// к одной записи
+type PublisherRepo struct {
+	instrumentation
+
+	directory *directory
+	bookCache *CachedBookRepo
+}
+
+func NewPublisherRepo(db *sqlx.DB, logger Logger) *PublisherRepo {
+	pr := &PublisherRepo{
+		instrumentation: instrumentation{repo: "publisher", dbSystem: dbSystemPostgres, table: "bs.publisher", logger: logger},
+		directory: newDirectory(db, "publisher", AuditEntityPublisher, directoryErrors{
+			notFound:      repoerrs.ErrPublisherDoesNotExists,
+			alreadyExists: repoerrs.ErrPublisherAlreadyExists,
+			hasBooks:      repoerrs.ErrPublisherHasBooks,
+			aliasNotFound: repoerrs.ErrPublisherAliasDoesNotExists,
+			aliasIsName:   repoerrs.ErrPublisherAliasIsName,
+		}),
+	}
+	pr.directory.onRename = pr.renameBooks
+
+	return pr
+}
+
+// SetBookCache подключает кеш книг, который сбрасывается для книг, переименованных вместе с издательством
+func (pr *PublisherRepo) SetBookCache(cache *CachedBookRepo) {
+	pr.bookCache = cache
+}
+
+func (pr *PublisherRepo) Create(ctx context.Context, publisher *repomodels.PublisherModel) (err error) {
+	ctx, end := pr.start(ctx, "Create", entityIDAttr(publisher.ID))
+	defer end(&err)
+
+	pr.logger.Debugf("inserting publisher with ID: %s", publisher.ID)
+
+	err = pr.directory.create(ctx, publisher.ID, publisher.Name)
+	if err != nil && errors.Is(err, repoerrs.ErrPublisherAlreadyExists) {
+		pr.logger.Debugf("publisher with this name already exists: %s", publisher.Name)
+		return err
+	}
+	if err != nil {
+		pr.logger.Debugf("error inserting publisher: %v", err)
+		return err
+	}
+
+	pr.logger.Debugf("inserted publisher with ID: %s", publisher.ID)
+
+	return nil
+}
+
+func (pr *PublisherRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *repomodels.PublisherModel, err error) {
+	ctx, end := pr.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	pr.logger.Debugf("selecting publisher with ID: %s", ID)
+
+	entry, err := pr.directory.getByID(ctx, ID)
+	if err != nil && errors.Is(err, repoerrs.ErrPublisherDoesNotExists) {
+		pr.logger.Debugf("publisher with this ID not found: %s", ID)
+		return nil, err
+	}
+	if err != nil {
+		pr.logger.Debugf("error selecting publisher with ID: %v", err)
+		return nil, err
+	}
+
+	pr.logger.Debugf("selected publisher with ID: %s", ID)
+
+	return (*repomodels.PublisherModel)(entry), nil
+}
+
+// GetByName ищет издательство по текущему имени или псевдониму без учета регистра
+func (pr *PublisherRepo) GetByName(ctx context.Context, name string) (_ *repomodels.PublisherModel, err error) {
+	ctx, end := pr.start(ctx, "GetByName")
+	defer end(&err)
+
+	pr.logger.Debugf("selecting publisher by name: %s", name)
+
+	entry, err := pr.directory.getByName(ctx, name)
+	if err != nil && errors.Is(err, repoerrs.ErrPublisherDoesNotExists) {
+		pr.logger.Debugf("publisher with this name not found: %s", name)
+		return nil, err
+	}
+	if err != nil {
+		pr.logger.Debugf("error selecting publisher by name: %v", err)
+		return nil, err
+	}
+
+	pr.logger.Debugf("selected publisher with name: %s", name)
+
+	return (*repomodels.PublisherModel)(entry), nil
+}
+
+// Search ищет по подстроке в имени и псевдонимах
+func (pr *PublisherRepo) Search(ctx context.Context, params *repodto.DirectoryParamsDTO) (_ []*repomodels.PublisherModel, err error) {
+	ctx, end := pr.start(ctx, "Search")
+	defer end(&err)
+
+	pr.logger.Debugf("selecting publishers with params")
+
+	entries, err := pr.directory.search(ctx, params)
+	if err != nil && errors.Is(err, repoerrs.ErrPublisherDoesNotExists) {
+		pr.logger.Debugf("publishers not found with this params")
+		return nil, err
+	}
+	if err != nil {
+		pr.logger.Debugf("error selecting publishers with params: %v", err)
+		return nil, err
+	}
+
+	pr.logger.Debugf("found %d publishers", len(entries))
+
+	publishers := make([]*repomodels.PublisherModel, len(entries))
+	for i, entry := range entries {
+		publishers[i] = (*repomodels.PublisherModel)(entry)
+	}
+
+	return publishers, nil
+}
+
+// Update меняет имя; прежнее имя остается псевдонимом
+func (pr *PublisherRepo) Update(ctx context.Context, publisher *repomodels.PublisherModel) (err error) {
+	ctx, end := pr.start(ctx, "Update", entityIDAttr(publisher.ID))
+	defer end(&err)
+
+	pr.logger.Debugf("updating publisher with ID: %s", publisher.ID)
+
+	err = pr.directory.update(ctx, publisher.ID, publisher.Name)
+	if err != nil && (errors.Is(err, repoerrs.ErrPublisherDoesNotExists) || errors.Is(err, repoerrs.ErrPublisherAlreadyExists)) {
+		pr.logger.Debugf("publisher with ID %s can't be updated: %v", publisher.ID, err)
+		return err
+	}
+	if err != nil {
+		pr.logger.Debugf("error updating publisher: %v", err)
+		return err
+	}
+
+	pr.logger.Debugf("updated publisher with ID: %s", publisher.ID)
+
+	return nil
+}
+
+// Delete удаляет издательство вместе с псевдонимами. Издательство, у которого есть книги, удалить нельзя
+func (pr *PublisherRepo) Delete(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := pr.start(ctx, "Delete", entityIDAttr(ID))
+	defer end(&err)
+
+	pr.logger.Debugf("deleting publisher with ID: %s", ID)
+
+	err = pr.directory.delete(ctx, ID)
+	if err != nil && (errors.Is(err, repoerrs.ErrPublisherDoesNotExists) || errors.Is(err, repoerrs.ErrPublisherHasBooks)) {
+		pr.logger.Debugf("publisher with ID %s can't be deleted: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		pr.logger.Debugf("error deleting publisher: %v", err)
+		return err
+	}
+
+	pr.logger.Debugf("deleted publisher with ID: %s", ID)
+
+	return nil
+}
+
+func (pr *PublisherRepo) AddAlias(ctx context.Context, ID uuid.UUID, alias string) (err error) {
+	ctx, end := pr.start(ctx, "AddAlias", entityIDAttr(ID))
+	defer end(&err)
+
+	pr.logger.Debugf("adding alias to publisher with ID: %s", ID)
+
+	err = pr.directory.addAlias(ctx, ID, alias)
+	if err != nil && (errors.Is(err, repoerrs.ErrPublisherDoesNotExists) || errors.Is(err, repoerrs.ErrPublisherAlreadyExists)) {
+		pr.logger.Debugf("alias %s can't be added to publisher with ID %s: %v", alias, ID, err)
+		return err
+	}
+	if err != nil {
+		pr.logger.Debugf("error adding publisher alias: %v", err)
+		return err
+	}
+
+	pr.logger.Debugf("added alias to publisher with ID: %s", ID)
+
+	return nil
+}
+
+func (pr *PublisherRepo) DeleteAlias(ctx context.Context, ID uuid.UUID, alias string) (err error) {
+	ctx, end := pr.start(ctx, "DeleteAlias", entityIDAttr(ID))
+	defer end(&err)
+
+	pr.logger.Debugf("deleting alias of publisher with ID: %s", ID)
+
+	err = pr.directory.deleteAlias(ctx, ID, alias)
+	if err != nil && (errors.Is(err, repoerrs.ErrPublisherDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrPublisherAliasDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrPublisherAliasIsName)) {
+		pr.logger.Debugf("alias %s of publisher with ID %s can't be deleted: %v", alias, ID, err)
+		return err
+	}
+	if err != nil {
+		pr.logger.Debugf("error deleting publisher alias: %v", err)
+		return err
+	}
+
+	pr.logger.Debugf("deleted alias of publisher with ID: %s", ID)
+
+	return nil
+}
+
+// GetAliases возвращает все написания, включая текущее имя
+func (pr *PublisherRepo) GetAliases(ctx context.Context, ID uuid.UUID) (_ []string, err error) {
+	ctx, end := pr.start(ctx, "GetAliases", entityIDAttr(ID))
+	defer end(&err)
+
+	pr.logger.Debugf("selecting aliases of publisher with ID: %s", ID)
+
+	aliases, err := pr.directory.getAliases(ctx, ID)
+	if err != nil && errors.Is(err, repoerrs.ErrPublisherDoesNotExists) {
+		pr.logger.Debugf("publisher with this ID not found: %s", ID)
+		return nil, err
+	}
+	if err != nil {
+		pr.logger.Debugf("error selecting publisher aliases: %v", err)
+		return nil, err
+	}
+
+	pr.logger.Debugf("found %d aliases of publisher with ID: %s", len(aliases), ID)
+
+	return aliases, nil
+}
+
+// renameBooks переписывает название в привязанных книгах: bs.book.publisher — копия имени издательства.
+// Изменение книг попадает в журнал, outbox и кеш книг так же, как при BookRepo.Update
+func (pr *PublisherRepo) renameBooks(ctx context.Context, ID uuid.UUID, name string) error {
+	query := `with matched as (
+			      select id, publisher from bs.book where publisher_id = $1 and publisher <> $2),
+			  updated as (
+			      update bs.book b
+			      set publisher = $2
+			      from matched m
+			      where b.id = m.id
+			      returning b.*),
+			  audited as (
+			      insert into bs.audit_log
+			          (entity_type, entity_id, operation, before_data, after_data, actor_id, request_id)
+			      select $3, u.id, $4, jsonb_build_object('publisher', m.publisher), jsonb_build_object('publisher', u.publisher), $5, $6
+			      from updated u join matched m on m.id = u.id),
+			  published as (
+			      insert into bs.outbox (aggregate_type, aggregate_id, event_type, payload)
+			      select $3, u.id, $7, ` + outboxPayloadSQL(bookSnapshot{}, "u") + ` from updated u)
+			  select u.id, u.title from updated u`
+
+	actorID, requestID := auditContext(ctx)
+
+	var books []struct {
+		ID    uuid.UUID `db:"id"`
+		Title string    `db:"title"`
+	}
+	err := pr.directory.getter.DefaultTrOrDB(ctx, pr.directory.db).SelectContext(ctx, &books, query,
+		ID,
+		name,
+		AuditEntityBook,
+		AuditOperationUpdate,
+		actorID,
+		requestID,
+		EventBookUpdated,
+	)
+	if err != nil {
+		return err
+	}
+
+	// сброс откладывается до коммита переименования
+	if pr.bookCache != nil {
+		for _, book := range books {
+			pr.bookCache.Invalidate(ctx, book.ID, book.Title)
+		}
+	}
+
+	return nil
+}
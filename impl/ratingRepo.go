@@ -4,89 +4,131 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
 	"github.com/nikitalystsev/BookSmart-services/core/models"
 	"github.com/nikitalystsev/BookSmart-services/errs"
 	"github.com/nikitalystsev/BookSmart-services/intfRepo"
-	"github.com/sirupsen/logrus"
 )
 
 type RatingRepo struct {
-	db     *sqlx.DB
-	logger *logrus.Entry
+	instrumentation
+
+	db        *sqlx.DB
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+	outbox    *outboxWriter
 }
 
-func NewRatingRepo(db *sqlx.DB, logger *logrus.Entry) intfRepo.IRatingRepo {
-	return &RatingRepo{db: db, logger: logger}
+var _ intfRepo.IRatingRepo = (*RatingRepo)(nil)
+
+// NewRatingRepo возвращает репозиторий как интерфейс сервисного слоя
+func NewRatingRepo(db *sqlx.DB, logger Logger) intfRepo.IRatingRepo {
+	return NewRatingRepoImpl(db, logger)
+}
+
+// NewRatingRepoImpl возвращает RatingRepo с методами, которых нет в intfRepo.IRatingRepo
+func NewRatingRepoImpl(db *sqlx.DB, logger Logger) *RatingRepo {
+	return &RatingRepo{
+		instrumentation: instrumentation{repo: "rating", dbSystem: dbSystemPostgres, table: "bs.rating", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		outbox:          newOutboxWriter(db, trmsqlx.DefaultCtxGetter),
+	}
 }
 
-func (rr *RatingRepo) Create(ctx context.Context, rating *models.RatingModel) error {
-	rr.logger.Infof("inserting rating with ID %s", rating.ID.String())
+func (rr *RatingRepo) Create(ctx context.Context, rating *models.RatingModel) (err error) {
+	ctx, end := rr.start(ctx, "Create", entityIDAttr(rating.ID))
+	defer end(&err)
+
+	rr.logger.Debugf("inserting rating with ID %s", rating.ID.String())
 
 	query := `insert into bs.rating values ($1, $2, $3, $4, $5)`
 
-	result, err := rr.db.ExecContext(ctx, query,
-		rating.ID,
-		rating.ReaderID,
-		rating.BookID,
-		rating.Review,
-		rating.Rating,
-	)
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query,
+			rating.ID,
+			rating.ReaderID,
+			rating.BookID,
+			rating.Review,
+			rating.Rating,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("ratingRepo.Create: expected 1 row affected, got %d", rows)
+		}
+
+		after := rr.convertToRepoRatingModel(rating)
+		if err = rr.audit.write(ctx, AuditEntityRating, rating.ID, AuditOperationCreate, nil, after); err != nil {
+			return err
+		}
+
+		return rr.outbox.write(ctx, AuditEntityRating, rating.ID, EventRatingCreated, after)
+	})
 	if err != nil {
-		rr.logger.Errorf("error inserting rating: %v", err)
+		rr.logger.Debugf("error inserting rating: %v", err)
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		rr.logger.Errorf("error inserting rating: %v", err)
-		return err
-	}
-	if rows != 1 {
-		rr.logger.Errorf("error inserting rating: expected 1 row affected, got %d", rows)
-		return errors.New("ratingRepo.Create: expected 1 row affected")
-	}
 
 	return nil
 }
 
-func (rr *RatingRepo) GetByReaderAndBook(ctx context.Context, readerID, bookID uuid.UUID) (*models.RatingModel, error) {
-	rr.logger.Infof("selecting rating with readerID and bookID: %s, %s", readerID.String(), bookID.String())
+func (rr *RatingRepo) GetByReaderAndBook(ctx context.Context, readerID, bookID uuid.UUID) (_ *models.RatingModel, err error) {
+	ctx, end := rr.start(ctx, "GetByReaderAndBook", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting rating with readerID and bookID: %s, %s", readerID.String(), bookID.String())
 
 	query := `select id, reader_id, book_id, review, rating from bs.rating where reader_id = $1 and book_id = $2`
 
 	var rating repomodels.RatingModel
-	err := rr.db.GetContext(ctx, &rating, query, readerID, bookID)
+	err = rr.db.GetContext(ctx, &rating, query, readerID, bookID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting rating: %v", err)
+		rr.logger.Debugf("error selecting rating: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Warn("rating not found")
+		rr.logger.Debugf("rating not found")
 		return nil, errs.ErrRatingDoesNotExists
 	}
 
-	rr.logger.Infof("selected rating with readerID and bookID: %s, %s", readerID.String(), bookID.String())
+	rr.logger.Debugf("selected rating with readerID and bookID: %s, %s", readerID.String(), bookID.String())
 
 	return rr.convertToRatingModel(&rating), nil
 }
 
 // GetByBookID TODO logs
-func (rr *RatingRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) ([]*models.RatingModel, error) {
-	rr.logger.Infof("selecting ratings with bookID: %s", bookID.String())
+func (rr *RatingRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) (_ []*models.RatingModel, err error) {
+	ctx, end := rr.start(ctx, "GetByBookID", entityIDAttr(bookID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting ratings with bookID: %s", bookID.String())
 
 	query := `select id, reader_id, book_id, review, rating from bs.rating where book_id = $1`
 
 	var coreRatings []*repomodels.RatingModel
 
-	err := rr.db.SelectContext(ctx, &coreRatings, query, bookID)
+	err = rr.db.SelectContext(ctx, &coreRatings, query, bookID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting ratings: %v", err)
+		rr.logger.Debugf("error selecting ratings: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) || len(coreRatings) == 0 {
-		rr.logger.Warn("ratings not found")
+		rr.logger.Debugf("ratings not found")
 		return nil, errs.ErrRatingDoesNotExists
 	}
 
@@ -95,7 +137,7 @@ func (rr *RatingRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) ([]*mod
 		ratings[i] = rr.convertToRatingModel(book)
 	}
 
-	rr.logger.Infof("selected ratings with bookID: %s", bookID.String())
+	rr.logger.Debugf("selected ratings with bookID: %s", bookID.String())
 
 	return ratings, nil
 }
@@ -109,3 +151,13 @@ func (rr *RatingRepo) convertToRatingModel(rating *repomodels.RatingModel) *mode
 		Rating:   rating.Rating,
 	}
 }
+
+func (rr *RatingRepo) convertToRepoRatingModel(rating *models.RatingModel) *repomodels.RatingModel {
+	return &repomodels.RatingModel{
+		ID:       rating.ID,
+		ReaderID: rating.ReaderID,
+		BookID:   rating.BookID,
+		Review:   rating.Review,
+		Rating:   rating.Rating,
+	}
+}
@@ -0,0 +1,237 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"time"
+)
+
+const anonymizedReaderFio = "Anonymized reader"
+
+// Export собирает все персональные данные читателя в один JSON-документ.
+// Все выборки выполняются в одной транзакции, чтобы документ был согласованным
+func (rr *ReaderRepo) Export(ctx context.Context, readerID uuid.UUID) (_ []byte, err error) {
+	ctx, end := rr.start(ctx, "Export", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("exporting data of reader with ID: %s", readerID)
+
+	export := &repodto.ReaderExportDTO{ExportedAt: time.Now()}
+
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		tr := rr.getter.DefaultTrOrDB(ctx, rr.db)
+
+		query := `select id, fio, phone_number, age, role, deactivated_at from bs.reader where id = $1`
+
+		err := tr.GetContext(ctx, &export.Reader, query, readerID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return errs.ErrReaderDoesNotExists
+		}
+
+		query = `select id, lib_card_num, validity, issue_date, action_status 
+				 from bs.lib_card 
+				 where reader_id = $1 
+				 order by issue_date`
+
+		if err = tr.SelectContext(ctx, &export.LibCards, query, readerID); err != nil {
+			return err
+		}
+
+		query = `select r.id, r.book_id, b.title as book_title, r.issue_date, r.return_date, r.state 
+				 from bs.reservation r join bs.book b on b.id = r.book_id 
+				 where r.reader_id = $1 
+				 order by r.issue_date`
+
+		if err = tr.SelectContext(ctx, &export.Reservations, query, readerID); err != nil {
+			return err
+		}
+
+		query = `select r.id, r.book_id, b.title as book_title, r.review, r.rating 
+				 from bs.rating r join bs.book b on b.id = r.book_id 
+				 where r.reader_id = $1`
+
+		if err = tr.SelectContext(ctx, &export.Ratings, query, readerID); err != nil {
+			return err
+		}
+
+		query = `select f.book_id, b.title as book_title 
+				 from bs.favorite_books f join bs.book b on b.id = f.book_id 
+				 where f.reader_id = $1`
+
+		if err = tr.SelectContext(ctx, &export.FavoriteBooks, query, readerID); err != nil {
+			return err
+		}
+
+		query = `select h.book_id, b.title as book_title, h.created_at 
+				 from bs.book_hold h join bs.book b on b.id = h.book_id 
+				 where h.reader_id = $1 
+				 order by h.created_at`
+
+		if err = tr.SelectContext(ctx, &export.BookHolds, query, readerID); err != nil {
+			return err
+		}
+
+		query = `select login_time, success, ip 
+				 from bs.login_history 
+				 where reader_id = $1 
+				 order by login_time`
+
+		return tr.SelectContext(ctx, &export.LoginHistory, query, readerID)
+	})
+	if err != nil && errors.Is(err, errs.ErrReaderDoesNotExists) {
+		rr.logger.Debugf("reader with this ID not found: %s", readerID)
+		return nil, err
+	}
+	if err != nil {
+		rr.logger.Debugf("error exporting reader data: %v", err)
+		return nil, err
+	}
+
+	data, err := json.Marshal(export)
+	if err != nil {
+		rr.logger.Debugf("error marshalling reader data: %v", err)
+		return nil, err
+	}
+
+	rr.logger.Debugf("exported data of reader with ID: %s", readerID)
+
+	return data, nil
+}
+
+// Anonymize необратимо удаляет персональные данные читателя и снимает его с очередей ожидания.
+// Сами бронирования, оценки и избранное остаются, чтобы не искажать статистику по книгам
+func (rr *ReaderRepo) Anonymize(ctx context.Context, readerID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "Anonymize", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("anonymizing reader with ID: %s", readerID)
+
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		tr := rr.getter.DefaultTrOrDB(ctx, rr.db)
+
+		query := `select anonymized_at is not null from bs.reader where id = $1 for update`
+
+		var isAnonymized bool
+		err := tr.GetContext(ctx, &isAnonymized, query, readerID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			return errs.ErrReaderDoesNotExists
+		}
+		if isAnonymized {
+			return repoerrs.ErrReaderIsAlreadyAnonymized
+		}
+
+		// номер телефона уникален, поэтому заменяем его производным от ID значением
+		query = `update bs.reader 
+				 set fio = $1, 
+				     phone_number = 'anon' || substr(replace(id::text, '-', ''), 1, 16), 
+				     password = '', 
+				     deactivated_at = coalesce(deactivated_at, now()), 
+				     anonymized_at = now() 
+				 where id = $2`
+
+		if _, err = tr.ExecContext(ctx, query, anonymizedReaderFio, readerID); err != nil {
+			return err
+		}
+
+		// пустая строка, а не null: RatingRepo читает review в string
+		query = `update bs.rating set review = '' where reader_id = $1`
+
+		if _, err = tr.ExecContext(ctx, query, readerID); err != nil {
+			return err
+		}
+
+		// очередь ожидания анонимному читателю не нужна: книги ему уже не выдадут
+		query = `delete from bs.book_hold where reader_id = $1 returning id, book_id`
+
+		var holds []struct {
+			ID     uuid.UUID `db:"id"`
+			BookID uuid.UUID `db:"book_id"`
+		}
+		if err = tr.SelectContext(ctx, &holds, query, readerID); err != nil {
+			return err
+		}
+		for _, hold := range holds {
+			before := map[string]any{"id": hold.ID, "reader_id": readerID}
+			if err = rr.audit.write(ctx, AuditEntityBookHold, hold.BookID, AuditOperationDelete, before, nil); err != nil {
+				return err
+			}
+		}
+
+		query = `delete from bs.login_history where reader_id = $1`
+
+		if _, err = tr.ExecContext(ctx, query, readerID); err != nil {
+			return err
+		}
+
+		// персональные данные могли попасть в журнал изменений раньше, вычищаем и их
+		query = `update bs.audit_log 
+				 set before_data = before_data - '{fio,phone_number}'::text[], 
+				     after_data = after_data - '{fio,phone_number}'::text[] 
+				 where entity_type = $1 and entity_id = $2`
+
+		if _, err = tr.ExecContext(ctx, query, AuditEntityReader, readerID); err != nil {
+			return err
+		}
+
+		query = `update bs.audit_log 
+				 set before_data = before_data - 'review', 
+				     after_data = after_data - 'review' 
+				 where entity_type = $1 and entity_id in (select id from bs.rating where reader_id = $2)`
+
+		if _, err = tr.ExecContext(ctx, query, AuditEntityRating, readerID); err != nil {
+			return err
+		}
+
+		// и в еще не отправленные или уже отправленные события outbox: события читателя
+		// сводятся к ID, из событий оценок убирается отзыв
+		query = `update bs.outbox 
+				 set payload = jsonb_build_object('id', aggregate_id) 
+				 where aggregate_type = $1 and aggregate_id = $2`
+
+		if _, err = tr.ExecContext(ctx, query, AuditEntityReader, readerID); err != nil {
+			return err
+		}
+
+		query = `update bs.outbox 
+				 set payload = payload - 'review' 
+				 where aggregate_type = $1 and aggregate_id in (select id from bs.rating where reader_id = $2)`
+
+		if _, err = tr.ExecContext(ctx, query, AuditEntityRating, readerID); err != nil {
+			return err
+		}
+
+		if err = rr.audit.write(ctx, AuditEntityReader, readerID, AuditOperationAnonymize, nil, nil); err != nil {
+			return err
+		}
+		if err = rr.outbox.write(ctx, AuditEntityReader, readerID, EventReaderAnonymized, nil); err != nil {
+			return err
+		}
+
+		// токены отзываются последними: если Redis недоступен, транзакция откатится
+		return rr.RevokeRefreshTokens(ctx, readerID)
+	})
+	if err != nil && (errors.Is(err, errs.ErrReaderDoesNotExists) || errors.Is(err, repoerrs.ErrReaderIsAlreadyAnonymized)) {
+		rr.logger.Debugf("reader with ID %s can't be anonymized: %v", readerID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error anonymizing reader: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("anonymized reader with ID: %s", readerID)
+
+	return nil
+}
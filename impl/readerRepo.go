@@ -4,198 +4,555 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
 	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	repodto "github.com/nikitalystsev/BookSmart-repo-postgres/core/dto"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
 	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
 	"github.com/nikitalystsev/BookSmart-services/core/models"
 	"github.com/nikitalystsev/BookSmart-services/errs"
 	"github.com/nikitalystsev/BookSmart-services/intfRepo"
-	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
 	"time"
 )
 
 type ReaderRepo struct {
-	db     *sqlx.DB
-	client *redis.Client
-	logger *logrus.Entry
+	instrumentation
+
+	db        *sqlx.DB
+	client    *redis.Client
+	getter    *trmsqlx.CtxGetter
+	trManager *manager.Manager
+	audit     *auditWriter
+	outbox    *outboxWriter
 }
 
-func NewReaderRepo(db *sqlx.DB, client *redis.Client, logger *logrus.Entry) intfRepo.IReaderRepo {
-	return &ReaderRepo{db: db, client: client, logger: logger}
+var _ intfRepo.IReaderRepo = (*ReaderRepo)(nil)
+
+// NewReaderRepo возвращает репозиторий как интерфейс сервисного слоя
+func NewReaderRepo(db *sqlx.DB, client *redis.Client, logger Logger) intfRepo.IReaderRepo {
+	return NewReaderRepoImpl(db, client, logger)
+}
+
+// NewReaderRepoImpl возвращает ReaderRepo с методами, которых нет в intfRepo.IReaderRepo
+func NewReaderRepoImpl(db *sqlx.DB, client *redis.Client, logger Logger) *ReaderRepo {
+	return &ReaderRepo{
+		instrumentation: instrumentation{repo: "reader", dbSystem: dbSystemPostgres, table: "bs.reader", logger: logger},
+		db:              db,
+		client:          client,
+		getter:          trmsqlx.DefaultCtxGetter,
+		trManager:       manager.Must(trmsqlx.NewDefaultFactory(db)),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		outbox:          newOutboxWriter(db, trmsqlx.DefaultCtxGetter),
+	}
 }
 
-func (rr *ReaderRepo) Create(ctx context.Context, reader *models.ReaderModel) error {
-	rr.logger.Infof("inserting reader with ID: %s", reader.ID)
+// SetTracerProvider включает трассировку вызовов и дочерние спаны на команды Redis.
+// Хук добавляется к копии клиента, чтобы не трассировать чужие команды через общий клиент
+func (rr *ReaderRepo) SetTracerProvider(provider trace.TracerProvider) {
+	rr.instrumentation.SetTracerProvider(provider)
+
+	rr.client = rr.client.WithContext(context.Background())
+	rr.client.AddHook(&redisTracingHook{tracer: rr.tracer})
+}
+
+func (rr *ReaderRepo) Create(ctx context.Context, reader *models.ReaderModel) (err error) {
+	ctx, end := rr.start(ctx, "Create", entityIDAttr(reader.ID))
+	defer end(&err)
+
+	rr.logger.Debugf("inserting reader with ID: %s", reader.ID)
 
 	query := `insert into bs.reader values ($1, $2, $3, $4, $5, $6)`
 
-	result, err := rr.db.ExecContext(
-		ctx, query,
-		reader.ID,
-		reader.Fio,
-		reader.PhoneNumber,
-		reader.Age,
-		reader.Password,
-		reader.Role,
-	)
-	if err != nil {
-		rr.logger.Errorf("error inserting reader: %v", err)
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
+			ctx, query,
+			reader.ID,
+			reader.Fio,
+			reader.PhoneNumber,
+			reader.Age,
+			reader.Password,
+			reader.Role,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrReaderPhoneNumberAlreadyExist
+		}
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("readerRepo.Create: expected 1 row affected, got %d", rows)
+		}
+
+		return rr.recordChange(ctx, reader.ID, AuditOperationCreate, EventReaderCreated, nil)
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrReaderPhoneNumberAlreadyExist) {
+		rr.logger.Debugf("reader with this phoneNumber already exists: %s", redactPhone(reader.PhoneNumber))
 		return err
 	}
-	rows, err := result.RowsAffected()
 	if err != nil {
-		rr.logger.Errorf("error inserting reader: %v", err)
+		rr.logger.Debugf("error inserting reader: %v", err)
 		return err
 	}
-	if rows != 1 {
-		rr.logger.Errorf("error inserting reader: %d rows affected", rows)
-		return errors.New("readerRepo.Create: expected 1 row affected")
-	}
 
-	rr.logger.Infof("inserted reader with ID: %s", reader.ID)
+	rr.logger.Debugf("inserted reader with ID: %s", reader.ID)
 
 	return nil
 }
 
-func (rr *ReaderRepo) GetByPhoneNumber(ctx context.Context, phoneNumber string) (*models.ReaderModel, error) {
-	rr.logger.Infof("selecting reader with phoneNumber: %s", phoneNumber)
+func (rr *ReaderRepo) GetByPhoneNumber(ctx context.Context, phoneNumber string) (_ *models.ReaderModel, err error) {
+	ctx, end := rr.start(ctx, "GetByPhoneNumber")
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reader with phoneNumber: %s", redactPhone(phoneNumber))
 
-	query := `select id, fio, phone_number, age, password, role from bs.reader where phone_number = $1`
+	query := `select id, fio, phone_number, age, password, role 
+			  from bs.reader 
+			  where phone_number = $1 and deactivated_at is null`
 
 	var reader repomodels.ReaderModel
-	err := rr.db.GetContext(ctx, &reader, query, phoneNumber)
+	err = rr.db.GetContext(ctx, &reader, query, phoneNumber)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reader by phoneNumber: %v", err)
+		rr.logger.Debugf("error selecting reader by phoneNumber: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Warnf("reader with this phoneNumber not found: %s", phoneNumber)
+		rr.logger.Debugf("reader with this phoneNumber not found: %s", redactPhone(phoneNumber))
 		return nil, errs.ErrReaderDoesNotExists
 	}
 
-	rr.logger.Infof("selected reader with phoneNumber: %s", phoneNumber)
+	rr.logger.Debugf("selected reader with phoneNumber: %s", redactPhone(phoneNumber))
 
 	return rr.convertToReaderModel(&reader), nil
 }
 
-func (rr *ReaderRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.ReaderModel, error) {
-	rr.logger.Infof("selecting reader with ID: %s", ID)
+func (rr *ReaderRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *models.ReaderModel, err error) {
+	ctx, end := rr.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reader with ID: %s", ID)
 
 	query := `select id, fio, phone_number, age, password, role from bs.reader where id = $1`
 
 	var reader repomodels.ReaderModel
-	err := rr.db.GetContext(ctx, &reader, query, ID)
+	err = rr.db.GetContext(ctx, &reader, query, ID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reader with ID: %v", err)
+		rr.logger.Debugf("error selecting reader with ID: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Warnf("reader with this ID not found: %v", ID)
+		rr.logger.Debugf("reader with this ID not found: %v", ID)
 		return nil, errs.ErrReaderDoesNotExists
 	}
 
-	rr.logger.Infof("selected reader with ID: %s", ID)
+	rr.logger.Debugf("selected reader with ID: %s", ID)
 
 	return rr.convertToReaderModel(&reader), nil
 }
 
-func (rr *ReaderRepo) IsFavorite(ctx context.Context, readerID, bookID uuid.UUID) (bool, error) {
-	rr.logger.Infof("book with ID = %s already is favorite?", bookID)
+func (rr *ReaderRepo) IsFavorite(ctx context.Context, readerID, bookID uuid.UUID) (_ bool, err error) {
+	ctx, end := rr.start(ctx, "IsFavorite", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("book with ID = %s already is favorite?", bookID)
 
 	query := `select count(*) from bs.favorite_books where reader_id = $1 and book_id = $2`
 
 	var count int
-	err := rr.db.GetContext(ctx, &count, query, readerID, bookID)
+	err = rr.db.GetContext(ctx, &count, query, readerID, bookID)
 	if err != nil {
-		rr.logger.Errorf("error checking favorite book: %v", err)
+		rr.logger.Debugf("error checking favorite book: %v", err)
 		return false, err
 	}
 
-	rr.logger.Infof("checked favorite book")
+	rr.logger.Debugf("checked favorite book")
 
 	return count > 0, nil
 }
 
-func (rr *ReaderRepo) AddToFavorites(ctx context.Context, readerID, bookID uuid.UUID) error {
-	rr.logger.Infof("reader (ID = %s) adding book (ID = %s) to favorites", readerID, bookID)
+func (rr *ReaderRepo) AddToFavorites(ctx context.Context, readerID, bookID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "AddToFavorites", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("reader (ID = %s) adding book (ID = %s) to favorites", readerID, bookID)
 
 	query := `insert into bs.favorite_books (reader_id, book_id) values ($1, $2)`
 
 	result, err := rr.db.ExecContext(ctx, query, readerID, bookID)
 	if err != nil {
-		rr.logger.Errorf("error adding book to favorites: %v", err)
+		rr.logger.Debugf("error adding book to favorites: %v", err)
 		return err
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		rr.logger.Errorf("error adding book to favorites: %v", err)
+		rr.logger.Debugf("error adding book to favorites: %v", err)
 		return err
 	}
+	setRowsAffected(ctx, rows)
 	if rows != 1 {
-		rr.logger.Errorf("error inserting favirites: %d rows affected", rows)
+		rr.logger.Debugf("error inserting favirites: %d rows affected", rows)
 		return errors.New("readerRepo.AddToFavorites: expected 1 row affected")
 	}
 
-	rr.logger.Infof("reader (ID = %s) added book (ID = %s) to favorites", readerID, bookID)
+	rr.logger.Debugf("reader (ID = %s) added book (ID = %s) to favorites", readerID, bookID)
 
 	return nil
 }
 
-func (rr *ReaderRepo) SaveRefreshToken(ctx context.Context, id uuid.UUID, token string, ttl time.Duration) error {
-	rr.logger.Infof("saving refresh token in redis")
+func (rr *ReaderRepo) SaveRefreshToken(ctx context.Context, id uuid.UUID, token string, ttl time.Duration) (err error) {
+	ctx, end := rr.start(ctx, "SaveRefreshToken", entityIDAttr(id), redisSystemAttr)
+	defer end(&err)
+
+	rr.logger.Debugf("saving refresh token in redis")
 
-	err := rr.client.Set(ctx, token, id.String(), ttl).Err()
+	// дополнительно храним множество токенов читателя, чтобы их можно было отозвать
+	tokensKey := rr.refreshTokensKey(id)
+	_, err = rr.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, token, id.String(), ttl)
+		pipe.SAdd(ctx, tokensKey, token)
+		pipe.Expire(ctx, tokensKey, ttl)
+		return nil
+	})
 	if err != nil {
-		rr.logger.Errorf("error saving refresh token: %v", err)
+		rr.logger.Debugf("error saving refresh token: %v", err)
 		return err
 	}
 
-	rr.logger.Infof("refresh token saved in redis")
+	rr.logger.Debugf("refresh token saved in redis")
 
 	return nil
 }
 
-func (rr *ReaderRepo) GetByRefreshToken(ctx context.Context, token string) (*models.ReaderModel, error) {
-	rr.logger.Infof("getting reader by refresh token: %s", token)
+func (rr *ReaderRepo) GetByRefreshToken(ctx context.Context, token string) (_ *models.ReaderModel, err error) {
+	ctx, end := rr.start(ctx, "GetByRefreshToken")
+	defer end(&err)
+
+	rr.logger.Debugf("getting reader by refresh token: %s", redactToken(token))
 
 	var readerID uuid.UUID
 
 	readerIDStr, err := rr.client.Get(ctx, token).Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
-		rr.logger.Errorf("error getting reader by refresh token: %v", err)
+		rr.logger.Debugf("error getting reader by refresh token: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, redis.Nil) {
-		rr.logger.Errorf("reader with this refresh token not found: %s", token)
+		rr.logger.Debugf("reader with this refresh token not found: %s", redactToken(token))
 		return nil, errs.ErrReaderDoesNotExists
 	}
 
 	readerID, err = uuid.Parse(readerIDStr)
 	if err != nil {
-		rr.logger.Errorf("error parsing readerID by refresh token: %v", err)
+		rr.logger.Debugf("error parsing readerID by refresh token: %v", err)
 		return nil, err
 	}
 
 	var reader repomodels.ReaderModel
 
-	query := `select id, fio, phone_number, age, password, role from bs.reader where id = $1`
+	query := `select id, fio, phone_number, age, password, role 
+			  from bs.reader 
+			  where id = $1 and deactivated_at is null`
 
 	err = rr.db.GetContext(ctx, &reader, query, readerID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reader by id: %v", err)
+		rr.logger.Debugf("error selecting reader by id: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Warnf("reader with this ID not found: %v", readerID)
+		rr.logger.Debugf("reader with this ID not found: %v", readerID)
 		return nil, errs.ErrReaderDoesNotExists
 	}
 
-	rr.logger.Infof("getting reader by refresh token: %v", token)
+	rr.logger.Debugf("getting reader by refresh token: %v", redactToken(token))
 
 	return rr.convertToReaderModel(&reader), nil
 }
 
+func (rr *ReaderRepo) GetByParams(ctx context.Context, params *repodto.ReaderParamsDTO) (_ []*models.ReaderModel, err error) {
+	ctx, end := rr.start(ctx, "GetByParams")
+	defer end(&err)
+
+	rr.logger.Debugf("selecting readers with params")
+
+	query := `select id, fio, phone_number, age, password, role 
+			  from bs.reader 
+			  where deactivated_at is null and 
+			        ($1 = '' or fio ilike '%' || $1 || '%') and 
+			        ($2 = '' or phone_number like '%' || $2 || '%') and 
+			        ($3 = '' or role::text = $3) and 
+			        ($4 = 0 or age >= $4) and 
+			        ($5 = 0 or age <= $5)
+			  order by fio
+			  limit $6 offset $7`
+
+	var coreReaders []*repomodels.ReaderModel
+
+	err = rr.db.SelectContext(ctx, &coreReaders, query,
+		escapeLike(params.Fio),
+		escapeLike(params.PhoneNumber),
+		params.Role,
+		params.MinAge,
+		params.MaxAge,
+		params.Limit,
+		params.Offset,
+	)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rr.logger.Debugf("error selecting readers with params: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(coreReaders) == 0 {
+		rr.logger.Debugf("readers not found with this params")
+		return nil, errs.ErrReaderDoesNotExists
+	}
+
+	rr.logger.Debugf("found %d readers", len(coreReaders))
+
+	readers := make([]*models.ReaderModel, len(coreReaders))
+	for i, reader := range coreReaders {
+		readers[i] = rr.convertToReaderModel(reader)
+	}
+
+	return readers, nil
+}
+
+func (rr *ReaderRepo) Update(ctx context.Context, reader *models.ReaderModel) (err error) {
+	ctx, end := rr.start(ctx, "Update", entityIDAttr(reader.ID))
+	defer end(&err)
+
+	rr.logger.Debugf("updating reader with ID: %s", reader.ID)
+
+	query := `update bs.reader 
+			  set fio = $1, 
+			      phone_number = $2, 
+			      age = $3, 
+			      password = $4, 
+			      role = $5
+			  where id = $6`
+
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := rr.getActiveForUpdate(ctx, reader.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
+			ctx, query,
+			reader.Fio,
+			reader.PhoneNumber,
+			reader.Age,
+			reader.Password,
+			reader.Role,
+			reader.ID,
+		)
+		if err != nil && isUniqueViolation(err) {
+			return repoerrs.ErrReaderPhoneNumberAlreadyExist
+		}
+		if err != nil {
+			return err
+		}
+
+		return rr.recordChange(ctx, reader.ID, AuditOperationUpdate, EventReaderUpdated, before)
+	})
+	if err != nil && rr.isExpectedMutationError(err) {
+		rr.logger.Debugf("reader with ID %s can't be updated: %v", reader.ID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error updating reader: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("updated reader with ID: %s", reader.ID)
+
+	return nil
+}
+
+func (rr *ReaderRepo) UpdateRole(ctx context.Context, ID uuid.UUID, role string) (err error) {
+	ctx, end := rr.start(ctx, "UpdateRole", entityIDAttr(ID))
+	defer end(&err)
+
+	rr.logger.Debugf("updating role of reader with ID: %s", ID)
+
+	query := `update bs.reader set role = $1 where id = $2`
+
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := rr.getActiveForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, role, ID); err != nil {
+			return err
+		}
+
+		return rr.recordChange(ctx, ID, AuditOperationUpdate, EventReaderUpdated, before)
+	})
+	if err != nil && rr.isExpectedMutationError(err) {
+		rr.logger.Debugf("role of reader with ID %s can't be updated: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error updating reader role: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("updated role of reader with ID: %s", ID)
+
+	return nil
+}
+
+// Deactivate не удаляет читателя, чтобы сохранить историю его бронирований и отзывов
+func (rr *ReaderRepo) Deactivate(ctx context.Context, ID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "Deactivate", entityIDAttr(ID))
+	defer end(&err)
+
+	rr.logger.Debugf("deactivating reader with ID: %s", ID)
+
+	query := `update bs.reader set deactivated_at = now() where id = $1`
+
+	err = rr.trManager.Do(ctx, func(ctx context.Context) error {
+		before, err := rr.getActiveForUpdate(ctx, ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, ID); err != nil {
+			return err
+		}
+
+		return rr.recordChange(ctx, ID, AuditOperationDeactivate, EventReaderDeactivated, before)
+	})
+	if err != nil && rr.isExpectedMutationError(err) {
+		rr.logger.Debugf("reader with ID %s can't be deactivated: %v", ID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error deactivating reader: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("deactivated reader with ID: %s", ID)
+
+	return nil
+}
+
+// readerSnapshot — состояние строки читателя для журнала изменений (пароль в журнал не попадает)
+type readerSnapshot struct {
+	repomodels.ReaderModel
+	DeactivatedAt *time.Time `db:"deactivated_at"`
+}
+
+// getForUpdate блокирует строку читателя до конца транзакции и возвращает ее состояние
+func (rr *ReaderRepo) getForUpdate(ctx context.Context, ID uuid.UUID) (*readerSnapshot, error) {
+	query := `select id, fio, phone_number, age, password, role, deactivated_at 
+			  from bs.reader 
+			  where id = $1 
+			  for update`
+
+	var snapshot readerSnapshot
+	err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &snapshot, query, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrReaderDoesNotExists
+	}
+
+	return &snapshot, nil
+}
+
+func (rr *ReaderRepo) getActiveForUpdate(ctx context.Context, ID uuid.UUID) (*readerSnapshot, error) {
+	snapshot, err := rr.getForUpdate(ctx, ID)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.DeactivatedAt != nil {
+		return nil, repoerrs.ErrReaderIsDeactivated
+	}
+
+	return snapshot, nil
+}
+
+// recordChange перечитывает читателя после изменения, пишет разницу с before в журнал
+// и доменное событие в outbox
+func (rr *ReaderRepo) recordChange(ctx context.Context, ID uuid.UUID, operation, eventType string, before *readerSnapshot) error {
+	after, err := rr.getForUpdate(ctx, ID)
+	if err != nil {
+		return err
+	}
+
+	if err = rr.audit.write(ctx, AuditEntityReader, ID, operation, before, after); err != nil {
+		return err
+	}
+
+	return rr.outbox.write(ctx, AuditEntityReader, ID, eventType, readerEventPayload(ID, before, after))
+}
+
+// readerEventPayload — событие читателя без персональных данных: outbox уходит во внешние
+// потоки, откуда Anonymize их уже не вычистит. Подписчикам передаются только ID и имена
+// изменившихся полей, сами значения они читают через репозиторий
+func readerEventPayload(ID uuid.UUID, before, after *readerSnapshot) map[string]any {
+	payload := map[string]any{"id": ID}
+	if before == nil {
+		return payload
+	}
+
+	_, changed := auditDiff(auditFields(before), auditFields(after))
+	changedFields := make([]string, 0, len(changed))
+	for column := range changed {
+		changedFields = append(changedFields, column)
+	}
+	sort.Strings(changedFields)
+	payload["changed_fields"] = changedFields
+
+	return payload
+}
+
+func (rr *ReaderRepo) isExpectedMutationError(err error) bool {
+	return errors.Is(err, errs.ErrReaderDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrReaderIsDeactivated) ||
+		errors.Is(err, repoerrs.ErrReaderPhoneNumberAlreadyExist)
+}
+
+func (rr *ReaderRepo) RevokeRefreshTokens(ctx context.Context, id uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "RevokeRefreshTokens", entityIDAttr(id), redisSystemAttr)
+	defer end(&err)
+
+	rr.logger.Debugf("revoking refresh tokens of reader with ID: %s", id)
+
+	tokensKey := rr.refreshTokensKey(id)
+
+	tokens, err := rr.client.SMembers(ctx, tokensKey).Result()
+	if err != nil {
+		rr.logger.Debugf("error getting refresh tokens of reader: %v", err)
+		return err
+	}
+
+	err = rr.client.Del(ctx, append(tokens, tokensKey)...).Err()
+	if err != nil {
+		rr.logger.Debugf("error revoking refresh tokens: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("revoked %d refresh tokens of reader with ID: %s", len(tokens), id)
+
+	return nil
+}
+
+func (rr *ReaderRepo) refreshTokensKey(id uuid.UUID) string {
+	return fmt.Sprintf("reader:%s:refresh_tokens", id)
+}
+
 func (rr *ReaderRepo) convertToReaderModel(reader *repomodels.ReaderModel) *models.ReaderModel {
 	return &models.ReaderModel{
 		ID:          reader.ID,
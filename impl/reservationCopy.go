@@ -0,0 +1,204 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+)
+
+// IssueCopy закрепляет за бронированием экземпляр, отсканированный на выдаче. Экземпляр
+// должен быть экземпляром забронированной книги, стоять на полке (Available) и числиться
+// в филиале выдачи
+func (rr *ReservationRepo) IssueCopy(ctx context.Context, reservationID uuid.UUID, barcode string) (err error) {
+	ctx, end := rr.start(ctx, "IssueCopy", entityIDAttr(reservationID))
+	defer end(&err)
+
+	rr.logger.Debugf("issuing copy %s for reservation with ID: %s", barcode, reservationID)
+
+	err = rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		reservation, err := rr.getForUpdate(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+		if reservation.State == impl.ReservationClosed {
+			return errs.ErrReservationIsAlreadyClosed
+		}
+
+		bookCopy, err := rr.getCopyForUpdate(ctx, normalizeBarcode(barcode))
+		if err != nil {
+			return err
+		}
+		if bookCopy.BookID != reservation.BookID {
+			return repoerrs.ErrBookCopyOfAnotherBook
+		}
+		if bookCopy.Status != BookCopyStatusAvailable {
+			return repoerrs.ErrBookCopyIsNotAvailable
+		}
+		if !sameBranch(reservation.BranchID, bookCopy.BranchID) {
+			return repoerrs.ErrBookCopyOfAnotherBranch
+		}
+
+		// экземпляр, выданный по этому бронированию раньше, возвращается на полку
+		if err = rr.releaseCopy(ctx, reservationID); err != nil {
+			return err
+		}
+
+		query := `update bs.reservation set copy_id = $1 where id = $2`
+		if _, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, bookCopy.ID, reservationID); err != nil {
+			return err
+		}
+		if err = rr.setCopyStatus(ctx, bookCopy.ID, BookCopyStatusIssued); err != nil {
+			return err
+		}
+
+		after, err := rr.getForUpdate(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+		if err = rr.audit.write(ctx, AuditEntityReservation, reservationID, AuditOperationUpdate, reservation, after); err != nil {
+			return err
+		}
+
+		return rr.outbox.write(ctx, AuditEntityReservation, reservationID, EventReservationUpdated, after)
+	})
+	if err != nil && (errors.Is(err, errs.ErrReservationDoesNotExists) ||
+		errors.Is(err, errs.ErrReservationIsAlreadyClosed) ||
+		errors.Is(err, repoerrs.ErrBookCopyDoesNotExists) ||
+		errors.Is(err, repoerrs.ErrBookCopyOfAnotherBook) ||
+		errors.Is(err, repoerrs.ErrBookCopyIsNotAvailable) ||
+		errors.Is(err, repoerrs.ErrBookCopyOfAnotherBranch)) {
+		rr.logger.Debugf("copy %s can't be issued for reservation with ID %s: %v", barcode, reservationID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error issuing copy: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("issued copy %s for reservation with ID: %s", barcode, reservationID)
+
+	return nil
+}
+
+// GetOpenByBarcode находит незакрытое бронирование, по которому выдан экземпляр со штрихкодом;
+// нужен на возврате, когда читатель приносит книгу без документов
+func (rr *ReservationRepo) GetOpenByBarcode(ctx context.Context, barcode string) (_ *models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetOpenByBarcode")
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reservation by copy barcode: %s", barcode)
+
+	query := `select
+    			r.id,
+    			r.reader_id,
+    			r.book_id,
+    			r.issue_date,
+    			r.return_date,
+    			r.state
+			  from bs.reservation_view r
+			      join bs.reservation rc on rc.id = r.id
+			      join bs.book_copy c on c.id = rc.copy_id
+			  where c.barcode = $1 and r.state != $2`
+
+	var reservation repomodels.ReservationModel
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &reservation, query, normalizeBarcode(barcode), impl.ReservationClosed)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rr.logger.Debugf("error selecting reservation by copy barcode: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		rr.logger.Debugf("open reservation with copy barcode not found: %s", barcode)
+		return nil, errs.ErrReservationDoesNotExists
+	}
+
+	rr.logger.Debugf("selected reservation with copy barcode: %s", barcode)
+
+	return rr.convertToReservationModel(&reservation), nil
+}
+
+func (rr *ReservationRepo) getCopyForUpdate(ctx context.Context, barcode string) (*repomodels.BookCopyModel, error) {
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where barcode = $1
+			  for update`
+
+	var bookCopy repomodels.BookCopyModel
+	err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &bookCopy, query, barcode)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, repoerrs.ErrBookCopyDoesNotExists
+	}
+
+	return &bookCopy, nil
+}
+
+// releaseCopy возвращает на полку экземпляр, выданный по бронированию. У закрытого бронирования
+// ссылка на экземпляр остается, чтобы было видно, какой экземпляр был у читателя
+func (rr *ReservationRepo) releaseCopy(ctx context.Context, reservationID uuid.UUID) error {
+	query := `select copy_id from bs.reservation where id = $1 and copy_id is not null`
+
+	var copyID uuid.UUID
+	err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &copyID, query, reservationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `update bs.reservation set copy_id = null where id = $1 and state != $2`
+	if _, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, reservationID, impl.ReservationClosed); err != nil {
+		return err
+	}
+
+	return rr.setCopyStatus(ctx, copyID, BookCopyStatusAvailable)
+}
+
+// setCopyStatus меняет статус экземпляра, выданного или возвращаемого по бронированию, и пишет
+// изменение в журнал и outbox, как BookCopyRepo; потерянный или списанный за это время
+// экземпляр не трогается
+func (rr *ReservationRepo) setCopyStatus(ctx context.Context, copyID uuid.UUID, status string) error {
+	query := `select id, book_id, barcode, condition, location, status, branch_id, created_at
+			  from bs.book_copy
+			  where id = $1
+			  for update`
+
+	var before repomodels.BookCopyModel
+	if err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &before, query, copyID); err != nil {
+		return err
+	}
+	if before.Status == status || (before.Status != BookCopyStatusAvailable && before.Status != BookCopyStatusIssued) {
+		return nil
+	}
+
+	query = `update bs.book_copy set status = $1 where id = $2`
+	if _, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, status, copyID); err != nil {
+		return err
+	}
+
+	after := before
+	after.Status = status
+	if err := rr.audit.write(ctx, AuditEntityBookCopy, copyID, AuditOperationUpdate, &before, &after); err != nil {
+		return err
+	}
+
+	return rr.outbox.write(ctx, AuditEntityBookCopy, copyID, EventBookCopyUpdated, &after)
+}
+
+// sameBranch — экземпляр и бронирование в одном филиале; без филиала у одного из них
+// (данные до появления филиалов) проверка не делается
+func sameBranch(reservationBranchID, copyBranchID *uuid.UUID) bool {
+	if reservationBranchID == nil || copyBranchID == nil {
+		return true
+	}
+
+	return *reservationBranchID == *copyBranchID
+}
@@ -0,0 +1,202 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/google/uuid"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
+	"github.com/nikitalystsev/BookSmart-services/errs"
+	"github.com/nikitalystsev/BookSmart-services/impl"
+	"time"
+)
+
+// ReservationRenewalPolicy задает правила продления бронирования
+type ReservationRenewalPolicy struct {
+	ExtensionDays int // на сколько дней сдвигается срок возврата
+	MaxRenewals   int // сколько раз всего можно продлить одно бронирование
+}
+
+// DefaultReservationRenewalPolicy повторяет правило сервисного слоя: одно продление на неделю
+var DefaultReservationRenewalPolicy = ReservationRenewalPolicy{
+	ExtensionDays: impl.ReservationExtensionPeriodDays,
+	MaxRenewals:   1,
+}
+
+func (p *ReservationRenewalPolicy) validate() error {
+	if p.ExtensionDays <= 0 || p.MaxRenewals <= 0 {
+		return repoerrs.ErrInvalidReservationRenewalPolicy
+	}
+
+	return nil
+}
+
+// SetRenewalPolicy задает правила продления для Renew и Update. По умолчанию действует
+// DefaultReservationRenewalPolicy
+func (rr *ReservationRepo) SetRenewalPolicy(policy ReservationRenewalPolicy) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	rr.renewalPolicy = policy
+
+	return nil
+}
+
+// Renew продлевает бронирование на ExtensionDays дней политики репозитория от текущего срока
+// возврата и переводит его в состояние Extended. Продление не делается, если срок возврата
+// уже прошел, лимит продлений исчерпан или книгу ждут другие читатели
+func (rr *ReservationRepo) Renew(ctx context.Context, reservationID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "Renew", entityIDAttr(reservationID))
+	defer end(&err)
+
+	rr.logger.Debugf("renewing reservation with ID: %s", reservationID)
+
+	err = rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := rr.getForUpdate(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+
+		newReturnDate := before.ReturnDate.AddDate(0, 0, rr.renewalPolicy.ExtensionDays)
+		if err = rr.checkRenewal(ctx, before, newReturnDate); err != nil {
+			return err
+		}
+
+		if err = rr.recordRenewal(ctx, reservationID, before.ReturnDate, newReturnDate); err != nil {
+			return err
+		}
+
+		query := `update bs.reservation set return_date = $1, state = $2 where id = $3`
+
+		if _, err = rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, newReturnDate, impl.ReservationExtended, reservationID); err != nil {
+			return err
+		}
+
+		after, err := rr.getForUpdate(ctx, reservationID)
+		if err != nil {
+			return err
+		}
+		if err = rr.audit.write(ctx, AuditEntityReservation, reservationID, AuditOperationUpdate, before, after); err != nil {
+			return err
+		}
+
+		return rr.outbox.write(ctx, AuditEntityReservation, reservationID, EventReservationUpdated, after)
+	})
+	if err != nil && (errors.Is(err, errs.ErrReservationDoesNotExists) ||
+		errors.Is(err, errs.ErrReservationIsAlreadyClosed) ||
+		errors.Is(err, errs.ErrReservationIsAlreadyExpired) ||
+		errors.Is(err, repoerrs.ErrReservationRenewalLimitReached) ||
+		errors.Is(err, repoerrs.ErrBookHasWaitingReaders)) {
+		rr.logger.Debugf("reservation with ID %s can't be renewed: %v", reservationID, err)
+		return err
+	}
+	if err != nil {
+		rr.logger.Debugf("error renewing reservation: %v", err)
+		return err
+	}
+
+	rr.logger.Debugf("renewed reservation with ID: %s", reservationID)
+
+	return nil
+}
+
+// checkRenewal проверяет, можно ли продлить бронирование в состоянии before до newReturnDate.
+// Общая для Renew и продления через Update; одно продление сдвигает срок не дальше ExtensionDays
+func (rr *ReservationRepo) checkRenewal(ctx context.Context, before *repomodels.ReservationModel, newReturnDate time.Time) error {
+	tr := rr.getter.DefaultTrOrDB(ctx, rr.db)
+	policy := rr.renewalPolicy
+
+	if before.State == impl.ReservationClosed {
+		return errs.ErrReservationIsAlreadyClosed
+	}
+	if before.State == impl.ReservationExpired {
+		return errs.ErrReservationIsAlreadyExpired
+	}
+
+	// состояние Expired проставляется периодически, поэтому просрочка проверяется и по дате;
+	// срок возврата — дата, и в свой последний день бронирование еще не просрочено
+	query := `select $1::date < current_date`
+
+	var overdue bool
+	if err := tr.GetContext(ctx, &overdue, query, before.ReturnDate); err != nil {
+		return err
+	}
+	if overdue {
+		return errs.ErrReservationIsAlreadyExpired
+	}
+
+	query = `select $1::date > $2::date + $3::int`
+
+	var tooLong bool
+	if err := tr.GetContext(ctx, &tooLong, query, newReturnDate, before.ReturnDate, policy.ExtensionDays); err != nil {
+		return err
+	}
+	if tooLong {
+		return repoerrs.ErrReservationExtensionTooLong
+	}
+
+	query = `select count(*) from bs.reservation_renewal where reservation_id = $1`
+
+	var renewals int
+	if err := tr.GetContext(ctx, &renewals, query, before.ID); err != nil {
+		return err
+	}
+	if renewals >= policy.MaxRenewals {
+		return repoerrs.ErrReservationRenewalLimitReached
+	}
+
+	query = `select exists (select 1 from bs.book_hold where book_id = $1 and reader_id != $2)`
+
+	var hasWaiting bool
+	if err := tr.GetContext(ctx, &hasWaiting, query, before.BookID, before.ReaderID); err != nil {
+		return err
+	}
+	if hasWaiting {
+		return repoerrs.ErrBookHasWaitingReaders
+	}
+
+	return nil
+}
+
+func (rr *ReservationRepo) GetRenewalHistory(ctx context.Context, reservationID uuid.UUID) (_ []*repomodels.ReservationRenewalModel, err error) {
+	ctx, end := rr.start(ctx, "GetRenewalHistory", entityIDAttr(reservationID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting renewal history of reservation with ID: %s", reservationID)
+
+	query := `select
+    			id,
+    			reservation_id,
+    			previous_return_date,
+    			new_return_date,
+    			renewed_at
+			  from bs.reservation_renewal
+			  where reservation_id = $1
+			  order by renewed_at`
+
+	var renewals []*repomodels.ReservationRenewalModel
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &renewals, query, reservationID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		rr.logger.Debugf("error selecting renewal history: %v", err)
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) || len(renewals) == 0 {
+		rr.logger.Debugf("renewal history of reservation with ID not found: %s", reservationID)
+		return nil, repoerrs.ErrReservationRenewalDoesNotExist
+	}
+
+	rr.logger.Debugf("found %d renewals of reservation with ID: %s", len(renewals), reservationID)
+
+	return renewals, nil
+}
+
+// recordRenewal пишет продление в историю; ее длина и есть число продлений бронирования
+func (rr *ReservationRepo) recordRenewal(ctx context.Context, reservationID uuid.UUID, previousReturnDate, newReturnDate time.Time) error {
+	query := `insert into bs.reservation_renewal (reservation_id, previous_return_date, new_return_date)
+			  values ($1, $2, $3)`
+
+	_, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(ctx, query, reservationID, previousReturnDate, newReturnDate)
+
+	return err
+}
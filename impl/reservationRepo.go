@@ -8,60 +8,134 @@ import (
 	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
 	repomodels "github.com/nikitalystsev/BookSmart-repo-postgres/core/models"
 	"github.com/nikitalystsev/BookSmart-services/core/models"
 	"github.com/nikitalystsev/BookSmart-services/errs"
 	"github.com/nikitalystsev/BookSmart-services/impl"
 	"github.com/nikitalystsev/BookSmart-services/intfRepo"
-	"github.com/sirupsen/logrus"
 	"time"
 )
 
 type ReservationRepo struct {
-	db     *sqlx.DB
-	getter *trmsqlx.CtxGetter
-	logger *logrus.Entry
+	instrumentation
+
+	db       *sqlx.DB
+	getter   *trmsqlx.CtxGetter
+	txRunner *TxRunner
+	audit    *auditWriter
+	outbox   *outboxWriter
+
+	renewalPolicy ReservationRenewalPolicy
 }
 
-func NewReservationRepo(db *sqlx.DB, logger *logrus.Entry) intfRepo.IReservationRepo {
-	return &ReservationRepo{db: db, getter: trmsqlx.DefaultCtxGetter, logger: logger}
+var _ intfRepo.IReservationRepo = (*ReservationRepo)(nil)
+
+// NewReservationRepo возвращает репозиторий как интерфейс сервисного слоя
+func NewReservationRepo(db *sqlx.DB, logger Logger) intfRepo.IReservationRepo {
+	return NewReservationRepoImpl(db, logger)
 }
 
-func (rr *ReservationRepo) Create(ctx context.Context, reservation *models.ReservationModel) error {
-	rr.logger.Infof("inserting reservation with ID: %s", reservation.ID)
+// NewReservationRepoImpl возвращает ReservationRepo с методами, которых нет в intfRepo.IReservationRepo
+func NewReservationRepoImpl(db *sqlx.DB, logger Logger) *ReservationRepo {
+	return &ReservationRepo{
+		instrumentation: instrumentation{repo: "reservation", dbSystem: dbSystemPostgres, table: "bs.reservation", logger: logger},
+		db:              db,
+		getter:          trmsqlx.DefaultCtxGetter,
+		txRunner:        NewTxRunner(db, TxRunnerConfig{}, logger),
+		audit:           newAuditWriter(db, trmsqlx.DefaultCtxGetter),
+		outbox:          newOutboxWriter(db, trmsqlx.DefaultCtxGetter),
+		renewalPolicy:   DefaultReservationRenewalPolicy,
+	}
+}
 
-	query := `insert into bs.reservation values ($1, $2, $3, $4, $5, $6)`
+// SetMetrics подключает метрики и к вызовам репозитория, и к повторам его транзакций
+func (rr *ReservationRepo) SetMetrics(metrics *Metrics) {
+	rr.instrumentation.SetMetrics(metrics)
+	rr.txRunner.SetMetrics(metrics)
+}
 
-	result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
-		ctx, query,
-		reservation.ID,
-		reservation.ReaderID,
-		reservation.BookID,
-		reservation.IssueDate,
-		reservation.ReturnDate,
-		reservation.State,
-	)
-	if err != nil {
-		rr.logger.Errorf("error inserting reservation: %v", err)
+// Create заводит бронирование в филиале из контекста (WithBranchID), а без него — вне филиалов.
+// Код, которому филиал известен, должен вызывать CreateInBranch
+func (rr *ReservationRepo) Create(ctx context.Context, reservation *models.ReservationModel) (err error) {
+	ctx, end := rr.start(ctx, "Create", entityIDAttr(reservation.ID))
+	defer end(&err)
+
+	return rr.create(ctx, reservation, branchIDArg(ctx))
+}
+
+// CreateInBranch заводит бронирование в филиале выдачи branchID
+func (rr *ReservationRepo) CreateInBranch(ctx context.Context, reservation *models.ReservationModel, branchID uuid.UUID) (err error) {
+	ctx, end := rr.start(ctx, "CreateInBranch", entityIDAttr(reservation.ID))
+	defer end(&err)
+
+	return rr.create(ctx, reservation, &branchID)
+}
+
+func (rr *ReservationRepo) create(ctx context.Context, reservation *models.ReservationModel, branchID *uuid.UUID) error {
+	rr.logger.Debugf("inserting reservation with ID: %s", reservation.ID)
+
+	query := `insert into bs.reservation (id, reader_id, book_id, issue_date, return_date, state, branch_id) 
+			  values ($1, $2, $3, $4, $5, $6, $7)`
+
+	err := rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		if err := checkBranchExists(ctx, rr.getter.DefaultTrOrDB(ctx, rr.db), branchID); err != nil {
+			return err
+		}
+
+		result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
+			ctx, query,
+			reservation.ID,
+			reservation.ReaderID,
+			reservation.BookID,
+			reservation.IssueDate,
+			reservation.ReturnDate,
+			reservation.State,
+			branchID,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("reservationRepo.Create: expected 1 row affected, got %d", rows)
+		}
+		// читатель, дождавшийся книги, выходит из очереди ожидания
+		if _, err = rr.deleteHold(ctx, reservation.BookID, reservation.ReaderID); err != nil {
+			return err
+		}
+
+		after := rr.convertToRepoReservationModel(reservation)
+		after.BranchID = branchID
+		if err = rr.audit.write(ctx, AuditEntityReservation, reservation.ID, AuditOperationCreate, nil, after); err != nil {
+			return err
+		}
+
+		return rr.outbox.write(ctx, AuditEntityReservation, reservation.ID, EventReservationCreated, after)
+	})
+	if err != nil && errors.Is(err, repoerrs.ErrBranchDoesNotExists) {
+		rr.logger.Debugf("reservation branch not found: %v", err)
 		return err
 	}
-	rows, err := result.RowsAffected()
 	if err != nil {
-		rr.logger.Errorf("error inserting reservation: %v", err)
+		rr.logger.Debugf("error inserting reservation: %v", err)
 		return err
 	}
-	if rows != 1 {
-		rr.logger.Errorf("error inserting reservation: %d rows affected", rows)
-		return errors.New("reservationRepo.Create: expected 1 row affected")
-	}
 
-	rr.logger.Infof("inserted reservation with ID: %s", reservation.ID)
+	rr.logger.Debugf("inserted reservation with ID: %s", reservation.ID)
 
 	return nil
 }
 
-func (rr *ReservationRepo) GetByReaderAndBook(ctx context.Context, readerID, bookID uuid.UUID) ([]*models.ReservationModel, error) {
-	rr.logger.Infof("selecting reservations with readerID и bookID: %s и %s", readerID, bookID)
+func (rr *ReservationRepo) GetByReaderAndBook(ctx context.Context, readerID, bookID uuid.UUID) (_ []*models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetByReaderAndBook", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reservations with readerID и bookID: %s и %s", readerID, bookID)
 
 	query := `select 
     			id, 
@@ -74,17 +148,17 @@ func (rr *ReservationRepo) GetByReaderAndBook(ctx context.Context, readerID, boo
 			  where reader_id = $1 and book_id = $2`
 
 	var coreReservations []*repomodels.ReservationModel
-	err := rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID, bookID)
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID, bookID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reservations: %v", err)
+		rr.logger.Debugf("error selecting reservations: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) || len(coreReservations) == 0 {
-		rr.logger.Warnf("reservations with this readerID и bookID not found: %s и %s", readerID, bookID)
+		rr.logger.Debugf("reservations with this readerID и bookID not found: %s и %s", readerID, bookID)
 		return nil, errs.ErrReservationDoesNotExists
 	}
 
-	rr.logger.Infof("selected reservation with readerID и bookID: %s и %s", readerID, bookID)
+	rr.logger.Debugf("selected reservation with readerID и bookID: %s и %s", readerID, bookID)
 
 	reservations := make([]*models.ReservationModel, len(coreReservations))
 	for i, coreReservation := range coreReservations {
@@ -94,8 +168,11 @@ func (rr *ReservationRepo) GetByReaderAndBook(ctx context.Context, readerID, boo
 	return reservations, nil
 }
 
-func (rr *ReservationRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.ReservationModel, error) {
-	rr.logger.Infof("selecting reservation with ID: %s", ID)
+func (rr *ReservationRepo) GetByID(ctx context.Context, ID uuid.UUID) (_ *models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetByID", entityIDAttr(ID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reservation with ID: %s", ID)
 
 	query := `select 
     			id, 
@@ -108,24 +185,27 @@ func (rr *ReservationRepo) GetByID(ctx context.Context, ID uuid.UUID) (*models.R
 			  where id = $1`
 
 	var reservation repomodels.ReservationModel
-	err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &reservation, query, ID)
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &reservation, query, ID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reservation with ID: %v", err)
+		rr.logger.Debugf("error selecting reservation with ID: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Warnf("reservation with this ID not found: %s", ID)
+		rr.logger.Debugf("reservation with this ID not found: %s", ID)
 		return nil, errs.ErrReservationDoesNotExists
 	}
 
-	rr.logger.Infof("selected reservation with ID: %s", ID)
+	rr.logger.Debugf("selected reservation with ID: %s", ID)
 
 	return rr.convertToReservationModel(&reservation), nil
 }
 
 // GetByBookID TODO добавить в схемы (протестировано)
-func (rr *ReservationRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) ([]*models.ReservationModel, error) {
-	rr.logger.Infof("selecting reservation with bookID: %s", bookID)
+func (rr *ReservationRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) (_ []*models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetByBookID", entityIDAttr(bookID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting reservation with bookID: %s", bookID)
 
 	query := fmt.Sprintf(
 		`select 
@@ -139,17 +219,17 @@ func (rr *ReservationRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) ([
 			  	where book_id = $1 and state != '%s'`, impl.ReservationClosed,
 	)
 	var coreReservations []*repomodels.ReservationModel
-	err := rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, bookID)
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, bookID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting reservation with ID: %v", err)
+		rr.logger.Debugf("error selecting reservation with ID: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) || len(coreReservations) == 0 {
-		rr.logger.Warnf("reservation with this bookID not found: %s", bookID)
+		rr.logger.Debugf("reservation with this bookID not found: %s", bookID)
 		return nil, errs.ErrReservationDoesNotExists
 	}
 
-	rr.logger.Infof("selected reservation with bookID: %s", bookID)
+	rr.logger.Debugf("selected reservation with bookID: %s", bookID)
 
 	reservations := make([]*models.ReservationModel, len(coreReservations))
 	for i, coreReservation := range coreReservations {
@@ -159,8 +239,11 @@ func (rr *ReservationRepo) GetByBookID(ctx context.Context, bookID uuid.UUID) ([
 	return reservations, nil
 }
 
-func (rr *ReservationRepo) Update(ctx context.Context, reservation *models.ReservationModel) error {
-	rr.logger.Infof("updating reservation with ID: %s", reservation.ID)
+func (rr *ReservationRepo) Update(ctx context.Context, reservation *models.ReservationModel) (err error) {
+	ctx, end := rr.start(ctx, "Update", entityIDAttr(reservation.ID))
+	defer end(&err)
+
+	rr.logger.Debugf("updating reservation with ID: %s", reservation.ID)
 
 	query := `update bs.reservation 
 			  set reader_id = $1,
@@ -170,36 +253,91 @@ func (rr *ReservationRepo) Update(ctx context.Context, reservation *models.Reser
 			      state = $5
 			  where id = $6`
 
-	result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
-		ctx, query,
-		reservation.ReaderID,
-		reservation.BookID,
-		reservation.IssueDate,
-		reservation.ReturnDate,
-		reservation.State,
-		reservation.ID,
-	)
-	if err != nil {
-		rr.logger.Errorf("error updating reservation with ID: %v", err)
+	err = rr.txRunner.Do(ctx, func(ctx context.Context) error {
+		before, err := rr.getForUpdate(ctx, reservation.ID)
+		if err != nil {
+			return err
+		}
+		// продление через Update (так продлевает сервисный слой) подчиняется тем же правилам, что и Renew
+		extended := reservation.ReturnDate.After(before.ReturnDate)
+		if extended {
+			if err = rr.checkRenewal(ctx, before, reservation.ReturnDate); err != nil {
+				return err
+			}
+		}
+
+		result, err := rr.getter.DefaultTrOrDB(ctx, rr.db).ExecContext(
+			ctx, query,
+			reservation.ReaderID,
+			reservation.BookID,
+			reservation.IssueDate,
+			reservation.ReturnDate,
+			reservation.State,
+			reservation.ID,
+		)
+		if err != nil {
+			return err
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		setRowsAffected(ctx, rows)
+		if rows != 1 {
+			return fmt.Errorf("reservationRepo.Update: expected 1 row affected, got %d", rows)
+		}
+		if extended {
+			if err = rr.recordRenewal(ctx, reservation.ID, before.ReturnDate, reservation.ReturnDate); err != nil {
+				return err
+			}
+		}
+
+		after := rr.convertToRepoReservationModel(reservation)
+		after.BranchID, after.CopyID = before.BranchID, before.CopyID
+		if err = rr.audit.write(ctx, AuditEntityReservation, reservation.ID, AuditOperationUpdate, before, after); err != nil {
+			return err
+		}
+
+		if after.State == impl.ReservationClosed && before.State != impl.ReservationClosed {
+			if err = rr.releaseCopy(ctx, reservation.ID); err != nil {
+				return err
+			}
+		}
+
+		eventType := EventReservationUpdated
+		if after.State == impl.ReservationExpired && before.State != impl.ReservationExpired {
+			eventType = EventReservationExpired
+		}
+
+		return rr.outbox.write(ctx, AuditEntityReservation, reservation.ID, eventType, after)
+	})
+	if err != nil && errors.Is(err, errs.ErrReservationDoesNotExists) {
+		rr.logger.Debugf("reservation with this ID not found: %s", reservation.ID)
 		return err
 	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		rr.logger.Errorf("error updating reservation with ID: %v", err)
+	if err != nil && (errors.Is(err, errs.ErrReservationIsAlreadyClosed) ||
+		errors.Is(err, errs.ErrReservationIsAlreadyExpired) ||
+		errors.Is(err, repoerrs.ErrReservationRenewalLimitReached) ||
+		errors.Is(err, repoerrs.ErrReservationExtensionTooLong) ||
+		errors.Is(err, repoerrs.ErrBookHasWaitingReaders)) {
+		rr.logger.Debugf("reservation with ID %s can't be extended: %v", reservation.ID, err)
 		return err
 	}
-	if rows != 1 {
-		rr.logger.Errorf("error updating reservation: %d rows affected", rows)
-		return errors.New("reservationRepo.Update: expected 1 row affected")
+	if err != nil {
+		rr.logger.Debugf("error updating reservation with ID: %v", err)
+		return err
 	}
 
-	rr.logger.Infof("updated reservation with ID: %s", reservation.ID)
+	rr.logger.Debugf("updated reservation with ID: %s", reservation.ID)
 
 	return nil
 }
 
-func (rr *ReservationRepo) GetExpiredByReaderID(ctx context.Context, readerID uuid.UUID) ([]*models.ReservationModel, error) {
-	rr.logger.Infof("selecting expired reservations with readerID: %s", readerID)
+func (rr *ReservationRepo) GetExpiredByReaderID(ctx context.Context, readerID uuid.UUID) (_ []*models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetExpiredByReaderID", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting expired reservations with readerID: %s", readerID)
 
 	query := fmt.Sprintf(`select 
     			  id, 
@@ -214,17 +352,17 @@ func (rr *ReservationRepo) GetExpiredByReaderID(ctx context.Context, readerID uu
 	)
 
 	var coreReservations []*repomodels.ReservationModel
-	err := rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID, time.Now())
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID, time.Now())
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting expired reservations: %v", err)
+		rr.logger.Debugf("error selecting expired reservations: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) || len(coreReservations) == 0 {
-		rr.logger.Warnf("expired reservations with this readerID not found: %s", readerID)
+		rr.logger.Debugf("expired reservations with this readerID not found: %s", readerID)
 		return nil, errs.ErrReservationDoesNotExists
 	}
 
-	rr.logger.Infof("found %d expired reservations with readerID %s", len(coreReservations), readerID)
+	rr.logger.Debugf("found %d expired reservations with readerID %s", len(coreReservations), readerID)
 
 	reservations := make([]*models.ReservationModel, len(coreReservations))
 	for i, coreReservation := range coreReservations {
@@ -234,8 +372,11 @@ func (rr *ReservationRepo) GetExpiredByReaderID(ctx context.Context, readerID uu
 	return reservations, nil
 }
 
-func (rr *ReservationRepo) GetActiveByReaderID(ctx context.Context, readerID uuid.UUID) ([]*models.ReservationModel, error) {
-	rr.logger.Infof("selecting active reservations with readerID: %s", readerID)
+func (rr *ReservationRepo) GetActiveByReaderID(ctx context.Context, readerID uuid.UUID) (_ []*models.ReservationModel, err error) {
+	ctx, end := rr.start(ctx, "GetActiveByReaderID", entityIDAttr(readerID))
+	defer end(&err)
+
+	rr.logger.Debugf("selecting active reservations with readerID: %s", readerID)
 
 	query := fmt.Sprintf(
 		`select 
@@ -251,17 +392,17 @@ func (rr *ReservationRepo) GetActiveByReaderID(ctx context.Context, readerID uui
 	)
 
 	var coreReservations []*repomodels.ReservationModel
-	err := rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID)
+	err = rr.getter.DefaultTrOrDB(ctx, rr.db).SelectContext(ctx, &coreReservations, query, readerID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		rr.logger.Errorf("error selecting active reservations: %v", err)
+		rr.logger.Debugf("error selecting active reservations: %v", err)
 		return nil, err
 	}
 	if errors.Is(err, sql.ErrNoRows) || len(coreReservations) == 0 {
-		rr.logger.Warnf("active reservations with this readerID not found: %s", readerID)
+		rr.logger.Debugf("active reservations with this readerID not found: %s", readerID)
 		return nil, errs.ErrReservationDoesNotExists
 	}
 
-	rr.logger.Infof("found %d active reservations with readerID %s", len(coreReservations), readerID)
+	rr.logger.Debugf("found %d active reservations with readerID %s", len(coreReservations), readerID)
 
 	reservations := make([]*models.ReservationModel, len(coreReservations))
 	for i, coreReservation := range coreReservations {
@@ -271,6 +412,33 @@ func (rr *ReservationRepo) GetActiveByReaderID(ctx context.Context, readerID uui
 	return reservations, nil
 }
 
+// getForUpdate блокирует строку бронирования до конца транзакции и возвращает ее состояние для журнала
+func (rr *ReservationRepo) getForUpdate(ctx context.Context, ID uuid.UUID) (*repomodels.ReservationModel, error) {
+	query := `select 
+    			id, 
+    			reader_id, 
+    			book_id, 
+    			issue_date, 
+    			return_date, 
+    			state,
+    			branch_id, 
+    			copy_id 
+			  from bs.reservation 
+			  where id = $1 
+			  for update`
+
+	var reservation repomodels.ReservationModel
+	err := rr.getter.DefaultTrOrDB(ctx, rr.db).GetContext(ctx, &reservation, query, ID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errs.ErrReservationDoesNotExists
+	}
+
+	return &reservation, nil
+}
+
 func (rr *ReservationRepo) convertToReservationModel(reservation *repomodels.ReservationModel) *models.ReservationModel {
 	return &models.ReservationModel{
 		ID:         reservation.ID,
@@ -281,3 +449,14 @@ func (rr *ReservationRepo) convertToReservationModel(reservation *repomodels.Res
 		State:      reservation.State,
 	}
 }
+
+func (rr *ReservationRepo) convertToRepoReservationModel(reservation *models.ReservationModel) *repomodels.ReservationModel {
+	return &repomodels.ReservationModel{
+		ID:         reservation.ID,
+		ReaderID:   reservation.ReaderID,
+		BookID:     reservation.BookID,
+		IssueDate:  reservation.IssueDate,
+		ReturnDate: reservation.ReturnDate,
+		State:      reservation.State,
+	}
+}
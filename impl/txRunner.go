@@ -0,0 +1,200 @@
+package impl
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	trmsql "github.com/avito-tech/go-transaction-manager/drivers/sql/v2"
+	trmsqlx "github.com/avito-tech/go-transaction-manager/drivers/sqlx/v2"
+	"github.com/avito-tech/go-transaction-manager/trm/v2"
+	trmcontext "github.com/avito-tech/go-transaction-manager/trm/v2/context"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/manager"
+	"github.com/avito-tech/go-transaction-manager/trm/v2/settings"
+	"github.com/jmoiron/sqlx"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTxMaxAttempts      = 5
+	DefaultTxBaseBackoff      = 10 * time.Millisecond
+	DefaultTxMaxBackoff       = 500 * time.Millisecond
+	DefaultTxRetryBudgetRatio = 0.1
+	DefaultTxRetryBudgetMax   = 10
+)
+
+// причины повтора для метки reason
+const (
+	TxRetrySerialization = "serialization"
+	TxRetryDeadlock      = "deadlock"
+	TxRetryConnection    = "connection"
+)
+
+type TxRunnerConfig struct {
+	Isolation   sql.IsolationLevel // уровень изоляции для Do; sql.LevelDefault — уровень по умолчанию базы
+	MaxAttempts int                // сколько раз всего выполняется транзакция, включая первый
+	BaseBackoff time.Duration      // верхняя граница первой паузы, удваивается с каждой попыткой
+	MaxBackoff  time.Duration      // потолок паузы между попытками
+	// бюджет повторов: каждая транзакция пополняет его на BudgetRatio, каждый повтор тратит 1.
+	// Пока бюджет пуст, ошибки возвращаются сразу, чтобы повторы не добивали перегруженную базу
+	BudgetRatio float64
+	BudgetMax   float64
+}
+
+// TxRunner выполняет функцию в транзакции и повторяет ее целиком при ошибках сериализации,
+// взаимоблокировках и обрывах соединения.
+// Внутри уже открытой транзакции повторов нет: откатывается вся внешняя транзакция,
+// и повторять ее должен тот, кто ее открыл
+type TxRunner struct {
+	trManager *manager.Manager
+	config    TxRunnerConfig
+	budget    *retryBudget
+	metrics   *Metrics
+	logger    Logger
+}
+
+func NewTxRunner(db *sqlx.DB, config TxRunnerConfig, logger Logger) *TxRunner {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultTxMaxAttempts
+	}
+	if config.BaseBackoff <= 0 {
+		config.BaseBackoff = DefaultTxBaseBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultTxMaxBackoff
+	}
+	if config.BudgetRatio <= 0 {
+		config.BudgetRatio = DefaultTxRetryBudgetRatio
+	}
+	if config.BudgetMax <= 0 {
+		config.BudgetMax = DefaultTxRetryBudgetMax
+	}
+
+	return &TxRunner{
+		trManager: manager.Must(trmsqlx.NewDefaultFactory(db)),
+		config:    config,
+		budget:    &retryBudget{ratio: config.BudgetRatio, limit: config.BudgetMax, balance: config.BudgetMax},
+		logger:    logger,
+	}
+}
+
+// SetMetrics включает метрики числа попыток и повторов транзакций
+func (txr *TxRunner) SetMetrics(metrics *Metrics) {
+	txr.metrics = metrics
+}
+
+// Do выполняет fn в транзакции с уровнем изоляции из конфигурации
+func (txr *TxRunner) Do(ctx context.Context, fn func(ctx context.Context) error) error {
+	return txr.DoWithIsolation(ctx, txr.config.Isolation, fn)
+}
+
+// DoWithIsolation выполняет fn в транзакции с заданным уровнем изоляции.
+// fn может быть вызвана несколько раз, поэтому не должна иметь побочных эффектов вне транзакции
+func (txr *TxRunner) DoWithIsolation(ctx context.Context, isolation sql.IsolationLevel, fn func(ctx context.Context) error) error {
+	if trmcontext.DefaultManager.Default(ctx) != nil {
+		return txr.trManager.Do(ctx, fn)
+	}
+
+	txSettings := trmsql.MustSettings(settings.Must(), trmsql.WithTxOptions(&sql.TxOptions{Isolation: isolation}))
+
+	txr.budget.deposit()
+
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		err = txr.trManager.DoWithSettings(ctx, txSettings, fn)
+
+		reason, retryable := txRetryReason(err)
+		if !retryable || attempt == txr.config.MaxAttempts {
+			break
+		}
+		if !txr.budget.withdraw() {
+			txr.logger.Warnf("transaction retry budget exhausted, giving up after %d attempts: %v", attempt, err)
+			txr.metrics.observeTxRetryDropped()
+			break
+		}
+
+		txr.logger.Debugf("retrying transaction after %s error (attempt %d): %v", reason, attempt, err)
+		txr.metrics.observeTxRetry(reason)
+
+		if err = txr.sleep(ctx, attempt); err != nil {
+			break
+		}
+	}
+
+	txr.metrics.observeTxAttempts(attempt)
+
+	return err
+}
+
+// DoOnce выполняет fn в транзакции без повторов. Нужна для функций, которые нельзя
+// безопасно вызвать второй раз, например читающих входной поток
+func (txr *TxRunner) DoOnce(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := txr.trManager.Do(ctx, fn)
+
+	txr.metrics.observeTxAttempts(1)
+
+	return err
+}
+
+// sleep ждет случайное время до base * 2^(attempt-1), но не больше MaxBackoff (full jitter)
+func (txr *TxRunner) sleep(ctx context.Context, attempt int) error {
+	backoff := txr.config.BaseBackoff << (attempt - 1)
+	if backoff <= 0 || backoff > txr.config.MaxBackoff {
+		backoff = txr.config.MaxBackoff
+	}
+
+	timer := time.NewTimer(rand.N(backoff) + 1)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// txRetryReason решает, можно ли повторить транзакцию после err.
+// Обрыв соединения на коммите не повторяется: неизвестно, успела ли транзакция зафиксироваться
+func txRetryReason(err error) (string, bool) {
+	switch {
+	case err == nil:
+		return "", false
+	case pgErrorCode(err) == pgDeadlockDetected:
+		return TxRetryDeadlock, true
+	case pgErrorCode(err) == pgSerializationFailure:
+		return TxRetrySerialization, true
+	case isConnectionError(err) && !errors.Is(err, trm.ErrCommit):
+		return TxRetryConnection, true
+	default:
+		return "", false
+	}
+}
+
+type retryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	limit   float64
+	balance float64
+}
+
+func (rb *retryBudget) deposit() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	rb.balance = min(rb.balance+rb.ratio, rb.limit)
+}
+
+func (rb *retryBudget) withdraw() bool {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	if rb.balance < 1 {
+		return false
+	}
+	rb.balance--
+
+	return true
+}
@@ -0,0 +1,217 @@
+package impl
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	repoerrs "github.com/nikitalystsev/BookSmart-repo-postgres/core/errs"
+	"math/big"
+	"time"
+)
+
+const (
+	CodePurposePhoneVerification = "phone_verification"
+	CodePurposePasswordReset     = "password_reset"
+)
+
+const (
+	DefaultVerificationCodeLength   = 6
+	DefaultVerificationCodeTTL      = 5 * time.Minute
+	DefaultVerificationCodeAttempts = 3
+	DefaultVerificationCodeCooldown = time.Minute
+)
+
+type VerificationCodeConfig struct {
+	CodeLength     int
+	TTL            time.Duration
+	MaxAttempts    int
+	ResendCooldown time.Duration
+	// Secret — ключ HMAC для хешей кодов. Без него короткий код перебирается по дампу Redis;
+	// у всех экземпляров сервиса ключ должен быть одинаковым
+	Secret []byte
+}
+
+// issueCodeScript атомарно ставит задержку повторной отправки и сохраняет новый код:
+// задержка не может появиться без кода
+var issueCodeScript = redis.NewScript(`
+if not redis.call('SET', KEYS[2], 1, 'PX', ARGV[4], 'NX') then
+	return 0
+end
+redis.call('DEL', KEYS[1])
+redis.call('HSET', KEYS[1], 'hash', ARGV[1], 'attempts_left', ARGV[2])
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+return 1
+`)
+
+// verifyCodeScript атомарно сверяет код: при совпадении код удаляется (одноразовость),
+// при несовпадении уменьшается число оставшихся попыток
+var verifyCodeScript = redis.NewScript(`
+local stored = redis.call('HGET', KEYS[1], 'hash')
+if not stored then
+	return -1
+end
+if stored == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	return 1
+end
+local left = redis.call('HINCRBY', KEYS[1], 'attempts_left', -1)
+if left <= 0 then
+	redis.call('DEL', KEYS[1])
+	return -2
+end
+return 0
+`)
+
+const (
+	verifyCodeNotFound         = -1
+	verifyCodeAttemptsExceeded = -2
+	verifyCodeInvalid          = 0
+	verifyCodeOk               = 1
+)
+
+type VerificationCodeRepo struct {
+	instrumentation
+
+	client *redis.Client
+	config VerificationCodeConfig
+}
+
+func NewVerificationCodeRepo(client *redis.Client, config VerificationCodeConfig, logger Logger) (*VerificationCodeRepo, error) {
+	if len(config.Secret) == 0 {
+		return nil, repoerrs.ErrVerificationCodeSecretIsEmpty
+	}
+	if config.CodeLength <= 0 {
+		config.CodeLength = DefaultVerificationCodeLength
+	}
+	if config.TTL <= 0 {
+		config.TTL = DefaultVerificationCodeTTL
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = DefaultVerificationCodeAttempts
+	}
+	if config.ResendCooldown <= 0 {
+		config.ResendCooldown = DefaultVerificationCodeCooldown
+	}
+
+	return &VerificationCodeRepo{
+		instrumentation: instrumentation{repo: "verification_code", dbSystem: dbSystemRedis, logger: logger},
+		client:          client,
+		config:          config,
+	}, nil
+}
+
+// Issue генерирует новый код для цели (например, номера телефона) и заменяет им предыдущий.
+// В Redis хранится только HMAC кода
+func (vcr *VerificationCodeRepo) Issue(ctx context.Context, purpose, target string) (_ string, err error) {
+	ctx, end := vcr.start(ctx, "Issue")
+	defer end(&err)
+
+	vcr.logger.Debugf("issuing %s code", purpose)
+
+	code, err := vcr.generateCode()
+	if err != nil {
+		vcr.logger.Debugf("error generating code: %v", err)
+		return "", err
+	}
+
+	keys := []string{vcr.codeKey(purpose, target), vcr.cooldownKey(purpose, target)}
+	issued, err := issueCodeScript.Run(ctx, vcr.client, keys,
+		vcr.hashCode(code),
+		vcr.config.MaxAttempts,
+		vcr.config.TTL.Milliseconds(),
+		vcr.config.ResendCooldown.Milliseconds(),
+	).Int()
+	if err != nil {
+		vcr.logger.Debugf("error saving code: %v", err)
+		return "", err
+	}
+	if issued == 0 {
+		vcr.logger.Debugf("%s code resend is too early", purpose)
+		return "", repoerrs.ErrVerificationCodeResendTooEarly
+	}
+
+	vcr.logger.Debugf("issued %s code", purpose)
+
+	return code, nil
+}
+
+// Verify проверяет и одновременно погашает код
+func (vcr *VerificationCodeRepo) Verify(ctx context.Context, purpose, target, code string) (err error) {
+	ctx, end := vcr.start(ctx, "Verify")
+	defer end(&err)
+
+	vcr.logger.Debugf("verifying %s code", purpose)
+
+	result, err := verifyCodeScript.Run(ctx, vcr.client, []string{vcr.codeKey(purpose, target)}, vcr.hashCode(code)).Int()
+	if err != nil {
+		vcr.logger.Debugf("error verifying code: %v", err)
+		return err
+	}
+
+	switch result {
+	case verifyCodeOk:
+		vcr.logger.Debugf("verified %s code", purpose)
+		return nil
+	case verifyCodeInvalid:
+		vcr.logger.Debugf("invalid %s code", purpose)
+		return repoerrs.ErrVerificationCodeIsInvalid
+	case verifyCodeAttemptsExceeded:
+		vcr.logger.Debugf("%s code attempts exceeded", purpose)
+		return repoerrs.ErrVerificationCodeAttemptsExceeded
+	case verifyCodeNotFound:
+		vcr.logger.Debugf("%s code not found", purpose)
+		return repoerrs.ErrVerificationCodeDoesNotExists
+	}
+
+	vcr.logger.Debugf("unexpected verify script result: %d", result)
+
+	return errors.New("verificationCodeRepo.Verify: unexpected script result")
+}
+
+// GetResendCooldown возвращает время, через которое можно будет запросить новый код
+func (vcr *VerificationCodeRepo) GetResendCooldown(ctx context.Context, purpose, target string) (_ time.Duration, err error) {
+	ctx, end := vcr.start(ctx, "GetResendCooldown")
+	defer end(&err)
+
+	ttl, err := vcr.client.PTTL(ctx, vcr.cooldownKey(purpose, target)).Result()
+	if err != nil {
+		vcr.logger.Debugf("error getting resend cooldown: %v", err)
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+func (vcr *VerificationCodeRepo) generateCode() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(vcr.config.CodeLength)), nil)
+
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%0*d", vcr.config.CodeLength, n), nil
+}
+
+func (vcr *VerificationCodeRepo) hashCode(code string) string {
+	mac := hmac.New(sha256.New, vcr.config.Secret)
+	mac.Write([]byte(code))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (vcr *VerificationCodeRepo) codeKey(purpose, target string) string {
+	return fmt.Sprintf("code:%s:%s", purpose, target)
+}
+
+func (vcr *VerificationCodeRepo) cooldownKey(purpose, target string) string {
+	return fmt.Sprintf("code:%s:%s:cooldown", purpose, target)
+}